@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	"github.com/hiepnv90/elastic-lm/pkg/elasticlm"
+	"go.uber.org/zap"
+)
+
+// runFlattenCommand cancels the open orders and closes all shorts of the stored positions, while the program isn't
+// running, e.g. `elastic-lm --config elastic-lm.yaml flatten --timeout 1m`. A running program is flattened with
+// SIGUSR1 instead, as it would keep hedging with the hedged amounts it holds.
+func runFlattenCommand(elasticLM *elasticlm.ElasticLM, args []string) {
+	fs := flag.NewFlagSet("flatten", flag.ExitOnError)
+	timeout := fs.Duration("timeout", time.Minute, "Time to wait for the closing orders to finish")
+	_ = fs.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	err := elasticLM.Flatten(ctx)
+	if err != nil {
+		zap.S().Fatalw("Fail to flatten shorts", "error", err)
+	}
+	zap.S().Infow("Flatten all shorts")
+}
@@ -6,15 +6,17 @@ import (
 	"os"
 	"os/signal"
 	"strings"
-	"time"
+	"syscall"
 
 	"github.com/glebarez/sqlite"
 	"github.com/hiepnv90/elastic-lm/internal/app"
 	"github.com/hiepnv90/elastic-lm/internal/config"
+	"github.com/hiepnv90/elastic-lm/pkg/alert"
 	"github.com/hiepnv90/elastic-lm/pkg/binance"
 	"github.com/hiepnv90/elastic-lm/pkg/elasticlm"
 	"github.com/hiepnv90/elastic-lm/pkg/graphql"
 	"github.com/hiepnv90/elastic-lm/pkg/models"
+	"github.com/hiepnv90/elastic-lm/pkg/simulator"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
@@ -43,30 +45,46 @@ func main() {
 	undo := zap.ReplaceGlobals(logger)
 	defer undo()
 
-	zap.S().Infow("Create new client for GraphQL", "baseURL", cfg.GraphQL)
-	client = graphql.New(cfg.GraphQL, nil)
+	// Subcommands read the stored positions and orders, so they never reset the database.
+	switch flag.Arg(0) {
+	case "":
+	case "orders":
+		runOrdersCommand(setupDB(cfg.SQLite, false), flag.Args()[1:])
+		return
+	case "plan":
+		runPlanCommand(setupElasticLM(setupDB(cfg.SQLite, false), setupExchange(cfg)), flag.Args()[1:])
+		return
+	case "flatten":
+		runFlattenCommand(setupElasticLM(setupDB(cfg.SQLite, false), setupExchange(cfg)), flag.Args()[1:])
+		return
+	case "resume":
+		runResumeCommand(setupDB(cfg.SQLite, false))
+		return
+	default:
+		zap.S().Fatalw("Unknown command", "command", flag.Arg(0))
+	}
 
-	zap.S().Infow("Create new binance's client")
-	bclient := setupBinanceClient(cfg.Binance)
+	zap.S().Infow("Create new exchange's client", "paperTrading", cfg.PaperTrading.Enabled)
+	bclient := setupExchange(cfg)
 
 	zap.S().Infow("Setup database connection", "cfg", cfg.SQLite)
-	db := setupDB(cfg.SQLite)
+	db := setupDB(cfg.SQLite, cfg.SQLite.Reset)
 
-	zap.S().Infow("Create new ElasticLM instance", "positions", cfg.Positions)
-	tokenInstrumentMap := make(map[string]string)
-	for _, tokenInstrument := range cfg.Binance.Symbols {
-		token := strings.ToUpper(tokenInstrument.Token)
-		instrument := strings.ToUpper(tokenInstrument.Instrument)
-		tokenInstrumentMap[token] = instrument
-	}
-	elasticLM := elasticlm.New(
-		db, client, bclient, cfg.Positions, cfg.AmountThresholdBps,
-		cfg.Binance.QuoteCurrency, time.Second, tokenInstrumentMap,
-	)
+	elasticLM := setupElasticLM(db, bclient)
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
 
+	// SIGUSR1 is the kill switch, which stops hedging and closes all shorts without stopping the program.
+	flattenC := make(chan os.Signal, 1)
+	signal.Notify(flattenC, syscall.SIGUSR1)
+	go func() {
+		for range flattenC {
+			zap.S().Warnw("Receive kill switch signal")
+			elasticLM.RequestFlatten()
+		}
+	}()
+
 	err = elasticLM.Run(ctx)
 	if err != nil {
 		zap.S().Fatalw("Fail to monitor positions", "error", err)
@@ -74,6 +92,121 @@ func main() {
 	zap.S().Infow("Stop application!")
 }
 
+func setupElasticLM(db *gorm.DB, bclient elasticlm.Exchange) *elasticlm.ElasticLM {
+	zap.S().Infow("Create new client for GraphQL", "baseURL", cfg.GraphQL)
+	client = graphql.New(cfg.GraphQL, nil)
+
+	zap.S().Infow("Create new ElasticLM instance", "positions", cfg.Positions)
+	tokenInstrumentMap := make(map[string]string)
+	for _, tokenInstrument := range cfg.Binance.Symbols {
+		token := strings.ToUpper(tokenInstrument.Token)
+		instrument := strings.ToUpper(tokenInstrument.Instrument)
+		tokenInstrumentMap[token] = instrument
+	}
+	hedgeRatios := make(map[string]float64)
+	hedgeModes := make(map[string]elasticlm.HedgeMode)
+	for positionID, options := range cfg.PositionOptions {
+		if options.HedgeRatio != nil {
+			hedgeRatios[positionID] = *options.HedgeRatio
+		}
+		if options.HedgeMode != "" {
+			hedgeModes[positionID] = elasticlm.HedgeMode(strings.ToLower(options.HedgeMode))
+		}
+	}
+	symbolMaxNotionals := make(map[string]float64)
+	for symbol, maxNotional := range cfg.Limits.SymbolMaxNotionals {
+		symbolMaxNotionals[strings.ToUpper(symbol)] = maxNotional
+	}
+	symbolLeverages := make(map[string]int)
+	for symbol, leverage := range cfg.Binance.SymbolLeverages {
+		symbolLeverages[strings.ToUpper(symbol)] = leverage
+	}
+	symbolMarginTypes := make(map[string]elasticlm.MarginType)
+	for symbol, marginType := range cfg.Binance.SymbolMarginTypes {
+		symbolMarginTypes[strings.ToUpper(symbol)] = elasticlm.MarginType(strings.ToLower(marginType))
+	}
+	return elasticlm.New(
+		db, client, bclient, cfg.Positions, cfg.AmountThresholdBps,
+		cfg.Binance.QuoteCurrency, cfg.Interval, tokenInstrumentMap,
+		elasticlm.Options{
+			Reconcile: elasticlm.ReconcileOptions{
+				Mode:     elasticlm.ReconcileMode(strings.ToLower(cfg.Reconcile.Mode)),
+				Interval: cfg.Reconcile.Interval,
+			},
+			Rebalance: elasticlm.RebalanceOptions{
+				ThresholdNotional: cfg.AmountThresholdNotional,
+				TargetBps:         cfg.AmountTargetBps,
+				TargetNotional:    cfg.AmountTargetNotional,
+				OrderCooldown:     cfg.OrderCooldown,
+			},
+			TWAP: elasticlm.TWAPOptions{
+				ThresholdNotional: cfg.TWAP.ThresholdNotional,
+				Duration:          cfg.TWAP.Duration,
+				Slices:            cfg.TWAP.Slices,
+			},
+			Execution: elasticlm.ExecutionOptions{
+				Style:         elasticlm.ExecutionStyle(strings.ToLower(cfg.Execution.Style)),
+				MakerTimeout:  cfg.Execution.MakerTimeout,
+				MakerFallback: cfg.Execution.MakerFallback,
+			},
+			Slippage: elasticlm.SlippageOptions{
+				MaxBps: cfg.Slippage.MaxBps,
+				Action: elasticlm.SlippageAction(strings.ToLower(cfg.Slippage.Action)),
+			},
+			Cost: elasticlm.CostOptions{
+				Horizon:            cfg.Cost.Horizon,
+				FeeBps:             cfg.Cost.FeeBps,
+				VolatilityHalfLife: cfg.Cost.VolatilityHalfLife,
+				MinRatio:           cfg.Cost.MinRatio,
+			},
+			PriceEstimate: elasticlm.PriceEstimateOptions{
+				Enabled:         cfg.PriceEstimate.Enabled,
+				MaxDeviationBps: cfg.PriceEstimate.MaxDeviationBps,
+				MaxLag:          cfg.PriceEstimate.MaxLag,
+			},
+			Polling: elasticlm.PollingOptions{
+				MinInterval:        cfg.Polling.MinInterval,
+				MaxInterval:        cfg.Polling.MaxInterval,
+				EdgeBps:            cfg.Polling.EdgeBps,
+				VolatilityBps:      cfg.Polling.VolatilityBps,
+				VolatilityHalfLife: cfg.Polling.VolatilityHalfLife,
+				MaxBackoff:         cfg.Polling.MaxBackoff,
+			},
+			Limits: elasticlm.LimitOptions{
+				MaxOrderNotional:   cfg.Limits.MaxOrderNotional,
+				MaxSymbolNotional:  cfg.Limits.MaxSymbolNotional,
+				SymbolMaxNotionals: symbolMaxNotionals,
+				MaxTotalNotional:   cfg.Limits.MaxTotalNotional,
+				Action:             elasticlm.LimitAction(strings.ToLower(cfg.Limits.Action)),
+			},
+			Alerter:       setupAlerter(cfg.Alert),
+			FlattenOnExit: cfg.FlattenOnExit,
+			Margin: elasticlm.MarginOptions{
+				Interval:            cfg.Margin.Interval,
+				AlertRatio:          cfg.Margin.AlertRatio,
+				LiquidationAlertBps: cfg.Margin.LiquidationAlertBps,
+				TopUpRatio:          cfg.Margin.TopUpRatio,
+				TopUpAmount:         cfg.Margin.TopUpAmount,
+				ReduceRatio:         cfg.Margin.ReduceRatio,
+				ReduceFraction:      cfg.Margin.ReduceFraction,
+			},
+			Leverage: elasticlm.LeverageOptions{
+				Leverage:          cfg.Binance.Leverage,
+				MarginType:        elasticlm.MarginType(strings.ToLower(cfg.Binance.MarginType)),
+				SymbolLeverages:   symbolLeverages,
+				SymbolMarginTypes: symbolMarginTypes,
+			},
+			HedgeMode:  elasticlm.HedgeMode(strings.ToLower(cfg.HedgeMode)),
+			HedgeModes: hedgeModes,
+			Ladder: elasticlm.LadderOptions{
+				Rungs:       cfg.Ladder.Rungs,
+				SlippageBps: cfg.Ladder.SlippageBps,
+			},
+			HedgeRatios: hedgeRatios,
+		},
+	)
+}
+
 func setupLogger(debug bool) *zap.Logger {
 	logLevel := zap.InfoLevel
 	if debug {
@@ -83,20 +216,61 @@ func setupLogger(debug bool) *zap.Logger {
 	return app.NewLogger(logLevel)
 }
 
-func setupBinanceClient(cfg config.Binance) *binance.Client {
-	if cfg.APIKey == "" || cfg.SecretKey == "" {
+func setupExchange(cfg *config.Config) elasticlm.Exchange {
+	if cfg.PaperTrading.Enabled {
+		return setupSimulator(cfg.PaperTrading, cfg.Binance)
+	}
+
+	if cfg.Binance.APIKey == "" || cfg.Binance.SecretKey == "" {
 		return nil
 	}
-	return binance.New(cfg.APIKey, cfg.SecretKey)
+	return binance.New(cfg.Binance.APIKey, cfg.Binance.SecretKey)
+}
+
+func setupSimulator(cfg config.PaperTrading, bcfg config.Binance) *simulator.Exchange {
+	staticPrices := make(simulator.StaticPrices)
+	for symbol, price := range cfg.Prices {
+		staticPrices[strings.ToUpper(symbol)] = price
+	}
+
+	var prices simulator.PriceSource = staticPrices
+	if cfg.ReplayFile != "" {
+		replayPrices, err := simulator.LoadReplayPrices(cfg.ReplayFile, cfg.ReplaySpeed)
+		if err != nil {
+			zap.S().Fatalw("Fail to load replay prices", "file", cfg.ReplayFile, "error", err)
+		}
+		prices = replayPrices
+	}
+
+	// Exchange information is public, so the symbols' filters are loaded from Binance without keys.
+	exchange := simulator.New(
+		binance.New("", ""), prices, cfg.FeeBps,
+		map[string]float64{bcfg.QuoteCurrency: cfg.Balance},
+	)
+	exchange.SetDepth(cfg.Depth)
+	exchange.SetSpotBalance(bcfg.QuoteCurrency, cfg.SpotBalance)
+	err := exchange.SetDualSidePosition(cfg.HedgeMode)
+	if err != nil {
+		zap.S().Fatalw("Fail to set position mode of simulator", "hedgeMode", cfg.HedgeMode, "error", err)
+	}
+	return exchange
+}
+
+func setupAlerter(cfg config.Alert) alert.Alerter {
+	var alerter alert.Alerter = alert.NewLogger()
+	if cfg.WebhookURL != "" {
+		alerter = alert.Multi{alerter, alert.NewWebhook(cfg.WebhookURL, nil)}
+	}
+	return alert.NewThrottled(alerter, cfg.Interval)
 }
 
-func setupDB(cfg config.SQLite) *gorm.DB {
+func setupDB(cfg config.SQLite, reset bool) *gorm.DB {
 	db, err := gorm.Open(sqlite.Open(cfg.DBName), &gorm.Config{})
 	if err != nil {
 		zap.S().Fatalw("Fail to open database connection", "cfg", cfg, "error", err)
 	}
 
-	err = models.AutoMigrate(db, cfg.Reset)
+	err = models.AutoMigrate(db, reset)
 	if err != nil {
 		zap.S().Fatalw("Fail to auto migrate database", "error", err)
 	}
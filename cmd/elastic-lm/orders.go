@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hiepnv90/elastic-lm/pkg/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// runOrdersCommand prints the order journal, e.g. `elastic-lm --config elastic-lm.yaml orders --position 1239`.
+func runOrdersCommand(db *gorm.DB, args []string) {
+	fs := flag.NewFlagSet("orders", flag.ExitOnError)
+	positionID := fs.String("position", "", "Only show orders of the position")
+	symbol := fs.String("symbol", "", "Only show orders of the Binance symbol")
+	limit := fs.Int("limit", 50, "Maximum number of orders to show, 0 for all")
+	_ = fs.Parse(args)
+
+	orders, err := models.ListOrders(db, *positionID, strings.ToUpper(*symbol), *limit)
+	if err != nil {
+		zap.S().Fatalw("Fail to list orders from journal", "error", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tPOSITION\tSYMBOL\tSIDE\tTYPE\tQUANTITY\tEXECUTED\tAVG PRICE\tSTATUS\tCLIENT ORDER ID\tERROR")
+	for _, order := range orders {
+		fmt.Fprintf(
+			w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			order.CreatedAt.Format(time.RFC3339), order.PositionID, order.Symbol, order.Side, order.Type,
+			order.Quantity, order.ExecutedQuantity, order.AvgPrice, order.Status, order.ClientOrderID, order.Error,
+		)
+	}
+	_ = w.Flush()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+	"github.com/hiepnv90/elastic-lm/pkg/elasticlm"
+	"go.uber.org/zap"
+)
+
+// runPlanCommand prints the hedge plans of positions without executing them,
+// e.g. `elastic-lm --config elastic-lm.yaml plan --position 1239`.
+func runPlanCommand(elasticLM *elasticlm.ElasticLM, args []string) {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	positionID := fs.String("position", "", "Only show the plan of the position")
+	_ = fs.Parse(args)
+
+	plans, err := elasticLM.Plan(context.Background())
+	if err != nil {
+		zap.S().Fatalw("Fail to plan hedges of positions", "error", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "POSITION\tSTRATEGY\tSYMBOL\tTOKEN\tHEDGED\tTARGET\tDELTA\tREASON")
+	for _, plan := range plans {
+		if *positionID != "" && plan.PositionID != *positionID {
+			continue
+		}
+
+		if len(plan.Targets) == 0 {
+			fmt.Fprintf(w, "%s\t%s\t-\t-\t-\t-\t-\t%s\n", plan.PositionID, plan.Strategy, plan.Reason)
+			continue
+		}
+
+		for _, target := range plan.Targets {
+			decimals := target.Token.Decimals
+			fmt.Fprintf(
+				w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				plan.PositionID, plan.Strategy, target.Symbol, target.Token.Symbol,
+				common.FormatAmount(target.HedgedAmount, decimals, 5),
+				common.FormatAmount(target.Token.Amount, decimals, 5),
+				common.FormatAmount(target.Delta, decimals, 5),
+				target.Reason,
+			)
+		}
+	}
+	_ = w.Flush()
+}
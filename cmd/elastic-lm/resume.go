@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/hiepnv90/elastic-lm/pkg/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// runResumeCommand clears the halt which flattening all shorts leaves, and restores the hedge ratios which are scaled
+// down by reducing hedges on margin, so the next run fully hedges the positions again,
+// e.g. `elastic-lm --config elastic-lm.yaml resume`.
+func runResumeCommand(db *gorm.DB) {
+	for _, key := range []string{models.SettingHalted, models.SettingMarginHedgeScale} {
+		value, err := models.GetSetting(db, key)
+		if err != nil {
+			zap.S().Fatalw("Fail to load setting", "key", key, "error", err)
+		}
+		if value == "" {
+			continue
+		}
+
+		err = models.SetSetting(db, key, "")
+		if err != nil {
+			zap.S().Fatalw("Fail to clear setting", "key", key, "error", err)
+		}
+		zap.S().Infow("Clear setting", "key", key, "value", value)
+	}
+	zap.S().Infow("Hedging resumes on the next run")
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"io"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -13,10 +14,14 @@ type TokenInstrument struct {
 }
 
 type Binance struct {
-	APIKey        string            `yaml:"api_key"`
-	SecretKey     string            `yaml:"secret_key"`
-	QuoteCurrency string            `yaml:"quote_currency"`
-	Symbols       []TokenInstrument `yaml:"symbols"`
+	APIKey            string            `yaml:"api_key"`
+	SecretKey         string            `yaml:"secret_key"`
+	QuoteCurrency     string            `yaml:"quote_currency"`
+	Symbols           []TokenInstrument `yaml:"symbols"`
+	Leverage          int               `yaml:"leverage"`
+	MarginType        string            `yaml:"margin_type"`
+	SymbolLeverages   map[string]int    `yaml:"symbol_leverages"`
+	SymbolMarginTypes map[string]string `yaml:"symbol_margin_types"`
 }
 
 type SQLite struct {
@@ -24,13 +29,122 @@ type SQLite struct {
 	Reset  bool   `yaml:"reset"`
 }
 
+type PaperTrading struct {
+	Enabled     bool               `yaml:"enabled"`
+	FeeBps      float64            `yaml:"fee_bps"`
+	Balance     float64            `yaml:"balance"`
+	Prices      map[string]float64 `yaml:"prices"`
+	ReplayFile  string             `yaml:"replay_file"`
+	ReplaySpeed float64            `yaml:"replay_speed"`
+	Depth       float64            `yaml:"depth"`
+	SpotBalance float64            `yaml:"spot_balance"`
+	HedgeMode   bool               `yaml:"hedge_mode"`
+}
+
+type Reconcile struct {
+	Mode     string        `yaml:"mode"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+type Ladder struct {
+	Rungs       int     `yaml:"rungs"`
+	SlippageBps float64 `yaml:"slippage_bps"`
+}
+
+type TWAP struct {
+	ThresholdNotional float64       `yaml:"threshold_notional"`
+	Duration          time.Duration `yaml:"duration"`
+	Slices            int           `yaml:"slices"`
+}
+
+type Execution struct {
+	Style         string        `yaml:"style"`
+	MakerTimeout  time.Duration `yaml:"maker_timeout"`
+	MakerFallback bool          `yaml:"maker_fallback"`
+}
+
+type Slippage struct {
+	MaxBps float64 `yaml:"max_bps"`
+	Action string  `yaml:"action"`
+}
+
+type Cost struct {
+	Horizon            time.Duration `yaml:"horizon"`
+	FeeBps             float64       `yaml:"fee_bps"`
+	VolatilityHalfLife time.Duration `yaml:"volatility_half_life"`
+	MinRatio           float64       `yaml:"min_ratio"`
+}
+
+type PriceEstimate struct {
+	Enabled         bool          `yaml:"enabled"`
+	MaxDeviationBps float64       `yaml:"max_deviation_bps"`
+	MaxLag          time.Duration `yaml:"max_lag"`
+}
+
+type Polling struct {
+	MinInterval        time.Duration `yaml:"min_interval"`
+	MaxInterval        time.Duration `yaml:"max_interval"`
+	EdgeBps            float64       `yaml:"edge_bps"`
+	VolatilityBps      float64       `yaml:"volatility_bps"`
+	VolatilityHalfLife time.Duration `yaml:"volatility_half_life"`
+	MaxBackoff         time.Duration `yaml:"max_backoff"`
+}
+
+type Limits struct {
+	MaxOrderNotional   float64            `yaml:"max_order_notional"`
+	MaxSymbolNotional  float64            `yaml:"max_symbol_notional"`
+	SymbolMaxNotionals map[string]float64 `yaml:"symbol_max_notionals"`
+	MaxTotalNotional   float64            `yaml:"max_total_notional"`
+	Action             string             `yaml:"action"`
+}
+
+type Alert struct {
+	WebhookURL string        `yaml:"webhook_url"`
+	Interval   time.Duration `yaml:"interval"`
+}
+
+type Margin struct {
+	Interval            time.Duration `yaml:"interval"`
+	AlertRatio          float64       `yaml:"alert_ratio"`
+	LiquidationAlertBps float64       `yaml:"liquidation_alert_bps"`
+	TopUpRatio          float64       `yaml:"top_up_ratio"`
+	TopUpAmount         float64       `yaml:"top_up_amount"`
+	ReduceRatio         float64       `yaml:"reduce_ratio"`
+	ReduceFraction      float64       `yaml:"reduce_fraction"`
+}
+
+type PositionOptions struct {
+	HedgeRatio *float64 `yaml:"hedge_ratio"`
+	HedgeMode  string   `yaml:"hedge_mode"`
+}
+
 type Config struct {
-	Debug              bool     `yaml:"debug"`
-	GraphQL            string   `yaml:"graphql"`
-	Positions          []string `yaml:"positions"`
-	Binance            Binance  `yaml:"binance"`
-	AmountThresholdBps int      `yaml:"amount_threshold_bps"`
-	SQLite             SQLite   `yaml:"sqlite"`
+	Debug                   bool                       `yaml:"debug"`
+	GraphQL                 string                     `yaml:"graphql"`
+	Positions               []string                   `yaml:"positions"`
+	PositionOptions         map[string]PositionOptions `yaml:"position_options"`
+	Binance                 Binance                    `yaml:"binance"`
+	AmountThresholdBps      int                        `yaml:"amount_threshold_bps"`
+	AmountThresholdNotional float64                    `yaml:"amount_threshold_notional"`
+	HedgeMode               string                     `yaml:"hedge_mode"`
+	Ladder                  Ladder                     `yaml:"ladder"`
+	AmountTargetBps         int                        `yaml:"amount_target_bps"`
+	AmountTargetNotional    float64                    `yaml:"amount_target_notional"`
+	OrderCooldown           time.Duration              `yaml:"order_cooldown"`
+	TWAP                    TWAP                       `yaml:"twap"`
+	Execution               Execution                  `yaml:"execution"`
+	Slippage                Slippage                   `yaml:"slippage"`
+	Cost                    Cost                       `yaml:"cost"`
+	PriceEstimate           PriceEstimate              `yaml:"price_estimate"`
+	Limits                  Limits                     `yaml:"limits"`
+	Alert                   Alert                      `yaml:"alert"`
+	FlattenOnExit           bool                       `yaml:"flatten_on_exit"`
+	Margin                  Margin                     `yaml:"margin"`
+	Interval                time.Duration              `yaml:"interval"`
+	Polling                 Polling                    `yaml:"polling"`
+	SQLite                  SQLite                     `yaml:"sqlite"`
+	PaperTrading            PaperTrading               `yaml:"paper_trading"`
+	Reconcile               Reconcile                  `yaml:"reconcile"`
 }
 
 func Default() *Config {
@@ -45,9 +159,64 @@ func Default() *Config {
 			Symbols:       nil,
 		},
 		AmountThresholdBps: 0,
+		HedgeMode:          "dynamic",
+		Ladder: Ladder{
+			Rungs:       10,
+			SlippageBps: 50,
+		},
+		TWAP: TWAP{
+			Duration: 10 * time.Minute,
+			Slices:   10,
+		},
+		Execution: Execution{
+			Style:         "market",
+			MakerTimeout:  30 * time.Second,
+			MakerFallback: true,
+		},
+		Slippage: Slippage{
+			Action: "delay",
+		},
+		Cost: Cost{
+			FeeBps:             4,
+			VolatilityHalfLife: time.Hour,
+			MinRatio:           1,
+		},
+		PriceEstimate: PriceEstimate{
+			MaxDeviationBps: 500,
+			MaxLag:          time.Minute,
+		},
+		Limits: Limits{
+			Action: "clip",
+		},
+		Alert: Alert{
+			Interval: 10 * time.Minute,
+		},
+		Margin: Margin{
+			Interval:            time.Minute,
+			AlertRatio:          0.5,
+			LiquidationAlertBps: 1000,
+			ReduceFraction:      0.25,
+		},
+		Interval: time.Second,
+		Polling: Polling{
+			EdgeBps:            100,
+			VolatilityBps:      100,
+			VolatilityHalfLife: time.Hour,
+			MaxBackoff:         time.Minute,
+		},
 		SQLite: SQLite{
 			DBName: "elastic-lm.db",
 		},
+		PaperTrading: PaperTrading{
+			Enabled:     false,
+			FeeBps:      4,
+			Balance:     10000,
+			ReplaySpeed: 1,
+		},
+		Reconcile: Reconcile{
+			Mode:     "report",
+			Interval: 5 * time.Minute,
+		},
 	}
 }
 
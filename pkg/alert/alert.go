@@ -0,0 +1,121 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Alerter sends alerts which need the attention of operators.
+// The key identifies the kind of an alert, e.g. the limit and symbol which an order breaks, so repeated alerts of
+// the same kind can be throttled.
+type Alerter interface {
+	Alert(ctx context.Context, key string, message string) error
+}
+
+// Logger writes alerts into the log.
+type Logger struct {
+	logger *zap.SugaredLogger
+}
+
+func NewLogger() *Logger {
+	return &Logger{logger: zap.S()}
+}
+
+func (a *Logger) Alert(_ context.Context, key string, message string) error {
+	a.logger.Warnw("Alert", "key", key, "message", message)
+	return nil
+}
+
+// Webhook posts alerts as JSON `{"text": message}` to a URL, which is accepted by Slack and compatible webhooks.
+type Webhook struct {
+	url        string
+	httpClient *http.Client
+}
+
+func NewWebhook(url string, httpClient *http.Client) *Webhook {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &Webhook{
+		url:        url,
+		httpClient: httpClient,
+	}
+}
+
+func (a *Webhook) Alert(ctx context.Context, _ string, message string) error {
+	body, err := json.Marshal(map[string]string{"text": message})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Multi sends alerts to all of its alerters, it returns the first error.
+type Multi []Alerter
+
+func (a Multi) Alert(ctx context.Context, key string, message string) error {
+	var firstErr error
+	for _, alerter := range a {
+		err := alerter.Alert(ctx, key, message)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Throttled drops alerts of a key which are sent within the interval since the last alert of the key.
+type Throttled struct {
+	alerter  Alerter
+	interval time.Duration
+
+	mu       sync.Mutex
+	lastSent map[string]time.Time
+	now      func() time.Time
+}
+
+func NewThrottled(alerter Alerter, interval time.Duration) *Throttled {
+	return &Throttled{
+		alerter:  alerter,
+		interval: interval,
+		lastSent: make(map[string]time.Time),
+		now:      time.Now,
+	}
+}
+
+func (a *Throttled) Alert(ctx context.Context, key string, message string) error {
+	a.mu.Lock()
+	now := a.now()
+	if lastSent, ok := a.lastSent[key]; ok && now.Sub(lastSent) < a.interval {
+		a.mu.Unlock()
+		return nil
+	}
+	a.lastSent[key] = now
+	a.mu.Unlock()
+
+	return a.alerter.Alert(ctx, key, message)
+}
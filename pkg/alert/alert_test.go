@@ -0,0 +1,68 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recorder struct {
+	messages []string
+}
+
+func (r *recorder) Alert(_ context.Context, _ string, message string) error {
+	r.messages = append(r.messages, message)
+	return nil
+}
+
+func TestWebhook(t *testing.T) {
+	var body map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+	}))
+	defer srv.Close()
+
+	err := NewWebhook(srv.URL, nil).Alert(context.Background(), "limit", "order is refused")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"text": "order is refused"}, body)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	err = NewWebhook(failing.URL, nil).Alert(context.Background(), "limit", "order is refused")
+	assert.Error(t, err)
+}
+
+func TestThrottled(t *testing.T) {
+	r := &recorder{}
+	a := NewThrottled(r, time.Minute)
+	now := time.Unix(0, 0)
+	a.now = func() time.Time { return now }
+
+	tests := []struct {
+		after    time.Duration
+		key      string
+		message  string
+		expected []string
+	}{
+		{after: 0, key: "a", message: "a1", expected: []string{"a1"}},
+		{after: 30 * time.Second, key: "a", message: "a2", expected: []string{"a1"}},
+		{after: 0, key: "b", message: "b1", expected: []string{"a1", "b1"}},
+		{after: 30 * time.Second, key: "a", message: "a3", expected: []string{"a1", "b1", "a3"}},
+	}
+
+	for _, test := range tests {
+		now = now.Add(test.after)
+		require.NoError(t, a.Alert(context.Background(), test.key, test.message))
+		assert.Equal(t, test.expected, r.messages)
+	}
+}
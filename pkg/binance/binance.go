@@ -2,6 +2,7 @@ package binance
 
 import (
 	"context"
+	"fmt"
 
 	binance "github.com/adshao/go-binance/v2"
 	"github.com/adshao/go-binance/v2/futures"
@@ -47,20 +48,26 @@ func (c *Client) CreateFutureOrder(
 	symbol string,
 	quantity string,
 	price string,
+	stopPrice string,
 	side futures.SideType,
 	orderType futures.OrderType,
 	timeInForce futures.TimeInForceType,
 	reduceOnly bool,
+	positionSide futures.PositionSideType,
+	clientOrderID string,
 ) (*futures.CreateOrderResponse, error) {
 	c.logger.Infow(
 		"Create futures's order",
+		"clientOrderID", clientOrderID,
 		"symbol", symbol,
 		"quantity", quantity,
 		"price", price,
+		"stopPrice", stopPrice,
 		"side", side,
 		"type", orderType,
 		"timeInForce", timeInForce,
 		"reduceOnly", reduceOnly,
+		"positionSide", positionSide,
 	)
 
 	createOrderService := c.futureClient.
@@ -68,23 +75,38 @@ func (c *Client) CreateFutureOrder(
 		Symbol(symbol).
 		Quantity(quantity).
 		Side(side).
-		Type(orderType).
-		ReduceOnly(reduceOnly)
+		Type(orderType)
+	// Binance rejects reduceOnly in hedge mode even when it is false, so it is only sent when it is set.
+	if reduceOnly {
+		createOrderService = createOrderService.ReduceOnly(true)
+	}
+	if positionSide != "" {
+		createOrderService = createOrderService.PositionSide(positionSide)
+	}
 	if orderType != futures.OrderTypeMarket {
 		createOrderService = createOrderService.Price(price).TimeInForce(timeInForce)
 	}
+	if stopPrice != "" {
+		createOrderService = createOrderService.StopPrice(stopPrice)
+	}
+	if clientOrderID != "" {
+		createOrderService = createOrderService.NewClientOrderID(clientOrderID)
+	}
 
 	resp, err := createOrderService.Do(ctx)
 	if err != nil {
 		c.logger.Errorw(
 			"Fail to create future order",
+			"clientOrderID", clientOrderID,
 			"symbol", symbol,
 			"quantity", quantity,
 			"price", price,
+			"stopPrice", stopPrice,
 			"side", side,
 			"type", orderType,
 			"timeInForce", timeInForce,
 			"reduceOnly", reduceOnly,
+			"positionSide", positionSide,
 			"error", err,
 		)
 		return nil, err
@@ -93,11 +115,106 @@ func (c *Client) CreateFutureOrder(
 	return resp, nil
 }
 
+func (c *Client) GetFutureOrder(ctx context.Context, symbol string, clientOrderID string) (*futures.Order, error) {
+	c.logger.Debugw("Get futures's order", "symbol", symbol, "clientOrderID", clientOrderID)
+
+	order, err := c.futureClient.NewGetOrderService().
+		Symbol(symbol).
+		OrigClientOrderID(clientOrderID).
+		Do(ctx)
+	if err != nil {
+		c.logger.Errorw("Fail to get future order", "symbol", symbol, "clientOrderID", clientOrderID, "error", err)
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func (c *Client) CancelFutureOrder(
+	ctx context.Context, symbol string, clientOrderID string,
+) (*futures.CancelOrderResponse, error) {
+	c.logger.Infow("Cancel futures's order", "symbol", symbol, "clientOrderID", clientOrderID)
+
+	resp, err := c.futureClient.NewCancelOrderService().
+		Symbol(symbol).
+		OrigClientOrderID(clientOrderID).
+		Do(ctx)
+	if err != nil {
+		c.logger.Errorw("Fail to cancel future order", "symbol", symbol, "clientOrderID", clientOrderID, "error", err)
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (c *Client) GetPositionRisk(ctx context.Context, symbol string) ([]*futures.PositionRisk, error) {
+	c.logger.Debugw("Get futures' position risk", "symbol", symbol)
+
+	service := c.futureClient.NewGetPositionRiskService()
+	if symbol != "" {
+		service = service.Symbol(symbol)
+	}
+
+	risks, err := service.Do(ctx)
+	if err != nil {
+		c.logger.Errorw("Fail to get position risk", "symbol", symbol, "error", err)
+		return nil, err
+	}
+
+	return risks, nil
+}
+
+func (c *Client) GetPremiumIndex(ctx context.Context, symbol string) ([]*futures.PremiumIndex, error) {
+	c.logger.Debugw("Get futures' premium index", "symbol", symbol)
+
+	service := c.futureClient.NewPremiumIndexService()
+	if symbol != "" {
+		service = service.Symbol(symbol)
+	}
+
+	premiumIndexes, err := service.Do(ctx)
+	if err != nil {
+		c.logger.Errorw("Fail to get premium index", "symbol", symbol, "error", err)
+		return nil, err
+	}
+
+	return premiumIndexes, nil
+}
+
+func (c *Client) GetBookTicker(ctx context.Context, symbol string) (*futures.BookTicker, error) {
+	c.logger.Debugw("Get futures' book ticker", "symbol", symbol)
+
+	tickers, err := c.futureClient.NewListBookTickersService().Symbol(symbol).Do(ctx)
+	if err != nil {
+		c.logger.Errorw("Fail to get book ticker", "symbol", symbol, "error", err)
+		return nil, err
+	}
+	if len(tickers) == 0 {
+		return nil, fmt.Errorf("no book ticker of symbol %s", symbol)
+	}
+
+	return tickers[0], nil
+}
+
+func (c *Client) GetOrderBook(ctx context.Context, symbol string, limit int) (*futures.DepthResponse, error) {
+	c.logger.Debugw("Get futures' order book", "symbol", symbol, "limit", limit)
+
+	book, err := c.futureClient.NewDepthService().Symbol(symbol).Limit(limit).Do(ctx)
+	if err != nil {
+		c.logger.Errorw("Fail to get order book", "symbol", symbol, "error", err)
+		return nil, err
+	}
+
+	return book, nil
+}
+
+// ListenUserData connects to the user data stream and calls handler with its events. The handler runs on the
+// stream's reader, so it must not block.
 func (c *Client) ListenUserData(
 	ctx context.Context,
-	eventC chan *futures.WsUserDataEvent,
-) (doneC, stopC chan struct{}, err error) {
-	listenKey, err := c.futureClient.NewStartUserStreamService().Do(ctx)
+	handler futures.WsUserDataHandler,
+) (listenKey string, doneC, stopC chan struct{}, err error) {
+	listenKey, err = c.futureClient.NewStartUserStreamService().Do(ctx)
 	if err != nil {
 		c.logger.Errorw("Fail to create listen key", "error", err)
 		return
@@ -105,9 +222,7 @@ func (c *Client) ListenUserData(
 
 	doneC, stopC, err = futures.WsUserDataServe(
 		listenKey,
-		func(event *futures.WsUserDataEvent) {
-			eventC <- event
-		},
+		handler,
 		func(err error) {
 			c.logger.Errorw("Listen user data error", "error", err)
 		},
@@ -119,3 +234,80 @@ func (c *Client) ListenUserData(
 
 	return
 }
+
+func (c *Client) KeepaliveUserData(ctx context.Context, listenKey string) error {
+	c.logger.Debugw("Keep user data stream alive")
+
+	err := c.futureClient.NewKeepaliveUserStreamService().ListenKey(listenKey).Do(ctx)
+	if err != nil {
+		c.logger.Errorw("Fail to keep user data stream alive", "error", err)
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) GetAccount(ctx context.Context) (*futures.Account, error) {
+	c.logger.Debugw("Get futures' account")
+
+	account, err := c.futureClient.NewGetAccountService().Do(ctx)
+	if err != nil {
+		c.logger.Errorw("Fail to get futures account", "error", err)
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// TransferToFutures moves an amount of an asset from the spot wallet to the USDⓈ-M futures wallet.
+func (c *Client) TransferToFutures(ctx context.Context, asset string, amount string) error {
+	c.logger.Infow("Transfer from spot to futures", "asset", asset, "amount", amount)
+
+	_, err := c.spotClient.NewFuturesTransferService().
+		Asset(asset).
+		Amount(amount).
+		Type(binance.FuturesTransferTypeToFutures).
+		Do(ctx)
+	if err != nil {
+		c.logger.Errorw("Fail to transfer from spot to futures", "asset", asset, "amount", amount, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) GetPositionMode(ctx context.Context) (*futures.PositionMode, error) {
+	c.logger.Debugw("Get futures' position mode")
+
+	mode, err := c.futureClient.NewGetPositionModeService().Do(ctx)
+	if err != nil {
+		c.logger.Errorw("Fail to get position mode", "error", err)
+		return nil, err
+	}
+
+	return mode, nil
+}
+
+func (c *Client) ChangeLeverage(ctx context.Context, symbol string, leverage int) (*futures.SymbolLeverage, error) {
+	c.logger.Infow("Change futures' leverage", "symbol", symbol, "leverage", leverage)
+
+	resp, err := c.futureClient.NewChangeLeverageService().Symbol(symbol).Leverage(leverage).Do(ctx)
+	if err != nil {
+		c.logger.Errorw("Fail to change leverage", "symbol", symbol, "leverage", leverage, "error", err)
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (c *Client) ChangeMarginType(ctx context.Context, symbol string, marginType futures.MarginType) error {
+	c.logger.Infow("Change futures' margin type", "symbol", symbol, "marginType", marginType)
+
+	err := c.futureClient.NewChangeMarginTypeService().Symbol(symbol).MarginType(marginType).Do(ctx)
+	if err != nil {
+		c.logger.Errorw("Fail to change margin type", "symbol", symbol, "marginType", marginType, "error", err)
+		return err
+	}
+
+	return nil
+}
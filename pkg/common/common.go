@@ -5,6 +5,7 @@ import (
 	"math"
 	"math/big"
 	"strconv"
+	"strings"
 )
 
 type RoundType int
@@ -125,6 +126,78 @@ func FormatAmount(amount *big.Int, decimals int, precision int) string {
 	)
 }
 
+// ScaleAmount converts an amount from one number of decimals to another, truncating extra digits.
+func ScaleAmount(amount *big.Int, fromDecimals int, toDecimals int) *big.Int {
+	if fromDecimals == toDecimals {
+		return amount
+	}
+
+	if toDecimals > fromDecimals {
+		return BigMul(amount, BigExp(big.NewInt(10), int64(toDecimals-fromDecimals)))
+	}
+	return new(big.Int).Quo(amount, BigExp(big.NewInt(10), int64(fromDecimals-toDecimals)))
+}
+
+// ParseAmount parses a decimal string (e.g. "-1.25") into an amount with the given decimals.
+// Digits beyond the decimals are truncated.
+func ParseAmount(s string, decimals int) (*big.Int, error) {
+	s = strings.TrimSpace(s)
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign = "-"
+		s = s[1:]
+	}
+
+	intPart, fracPart := s, ""
+	if idx := strings.IndexByte(s, '.'); idx >= 0 {
+		intPart, fracPart = s[:idx], s[idx+1:]
+	}
+	if intPart == "" {
+		intPart = "0"
+	}
+
+	if len(fracPart) > decimals {
+		fracPart = fracPart[:decimals]
+	} else {
+		fracPart += strings.Repeat("0", decimals-len(fracPart))
+	}
+
+	amount, ok := new(big.Int).SetString(sign+intPart+fracPart, 10)
+	if !ok {
+		return nil, fmt.Errorf("fail to parse amount: s=%s decimals=%d", s, decimals)
+	}
+
+	return amount, nil
+}
+
+// AmountToFloat converts an amount with the given decimals into a float number.
+func AmountToFloat(amount *big.Int, decimals int) float64 {
+	f, _ := new(big.Float).Quo(
+		new(big.Float).SetInt(amount),
+		new(big.Float).SetInt(BigExp(big.NewInt(10), int64(decimals))),
+	).Float64()
+	return f
+}
+
+// FloatToAmount converts a float number into an amount with the given decimals, truncating extra digits.
+func FloatToAmount(f float64, decimals int) *big.Int {
+	amount, _ := new(big.Float).Mul(
+		big.NewFloat(f),
+		new(big.Float).SetInt(BigExp(big.NewInt(10), int64(decimals))),
+	).Int(nil)
+	return amount
+}
+
+// DecimalToAmount converts a float number into an amount with the given decimals by its shortest decimal form, so
+// e.g. 0.3 is exactly 0.3 instead of the float slightly below it. Extra digits are truncated.
+func DecimalToAmount(f float64, decimals int) *big.Int {
+	amount, err := ParseAmount(strconv.FormatFloat(f, 'f', -1, 64), decimals)
+	if err != nil {
+		return FloatToAmount(f, decimals)
+	}
+	return amount
+}
+
 func FloatIsZero(f float64) bool {
 	return math.Abs(f) < 1e10
 }
@@ -87,3 +87,151 @@ func TestFormatAmount(t *testing.T) {
 		assert.Equal(t, test.expected, FormatAmount(test.amount, test.decimals, test.precision))
 	}
 }
+
+func TestScaleAmount(t *testing.T) {
+	tests := []struct {
+		amount       *big.Int
+		fromDecimals int
+		toDecimals   int
+		expected     *big.Int
+	}{
+		{
+			amount:       big.NewInt(123456),
+			fromDecimals: 3,
+			toDecimals:   5,
+			expected:     big.NewInt(12345600),
+		},
+		{
+			amount:       big.NewInt(-123456),
+			fromDecimals: 5,
+			toDecimals:   3,
+			expected:     big.NewInt(-1234),
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, ScaleAmount(test.amount, test.fromDecimals, test.toDecimals))
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		s        string
+		decimals int
+		expected *big.Int
+	}{
+		{
+			s:        "1.23",
+			decimals: 5,
+			expected: big.NewInt(123000),
+		},
+		{
+			s:        "-8.145849",
+			decimals: 6,
+			expected: big.NewInt(-8145849),
+		},
+		{
+			s:        "0.1234567",
+			decimals: 3,
+			expected: big.NewInt(123),
+		},
+		{
+			s:        "42",
+			decimals: 2,
+			expected: big.NewInt(4200),
+		},
+	}
+
+	for _, test := range tests {
+		amount, err := ParseAmount(test.s, test.decimals)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, amount)
+	}
+
+	_, err := ParseAmount("1.2x", 2)
+	assert.Error(t, err)
+}
+
+func TestAmountToFloat(t *testing.T) {
+	tests := []struct {
+		amount   *big.Int
+		decimals int
+		expected float64
+	}{
+		{
+			amount:   big.NewInt(123456),
+			decimals: 3,
+			expected: 123.456,
+		},
+		{
+			amount:   big.NewInt(-5),
+			decimals: 1,
+			expected: -0.5,
+		},
+		{
+			amount:   NewBigIntFromString("1500000000000000000", 10),
+			decimals: 18,
+			expected: 1.5,
+		},
+	}
+
+	for _, test := range tests {
+		assert.InDelta(t, test.expected, AmountToFloat(test.amount, test.decimals), 1e-12)
+	}
+}
+
+func TestFloatToAmount(t *testing.T) {
+	tests := []struct {
+		f        float64
+		decimals int
+		expected *big.Int
+	}{
+		{
+			f:        123.456,
+			decimals: 3,
+			expected: big.NewInt(123456),
+		},
+		{
+			f:        0.5,
+			decimals: 18,
+			expected: NewBigIntFromString("500000000000000000", 10),
+		},
+		{
+			f:        0,
+			decimals: 6,
+			expected: big.NewInt(0),
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, FloatToAmount(test.f, test.decimals))
+	}
+}
+
+func TestDecimalToAmount(t *testing.T) {
+	tests := []struct {
+		f        float64
+		decimals int
+		expected *big.Int
+	}{
+		{
+			f:        0.3,
+			decimals: 18,
+			expected: NewBigIntFromString("300000000000000000", 10),
+		},
+		{
+			f:        -649.5,
+			decimals: 3,
+			expected: big.NewInt(-649500),
+		},
+		{
+			f:        1e-7,
+			decimals: 6,
+			expected: big.NewInt(0),
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, DecimalToAmount(test.f, test.decimals))
+	}
+}
@@ -1,9 +1,15 @@
 package common
 
 import (
+	"math"
 	"math/big"
 )
 
+const (
+	MinTick = -887272
+	MaxTick = 887272
+)
+
 func GetSqrtRatioAtTick(tick int) *big.Int {
 	var absTick uint
 	if tick < 0 {
@@ -87,3 +93,33 @@ func GetSqrtRatioAtTick(tick int) *big.Int {
 	}
 	return BigAdd(BigShiftRight(ratio, 32), remain)
 }
+
+// GetTickAtSqrtRatio returns the greatest tick whose sqrt ratio is less than or equal to the sqrt price.
+func GetTickAtSqrtRatio(sqrtPrice *big.Int) int {
+	// The tick is estimated from the price in floating point, then corrected with the exact ratios of ticks.
+	ratio, _ := new(big.Float).Quo(new(big.Float).SetInt(sqrtPrice), new(big.Float).SetInt(BigPowerOf2(96))).Float64()
+	tick := int(math.Floor(2 * math.Log(ratio) / math.Log(1.0001)))
+	if tick < MinTick {
+		tick = MinTick
+	}
+	if tick > MaxTick {
+		tick = MaxTick
+	}
+
+	for tick > MinTick && GetSqrtRatioAtTick(tick).Cmp(sqrtPrice) > 0 {
+		tick--
+	}
+	for tick < MaxTick && GetSqrtRatioAtTick(tick+1).Cmp(sqrtPrice) <= 0 {
+		tick++
+	}
+	return tick
+}
+
+// GetSqrtRatioAtPrice returns the sqrt price in Q64.96 of a price in amount of token1 per token0, both in their
+// smallest units.
+func GetSqrtRatioAtPrice(price float64) *big.Int {
+	sqrtPrice := new(big.Float).SetFloat64(math.Sqrt(price))
+	sqrtPrice.Mul(sqrtPrice, new(big.Float).SetInt(BigPowerOf2(96)))
+	res, _ := sqrtPrice.Int(nil)
+	return res
+}
@@ -50,3 +50,68 @@ func TestGetSqrtRatioAtTick(t *testing.T) {
 		assert.Equal(t, test.expected, GetSqrtRatioAtTick(test.tick))
 	}
 }
+
+func TestGetTickAtSqrtRatio(t *testing.T) {
+	tests := []struct {
+		sqrtPrice *big.Int
+		expected  int
+	}{
+		{
+			sqrtPrice: BigPowerOf2(96),
+			expected:  0,
+		},
+		{
+			sqrtPrice: big.NewInt(4295128739),
+			expected:  MinTick,
+		},
+		{
+			sqrtPrice: NewBigIntFromString("79232123823359799118286999568", 10),
+			expected:  1,
+		},
+		{
+			sqrtPrice: NewBigIntFromString("79232123823359799118286999567", 10),
+			expected:  0,
+		},
+		{
+			sqrtPrice: NewBigIntFromString("79224201403219477170569942574", 10),
+			expected:  -1,
+		},
+		{
+			sqrtPrice: NewBigIntFromString("90041927759339286931870012046", 10),
+			expected:  2559,
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, GetTickAtSqrtRatio(test.sqrtPrice))
+	}
+
+	for _, tick := range []int{-203800, -100, 0, 100, 203800} {
+		assert.Equal(t, tick, GetTickAtSqrtRatio(GetSqrtRatioAtTick(tick)))
+	}
+}
+
+func TestGetSqrtRatioAtPrice(t *testing.T) {
+	tests := []struct {
+		price        float64
+		expectedTick int
+	}{
+		{
+			price:        1,
+			expectedTick: 0,
+		},
+		{
+			price:        1.00011,
+			expectedTick: 1,
+		},
+		{
+			// 1400 USDC per WETH in the smallest units, with WETH as token1.
+			price:        1e12 / 1400,
+			expectedTick: 203878,
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expectedTick, GetTickAtSqrtRatio(GetSqrtRatioAtPrice(test.price)))
+	}
+}
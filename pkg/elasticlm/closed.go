@@ -0,0 +1,135 @@
+package elasticlm
+
+import (
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+	"github.com/hiepnv90/elastic-lm/pkg/models"
+	"github.com/hiepnv90/elastic-lm/pkg/position"
+)
+
+// missingPollsToClose is the number of consecutive updates a position must be missing from the subgraph before it
+// is considered closed, so a response which transiently omits a position doesn't unwind its hedges.
+const missingPollsToClose = 3
+
+// closedPosition returns the position's information after all of its liquidity is removed.
+func closedPosition(pos position.Position) position.Position {
+	pos.Liquidity = big.NewInt(0)
+	pos.MaxAmount0 = big.NewInt(0)
+	pos.MaxAmount1 = big.NewInt(0)
+	pos.Token0.Amount = big.NewInt(0)
+	pos.Token1.Amount = big.NewInt(0)
+	return pos
+}
+
+// getMissingPositionIDs returns the IDs of tracked positions which are not in the subgraph's response.
+func (e *ElasticLM) getMissingPositionIDs(seen map[string]bool) []string {
+	var ids []string
+	for id := range e.positionMap {
+		if !seen[id] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// getClosedMissingPositionIDs counts the consecutive updates which tracked positions are missing from the subgraph's
+// response, and returns the IDs of the ones which are missing long enough to be considered closed.
+func (e *ElasticLM) getClosedMissingPositionIDs(seen map[string]bool) []string {
+	for id := range e.missingPolls {
+		if seen[id] {
+			delete(e.missingPolls, id)
+		}
+	}
+
+	var ids []string
+	for _, id := range e.getMissingPositionIDs(seen) {
+		e.missingPolls[id]++
+		if e.missingPolls[id] < missingPollsToClose {
+			e.logger.Warnw("Position is missing from subgraph", "positionID", id, "polls", e.missingPolls[id])
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// archiveClosedPositions stops tracking closed positions once their hedges are unwound.
+// The archived record keeps the position's final hedged amounts.
+func (e *ElasticLM) archiveClosedPositions() {
+	for id, pos := range e.positionMap {
+		if !pos.IsClosed() {
+			continue
+		}
+		if e.hasPendingAllocations(id) || !e.isUnwound(pos) {
+			continue
+		}
+
+		err := e.db.Model(&models.Position{}).Where("id = ?", id).Update("closed_at", time.Now()).Error
+		if err != nil {
+			e.logger.Warnw("Fail to archive closed position", "positionID", id, "error", err)
+			continue
+		}
+
+		e.logger.Infow(
+			"Archive closed position",
+			"positionID", id,
+			"hedgedAmount0", pos.HedgedAmount0,
+			"hedgedAmount1", pos.HedgedAmount1,
+		)
+		delete(e.positionMap, id)
+		delete(e.missingPolls, id)
+		delete(e.residuals, hedgeLeg{PositionID: id, TokenIndex: 0})
+		delete(e.residuals, hedgeLeg{PositionID: id, TokenIndex: 1})
+		e.closedPositionIDs[id] = struct{}{}
+	}
+}
+
+func (e *ElasticLM) hasPendingAllocations(positionID string) bool {
+	for _, order := range e.pendingOrders {
+		for _, allocation := range order.Allocations {
+			if allocation.PositionID == positionID {
+				return true
+			}
+		}
+	}
+	for _, parent := range e.parentOrders {
+		for _, allocation := range parent.Allocations {
+			if allocation.PositionID == positionID {
+				return true
+			}
+		}
+	}
+	for _, m := range e.makerOrders {
+		for _, allocation := range m.remainders() {
+			if allocation.PositionID == positionID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isUnwound returns whether the hedged amounts of a position's volatile tokens are too small to be traded.
+// Without the symbol's information, e.g. while monitoring only, the hedged amount must be zero.
+func (e *ElasticLM) isUnwound(pos position.Position) bool {
+	for tokenIndex := 0; tokenIndex < 2; tokenIndex++ {
+		token := pos.Token(tokenIndex)
+		if token.IsStable() {
+			continue
+		}
+
+		amount := common.BigAbs(pos.HedgedAmount(tokenIndex))
+		symbolInfo, ok := e.symbolInfoMap[e.getBinancePerpetualSymbol(token)]
+		if ok {
+			amount = common.RoundAmount(amount, token.Decimals, symbolInfo.QuantityPrecision, common.RoundTypeFloor)
+		}
+		if !common.BigIsZero(amount) {
+			return false
+		}
+	}
+	return true
+}
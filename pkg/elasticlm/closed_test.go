@@ -0,0 +1,120 @@
+package elasticlm
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/hiepnv90/elastic-lm/pkg/graphql"
+	"github.com/hiepnv90/elastic-lm/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdatePositionsUnwindsClosedPositions(t *testing.T) {
+	tests := []struct {
+		name  string
+		close func(pos *graphql.Position) []graphql.Position
+		polls int
+	}{
+		{
+			name: "liquidity is removed",
+			close: func(pos *graphql.Position) []graphql.Position {
+				pos.Liquidity = "0"
+				return []graphql.Position{*pos}
+			},
+			polls: 1,
+		},
+		{
+			name: "NFT is burned",
+			close: func(pos *graphql.Position) []graphql.Position {
+				pos.Owner = zeroAddress
+				return []graphql.Position{*pos}
+			},
+			polls: 1,
+		},
+		{
+			name: "position is missing",
+			close: func(_ *graphql.Position) []graphql.Position {
+				return nil
+			},
+			polls: missingPollsToClose,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, sim := newTestElasticLM(t, Options{})
+			subgraph := newTestSubgraph(t, e)
+			pos := newTestSubgraphPosition("1", "650000000000000")
+			subgraph.positions = []graphql.Position{pos}
+
+			require.NoError(t, e.updatePositions(context.Background(), true))
+			short := getTestShort(sim)
+			assert.Greater(t, short, 0.9)
+			assertAmount(t, strconv.FormatFloat(short, 'f', 3, 64), e.positionMap["1"].HedgedAmount0)
+
+			subgraph.positions = test.close(&pos)
+			for i := 0; i < test.polls; i++ {
+				require.NoError(t, e.updatePositions(context.Background(), true))
+			}
+			assert.Equal(t, 0.0, getTestShort(sim), "hedge is unwound")
+			assert.NotContains(t, e.positionMap, "1")
+			assert.Contains(t, e.closedPositionIDs, "1")
+
+			var saved models.Position
+			require.NoError(t, e.db.Where("id = ?", "1").First(&saved).Error)
+			assert.NotNil(t, saved.ClosedAt, "position is archived")
+			assert.Equal(t, "0", saved.HedgedAmount0)
+
+			restarted := restartTestElasticLM(t, e, Options{})
+			assert.Empty(t, restarted.positionMap, "archived position isn't loaded")
+			assert.Contains(t, restarted.closedPositionIDs, "1")
+		})
+	}
+}
+
+func TestUpdatePositionsReopensArchivedPosition(t *testing.T) {
+	e, sim := newTestElasticLM(t, Options{})
+	subgraph := newTestSubgraph(t, e)
+	pos := newTestSubgraphPosition("1", "650000000000000")
+	subgraph.positions = []graphql.Position{pos}
+	require.NoError(t, e.updatePositions(context.Background(), true))
+	pos.Liquidity = "0"
+	subgraph.positions = []graphql.Position{pos}
+	require.NoError(t, e.updatePositions(context.Background(), true))
+	require.Contains(t, e.closedPositionIDs, "1")
+
+	pos.Liquidity = "650000000000000"
+	subgraph.positions = []graphql.Position{pos}
+	require.NoError(t, e.updatePositions(context.Background(), true))
+	assert.NotContains(t, e.closedPositionIDs, "1")
+	require.Contains(t, e.positionMap, "1")
+	assert.Greater(t, getTestShort(sim), 0.9, "liquidity added back is hedged again")
+}
+
+func TestUpdatePositionsConfirmsMissingPositions(t *testing.T) {
+	e, sim := newTestElasticLM(t, Options{})
+	subgraph := newTestSubgraph(t, e)
+	pos := newTestSubgraphPosition("1", "650000000000000")
+	subgraph.positions = []graphql.Position{pos}
+	require.NoError(t, e.updatePositions(context.Background(), true))
+	short := getTestShort(sim)
+
+	subgraph.positions = nil
+	for i := 1; i < missingPollsToClose; i++ {
+		require.NoError(t, e.updatePositions(context.Background(), true))
+	}
+	assert.Equal(t, short, getTestShort(sim), "hedge is kept until the position is missing long enough")
+	assert.Equal(t, missingPollsToClose-1, e.missingPolls["1"])
+
+	subgraph.positions = []graphql.Position{pos}
+	require.NoError(t, e.updatePositions(context.Background(), true))
+	assert.NotContains(t, e.missingPolls, "1", "count restarts once the position is seen")
+
+	subgraph.positions = nil
+	require.NoError(t, e.updatePositions(context.Background(), true))
+	assert.Equal(t, short, getTestShort(sim))
+	require.Contains(t, e.positionMap, "1")
+	assert.False(t, e.positionMap["1"].IsClosed())
+}
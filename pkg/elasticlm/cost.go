@@ -0,0 +1,205 @@
+package elasticlm
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+)
+
+// fundingInterval is the interval between funding payments of perpetual symbols.
+const fundingInterval = 8 * time.Hour
+
+// minVolatilitySamples is the number of mark price returns a volatility estimate needs before it is used.
+const minVolatilitySamples = 10
+
+// volatility is an exponentially weighted estimate of the variance of a symbol's mark price returns per second.
+type volatility struct {
+	Price    float64
+	Time     time.Time
+	Variance float64
+	Samples  int
+}
+
+// update adds the return since the last sampled price, weighted by the time passed against the half-life.
+func (v *volatility) update(price float64, now time.Time, halfLife time.Duration) {
+	if price <= 0 {
+		return
+	}
+	if v.Price <= 0 {
+		v.Price, v.Time = price, now
+		return
+	}
+
+	dt := now.Sub(v.Time).Seconds()
+	if dt <= 0 {
+		return
+	}
+
+	r := math.Log(price / v.Price)
+	alpha := 1 - math.Exp(-math.Ln2*dt/halfLife.Seconds())
+	v.Variance += alpha * (r*r/dt - v.Variance)
+	v.Price, v.Time = price, now
+	v.Samples++
+}
+
+// sigma returns the standard deviation of returns over the horizon, and false while there are too few samples.
+func (v *volatility) sigma(horizon time.Duration) (float64, bool) {
+	if v == nil || v.Samples < minVolatilitySamples {
+		return 0, false
+	}
+	return math.Sqrt(v.Variance * horizon.Seconds()), true
+}
+
+// CostOptions holds the settings of skipping rebalances which aren't worth their trading cost.
+type CostOptions struct {
+	// Horizon is the time over which the risk a rebalance removes and its funding cost are valued, rebalances aren't
+	// checked when it is unset.
+	Horizon time.Duration
+	// FeeBps is the fee rate which is charged on rebalance orders.
+	FeeBps float64
+	// VolatilityHalfLife is the half-life of the volatility of mark prices which values the risk a rebalance removes.
+	VolatilityHalfLife time.Duration
+	// Rebalances are skipped when their removed risk is below MinRatio times their cost.
+	MinRatio float64
+}
+
+// validate checks the settings of cost checks, which are only used with a horizon.
+func (o CostOptions) validate() error {
+	if o.Horizon <= 0 {
+		return nil
+	}
+	if o.VolatilityHalfLife <= 0 {
+		return fmt.Errorf("cost volatility half-life %s must be positive", o.VolatilityHalfLife)
+	}
+	if o.MinRatio < 0 {
+		return fmt.Errorf("cost min ratio %v must not be negative", o.MinRatio)
+	}
+	return nil
+}
+
+// sampleVolatilities updates the volatility estimates with the mark prices of the symbols which positions are
+// hedged on. The cost checks and the polling interval keep their own estimates, each with its own half-life.
+func (e *ElasticLM) sampleVolatilities(ctx context.Context) {
+	tracksCost, tracksPolling := e.cost.Horizon > 0, e.polling.tracksVolatility()
+	if !tracksCost && !tracksPolling {
+		return
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	for _, pos := range e.positionMap {
+		for tokenIndex := 0; tokenIndex < 2; tokenIndex++ {
+			token := pos.Token(tokenIndex)
+			if token.IsStable() {
+				continue
+			}
+			symbol := e.getBinancePerpetualSymbol(token)
+			if seen[symbol] {
+				continue
+			}
+			seen[symbol] = true
+
+			markPrice, err := e.getMarkPrice(ctx, symbol)
+			if err != nil {
+				continue
+			}
+			if tracksCost {
+				updateVolatility(e.volatilities, symbol, markPrice, now, e.cost.VolatilityHalfLife)
+			}
+			if tracksPolling {
+				updateVolatility(e.pollingVolatilities, symbol, markPrice, now, e.polling.VolatilityHalfLife)
+			}
+		}
+	}
+}
+
+func updateVolatility(
+	volatilities map[string]*volatility, symbol string, price float64, now time.Time, halfLife time.Duration,
+) {
+	v, ok := volatilities[symbol]
+	if !ok {
+		v = &volatility{}
+		volatilities[symbol] = v
+	}
+	v.update(price, now, halfLife)
+}
+
+// checkCost returns whether the risk which an order removes is worth its expected trading cost.
+// The cost is the commission, the expected slippage on the order book and the funding over the horizon, and the risk
+// is the order's notional times the volatility over the horizon. Initial hedges and unwinds of closed positions are
+// always worth it.
+func (e *ElasticLM) checkCost(
+	ctx context.Context,
+	symbol string,
+	deltas []hedgeDelta,
+	side futures.SideType,
+	quantity *big.Int,
+	decimals int,
+	style ExecutionStyle,
+) (bool, error) {
+	if e.cost.Horizon <= 0 {
+		return true, nil
+	}
+
+	for _, delta := range deltas {
+		pos, ok := e.positionMap[delta.PositionID]
+		if !ok || pos.IsClosed() || common.BigIsZero(pos.HedgedAmount(delta.TokenIndex)) {
+			return true, nil
+		}
+	}
+
+	sigma, ok := e.volatilities[symbol].sigma(e.cost.Horizon)
+	if !ok {
+		e.logger.Debugw("Skip cost check of rebalance without volatility estimate", "symbol", symbol)
+		return true, nil
+	}
+
+	markPrice, err := e.getMarkPrice(ctx, symbol)
+	if err != nil {
+		return false, err
+	}
+	notional := common.AmountToFloat(quantity, decimals) * markPrice
+
+	feeCost := notional * e.cost.FeeBps / 10000
+
+	// Maker orders don't take liquidity.
+	slippageCost := 0.0
+	if style != ExecutionStyleMaker {
+		levels, err := e.getBookLevels(ctx, symbol, side)
+		if err != nil {
+			return false, err
+		}
+		slippageBps := estimateSlippageBps(levels, side, common.AmountToFloat(quantity, decimals), markPrice)
+		slippageCost = notional * slippageBps / 10000
+	}
+
+	// Shorts receive the funding when the rate is positive, so selling earns it and buying gives it up.
+	periods := e.cost.Horizon.Hours() / fundingInterval.Hours()
+	fundingCost := e.fundingRates[symbol] * notional * periods
+	if side == futures.SideTypeSell {
+		fundingCost = -fundingCost
+	}
+
+	cost := feeCost + slippageCost + fundingCost
+	riskReduction := notional * sigma
+	worth := riskReduction >= cost*e.cost.MinRatio
+
+	e.logger.Infow(
+		"Evaluate cost of rebalance",
+		"symbol", symbol,
+		"side", side,
+		"notional", notional,
+		"feeCost", feeCost,
+		"slippageCost", slippageCost,
+		"fundingCost", fundingCost,
+		"volatility", sigma,
+		"riskReduction", riskReduction,
+		"worth", worth,
+	)
+	return worth, nil
+}
@@ -0,0 +1,57 @@
+package elasticlm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHedgeDeltasChecksCost(t *testing.T) {
+	tests := []struct {
+		name       string
+		hedged     string
+		volatility *volatility
+		short      float64
+		skipped    bool
+	}{
+		{
+			name:       "calm price",
+			hedged:     "0.5",
+			volatility: &volatility{Samples: minVolatilitySamples},
+			short:      0.5,
+			skipped:    true,
+		},
+		{
+			name:       "volatile price",
+			hedged:     "0.5",
+			volatility: &volatility{Variance: 1e-6, Samples: minVolatilitySamples},
+			short:      0.7,
+		},
+		{name: "no volatility estimate", hedged: "0.5", volatility: &volatility{Variance: 1e-6}, short: 0.7},
+		{name: "initial hedge", hedged: "0", volatility: &volatility{Samples: minVolatilitySamples}, short: 0.2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, sim := newTestElasticLM(t, Options{
+				Cost: CostOptions{Horizon: time.Hour, FeeBps: 4, VolatilityHalfLife: time.Hour, MinRatio: 1},
+			})
+			if test.hedged != "0" {
+				openTestShort(t, sim, test.hedged)
+			}
+			e.positionMap["1"] = newTestPosition("1", "1", test.hedged)
+			e.volatilities[testSymbol] = test.volatility
+
+			e.hedgeDeltas(context.Background(), []hedgeDelta{newTestDelta("1", "0.2")})
+			assert.Equal(t, test.short, getTestShort(sim))
+			if test.skipped {
+				assert.Contains(t, e.residuals, hedgeLeg{PositionID: "1"}, "skipped rebalance is kept as residual")
+				assertAmount(t, "0.5", e.positionMap["1"].HedgedAmount0)
+			} else {
+				assert.Empty(t, e.residuals)
+			}
+		})
+	}
+}
@@ -2,17 +2,21 @@ package elasticlm
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
 	"math/big"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/adshao/go-binance/v2/futures"
-	"github.com/hiepnv90/elastic-lm/pkg/binance"
+	"github.com/hiepnv90/elastic-lm/pkg/alert"
 	"github.com/hiepnv90/elastic-lm/pkg/common"
 	"github.com/hiepnv90/elastic-lm/pkg/graphql"
 	"github.com/hiepnv90/elastic-lm/pkg/models"
 	"github.com/hiepnv90/elastic-lm/pkg/position"
+	"github.com/hiepnv90/elastic-lm/pkg/strategy"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -20,44 +24,206 @@ import (
 
 var bps = big.NewInt(10000)
 
+const zeroAddress = "0x0000000000000000000000000000000000000000"
+
+// Options holds the optional settings of ElasticLM.
+type Options struct {
+	// Reconcile holds the settings of reconciling stored hedged amounts with the exchange's positions.
+	Reconcile ReconcileOptions
+	// Rebalance holds the bands of rebalancing a token's delta and the pace of its orders.
+	Rebalance RebalanceOptions
+	// HedgeMode is the default hedge mode of positions, which is overridden by HedgeModes by position ID.
+	HedgeMode  HedgeMode
+	HedgeModes map[string]HedgeMode
+	// Strategies are custom strategies which are selected by their names as hedge modes.
+	Strategies []strategy.Strategy
+	// Ladder holds the settings of ladders of positions in ladder mode.
+	Ladder LadderOptions
+	// TWAP holds the settings of slicing large orders over time.
+	TWAP TWAPOptions
+	// Execution holds the settings of sending hedge orders to the exchange.
+	Execution ExecutionOptions
+	// Slippage holds the settings of guarding market orders against slippage on the order book.
+	Slippage SlippageOptions
+	// Cost holds the settings of skipping rebalances which aren't worth their trading cost.
+	Cost CostOptions
+	// PriceEstimate holds the settings of estimating positions from mark prices between subgraph updates.
+	PriceEstimate PriceEstimateOptions
+	// Polling holds the settings of adapting the interval of updating positions.
+	Polling PollingOptions
+	// Limits holds the exposure limits which orders are checked against before they are sent.
+	Limits LimitOptions
+	// Alerter receives the alerts for operators, they are logged by default.
+	Alerter alert.Alerter
+	// With FlattenOnExit, all shorts are closed when Run's context is cancelled.
+	FlattenOnExit bool
+	// Margin holds the settings of monitoring the margin of the futures account and acting on it.
+	Margin MarginOptions
+	// Leverage holds the leverage and margin type which are applied to hedged symbols on the exchange.
+	Leverage LeverageOptions
+	// HedgeRatios are the ratios of token amounts to hedge by position ID, positions without a ratio are fully
+	// hedged.
+	HedgeRatios map[string]float64
+}
+
 type ElasticLM struct {
-	interval           time.Duration
-	positionIDs        []string
-	amountThresholdBps *big.Int
-	quoteCurrency      string
-	positionMap        map[string]position.Position
-	symbolInfoMap      map[string]futures.Symbol
-	tokenInstrumentMap map[string]string
+	interval            time.Duration
+	positionIDs         []string
+	amountThresholdBps  *big.Int
+	rebalance           RebalanceOptions
+	hedgeMode           HedgeMode
+	hedgeModes          map[string]HedgeMode
+	strategies          map[string]strategy.Strategy
+	ladder              LadderOptions
+	ladders             map[string]*ladder
+	lastOrderTimes      map[string]time.Time
+	twap                TWAPOptions
+	parentOrders        map[uint64]*parentOrder
+	execution           ExecutionOptions
+	makerOrders         map[string]*makerOrder
+	slippage            SlippageOptions
+	cost                CostOptions
+	volatilities        map[string]*volatility
+	pollingVolatilities map[string]*volatility
+	priceEstimate       PriceEstimateOptions
+	subgraphBlock       graphql.Block
+	polling             PollingOptions
+	currentInterval     time.Duration
+	limits              LimitOptions
+	alerter             alert.Alerter
+	flattenOnExit       bool
+	flattenC            chan struct{}
+	halted              bool
+	margin              MarginOptions
+	marginHedgeScale    float64
+	marginReduced       bool
+	leverage            LeverageOptions
+	setupSymbolSet      map[string]struct{}
+	dualSidePosition    bool
+	hedgeRatios         map[string]float64
+	quoteCurrency       string
+	positionMap         map[string]position.Position
+	closedPositionIDs   map[string]struct{}
+	missingPolls        map[string]int
+	symbolInfoMap       map[string]futures.Symbol
+	tokenInstrumentMap  map[string]string
+	pendingOrders       map[string]*pendingOrder
+	orderIDPrefix       string
+	userDataC           chan *futures.WsUserDataEvent
+	resyncC             chan struct{}
+	reconnectC          chan struct{}
+	reconciliation      ReconcileOptions
+	unallocatedAmounts  map[string]common.Token
+	residuals           map[hedgeLeg]hedgeDelta
+	markPrices          map[string]float64
+	fundingRates        map[string]float64
 
 	db      *gorm.DB
 	client  *graphql.Client
-	bclient *binance.Client
+	bclient Exchange
 	logger  *zap.SugaredLogger
 }
 
 func New(
 	db *gorm.DB,
 	client *graphql.Client,
-	bclient *binance.Client,
+	bclient Exchange,
 	positionIDs []string,
 	amountThresholdBps int,
 	quoteCurrency string,
 	interval time.Duration,
 	tokenInstrumentMap map[string]string,
+	opts Options,
 ) *ElasticLM {
-	return &ElasticLM{
-		interval:           interval,
-		positionIDs:        positionIDs,
-		amountThresholdBps: big.NewInt(int64(amountThresholdBps)),
-		quoteCurrency:      quoteCurrency,
-		positionMap:        make(map[string]position.Position),
-		symbolInfoMap:      make(map[string]futures.Symbol),
-		db:                 db,
-		tokenInstrumentMap: tokenInstrumentMap,
-		client:             client,
-		bclient:            bclient,
-		logger:             zap.S(),
+	e := &ElasticLM{
+		interval:            interval,
+		positionIDs:         positionIDs,
+		amountThresholdBps:  big.NewInt(int64(amountThresholdBps)),
+		rebalance:           opts.Rebalance,
+		hedgeMode:           opts.HedgeMode,
+		hedgeModes:          opts.HedgeModes,
+		ladder:              opts.Ladder,
+		ladders:             make(map[string]*ladder),
+		lastOrderTimes:      make(map[string]time.Time),
+		twap:                opts.TWAP,
+		parentOrders:        make(map[uint64]*parentOrder),
+		execution:           opts.Execution,
+		makerOrders:         make(map[string]*makerOrder),
+		slippage:            opts.Slippage,
+		cost:                opts.Cost,
+		volatilities:        make(map[string]*volatility),
+		pollingVolatilities: make(map[string]*volatility),
+		priceEstimate:       opts.PriceEstimate,
+		polling:             opts.Polling,
+		currentInterval:     interval,
+		limits:              opts.Limits,
+		alerter:             opts.Alerter,
+		flattenOnExit:       opts.FlattenOnExit,
+		flattenC:            make(chan struct{}, 1),
+		margin:              opts.Margin,
+		marginHedgeScale:    1,
+		leverage:            opts.Leverage,
+		setupSymbolSet:      make(map[string]struct{}),
+		hedgeRatios:         opts.HedgeRatios,
+		quoteCurrency:       quoteCurrency,
+		positionMap:         make(map[string]position.Position),
+		closedPositionIDs:   make(map[string]struct{}),
+		missingPolls:        make(map[string]int),
+		symbolInfoMap:       make(map[string]futures.Symbol),
+		pendingOrders:       make(map[string]*pendingOrder),
+		userDataC:           make(chan *futures.WsUserDataEvent, 100),
+		resyncC:             make(chan struct{}, 1),
+		reconnectC:          make(chan struct{}, 1),
+		reconciliation:      opts.Reconcile,
+		unallocatedAmounts:  make(map[string]common.Token),
+		residuals:           make(map[hedgeLeg]hedgeDelta),
+		markPrices:          make(map[string]float64),
+		fundingRates:        make(map[string]float64),
+		db:                  db,
+		tokenInstrumentMap:  tokenInstrumentMap,
+		client:              client,
+		bclient:             bclient,
+		logger:              zap.S(),
+	}
+	if e.alerter == nil {
+		e.alerter = alert.NewLogger()
+	}
+	e.strategies = e.newStrategies(opts.Strategies)
+	return e
+}
+
+// validate checks the settings of every feature before Run starts, the first invalid one is returned.
+func (e *ElasticLM) validate() error {
+	if e.interval <= 0 {
+		return fmt.Errorf("invalid interval: %s", e.interval)
 	}
+
+	checks := []struct {
+		name     string
+		validate func() error
+	}{
+		{name: "polling", validate: func() error { return e.polling.validate(e.interval) }},
+		{name: "reconcile", validate: e.reconciliation.validate},
+		{name: "hedge ratio", validate: e.validateHedgeRatios},
+		{name: "hedge mode", validate: e.validateHedgeModes},
+		{name: "ladder", validate: e.ladder.validate},
+		{name: "TWAP", validate: e.twap.validate},
+		{name: "execution", validate: e.execution.validate},
+		{name: "slippage", validate: e.slippage.validate},
+		{name: "cost", validate: e.cost.validate},
+		{name: "price estimate", validate: e.priceEstimate.validate},
+		{name: "limit", validate: e.limits.validate},
+		{name: "margin", validate: e.margin.validate},
+		{name: "leverage", validate: e.leverage.validate},
+		{name: "rebalance", validate: func() error { return e.rebalance.validate(e.amountThresholdBps.Int64()) }},
+	}
+	for _, check := range checks {
+		err := check.validate()
+		if err != nil {
+			return fmt.Errorf("invalid %s settings: %w", check.name, err)
+		}
+	}
+	return nil
 }
 
 func (e *ElasticLM) Run(ctx context.Context) error {
@@ -66,47 +232,164 @@ func (e *ElasticLM) Run(ctx context.Context) error {
 	isHedge := e.bclient != nil
 	l.Infow("Start monitoring positions", "isHedge", isHedge)
 
+	err := e.validate()
+	if err != nil {
+		l.Errorw("Invalid settings", "error", err)
+		return err
+	}
+
 	if isHedge {
-		l.Infow("Get exchange information")
-		exchangeInfo, err := e.bclient.GetExchangeInfo(ctx)
+		err = e.loadExchangeInfo(ctx)
 		if err != nil {
-			l.Errorw("Fail to get exchange information", "error", err)
 			return err
 		}
-		for _, symbolInfo := range exchangeInfo.Symbols {
-			e.symbolInfoMap[symbolInfo.Symbol] = symbolInfo
+
+		err = e.loadPositionMode(ctx)
+		if err != nil {
+			return err
 		}
+
+		l.Infow("Listen user data stream for order updates")
+		go e.listenUserData(ctx)
 	}
 
-	err := e.loadPositions()
+	err = e.loadPositions()
 	if err != nil {
 		l.Errorw("Fail to load saved positions from database", "error", err)
 		return err
 	}
 
-	ticker := time.NewTicker(e.interval)
-	defer ticker.Stop()
+	err = e.loadHalted(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = e.loadMarginHedgeScale()
+	if err != nil {
+		return err
+	}
+
+	if isHedge {
+		err = e.restoreOrders(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = e.setupSymbols(ctx)
+		if err != nil {
+			l.Errorw("Fail to set up leverage and margin type of symbols", "error", err)
+			return err
+		}
+	}
+
+	var reconcileC <-chan time.Time
+	if isHedge && !e.halted && e.reconciliation.Mode.enabled() {
+		l.Infow("Reconcile stored hedged amounts with exchange positions", "mode", e.reconciliation.Mode)
+		err = e.reconcile(ctx)
+		if err != nil {
+			l.Errorw("Fail to reconcile hedged amounts", "error", err)
+			return err
+		}
+
+		if e.reconciliation.Interval > 0 {
+			reconcileTicker := time.NewTicker(e.reconciliation.Interval)
+			defer reconcileTicker.Stop()
+			reconcileC = reconcileTicker.C
+		}
+	}
+
+	var marginC <-chan time.Time
+	if isHedge && e.margin.Interval > 0 {
+		l.Infow("Monitor margin of futures account", "interval", e.margin.Interval)
+		marginTicker := time.NewTicker(e.margin.Interval)
+		defer marginTicker.Stop()
+		marginC = marginTicker.C
+	}
 
-	err = e.updatePositions(ctx, isHedge)
+	err = e.updatePositions(ctx, isHedge && !e.halted)
 	if err != nil {
 		l.Errorw("Fail to update positions' information", "error", err)
 		return err
 	}
 
+	failures := 0
+	timer := time.NewTimer(e.nextInterval(failures))
+	defer timer.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
 			l.Infow("Stop monitoring positions")
+			if isHedge && e.flattenOnExit {
+				return e.flattenOnStop()
+			}
 			return nil
-		case <-ticker.C:
-			err = e.updatePositions(ctx, isHedge)
+		case <-timer.C:
+			err = e.updatePositions(ctx, isHedge && !e.halted)
 			if err != nil {
 				l.Errorw("Fail to update positions' information", "error", err)
+				failures++
+			} else {
+				failures = 0
+			}
+			timer.Reset(e.nextInterval(failures))
+		case event := <-e.userDataC:
+			e.handleUserDataEvent(event)
+		case <-e.resyncC:
+			e.resyncPendingOrders(ctx)
+		case <-e.flattenC:
+			if isHedge {
+				e.flatten(ctx)
+			}
+		case <-reconcileC:
+			if e.halted {
+				continue
+			}
+			err = e.reconcile(ctx)
+			if err != nil {
+				l.Errorw("Fail to reconcile hedged amounts", "error", err)
 			}
+		case <-marginC:
+			e.checkMargin(ctx)
 		}
 	}
 }
 
+// loadExchangeInfo loads the information of the exchange's symbols.
+func (e *ElasticLM) loadExchangeInfo(ctx context.Context) error {
+	e.logger.Infow("Get exchange information")
+	exchangeInfo, err := e.bclient.GetExchangeInfo(ctx)
+	if err != nil {
+		e.logger.Errorw("Fail to get exchange information", "error", err)
+		return err
+	}
+	for _, symbolInfo := range exchangeInfo.Symbols {
+		e.symbolInfoMap[symbolInfo.Symbol] = symbolInfo
+	}
+	return nil
+}
+
+// restoreOrders resolves the open orders of the journal, and restores the ladders, maker orders and parent orders
+// which they belong to.
+func (e *ElasticLM) restoreOrders(ctx context.Context) error {
+	e.logger.Infow("Resolve open orders of the journal")
+	err := e.loadOpenOrders()
+	if err != nil {
+		e.logger.Errorw("Fail to load open orders", "error", err)
+		return err
+	}
+	e.resyncPendingOrders(ctx)
+	e.restoreLadders()
+	e.restoreMakerOrders()
+
+	err = e.loadParentOrders()
+	if err != nil {
+		e.logger.Errorw("Fail to load parent orders", "error", err)
+		return err
+	}
+	return nil
+}
+
 func (e *ElasticLM) updatePositions(ctx context.Context, isHedge bool) error {
 	l := e.logger
 
@@ -116,11 +399,52 @@ func (e *ElasticLM) updatePositions(ctx context.Context, isHedge bool) error {
 		return err
 	}
 
+	// Mark prices are fetched at most once per update.
+	e.markPrices = make(map[string]float64)
+	if isHedge {
+		posInfos = e.estimatePositions(ctx, posInfos)
+	}
+
+	var deltas []hedgeDelta
+	seen := make(map[string]bool, len(posInfos))
 	for _, posInfo := range posInfos {
-		err = e.updatePosition(posInfo, isHedge)
+		seen[posInfo.ID] = true
+		if _, ok := e.closedPositionIDs[posInfo.ID]; ok {
+			if posInfo.IsClosed() {
+				continue
+			}
+			// Liquidity is added back to an archived position, so it is tracked again from scratch.
+			l.Infow("Reopen archived position", "positionID", posInfo.ID)
+			delete(e.closedPositionIDs, posInfo.ID)
+		}
+
+		posDeltas, err := e.updatePosition(ctx, posInfo, isHedge)
 		if err != nil {
 			l.Warnw("Fail to update position information", "info", posInfo.String(), "error", err)
+			continue
+		}
+		deltas = append(deltas, posDeltas...)
+	}
+
+	// Positions which the subgraph doesn't return anymore are closed, so their hedges are unwound.
+	for _, id := range e.getClosedMissingPositionIDs(seen) {
+		l.Warnw("Position is missing from subgraph, consider it closed", "positionID", id)
+		posDeltas, err := e.updatePosition(ctx, closedPosition(e.positionMap[id]), isHedge)
+		if err != nil {
+			l.Warnw("Fail to update position information", "positionID", id, "error", err)
+			continue
 		}
+		deltas = append(deltas, posDeltas...)
+	}
+
+	if isHedge {
+		e.sampleVolatilities(ctx)
+	}
+	e.hedgeDeltas(ctx, deltas)
+	if isHedge {
+		e.executeParentOrders(ctx)
+		e.chaseMakerOrders(ctx)
+		e.updateLadders(ctx)
 	}
 
 	err = e.savePositions()
@@ -128,138 +452,241 @@ func (e *ElasticLM) updatePositions(ctx context.Context, isHedge bool) error {
 		l.Warnw("Fail to save positions into database", "error", err)
 	}
 
+	e.archiveClosedPositions()
+
 	return nil
 }
 
-func (e *ElasticLM) updatePosition(newPosInfo position.Position, isHedge bool) error {
+// hedgeDelta is the amount of a position's token which needs to be hedged.
+type hedgeDelta struct {
+	PositionID string
+	TokenIndex int
+	Token      common.Token
+}
+
+// updatePosition stores the position's new information and returns the deltas of its tokens to hedge.
+// Deltas of stable tokens don't need any order, so they are added to the hedged amounts right away.
+func (e *ElasticLM) updatePosition(
+	ctx context.Context, newPosInfo position.Position, isHedge bool,
+) ([]hedgeDelta, error) {
 	l := e.logger
 
+	newPosInfo.HedgeRatioBps = e.getHedgeRatioBps(newPosInfo.ID)
 	posInfo, ok := e.positionMap[newPosInfo.ID]
 	if ok {
 		newPosInfo.HedgedAmount0 = posInfo.HedgedAmount0
 		newPosInfo.HedgedAmount1 = posInfo.HedgedAmount1
-		if posInfo.Equal(newPosInfo) {
-			return nil
-		}
 	}
 
-	l.Infow("Update position's information", "info", newPosInfo.String())
+	if !ok || !posInfo.Equal(newPosInfo) {
+		l.Infow("Update position's information", "info", newPosInfo.String(), "hedgeRatioBps", newPosInfo.HedgeRatioBps)
+	}
 	e.positionMap[newPosInfo.ID] = newPosInfo
 
 	if !isHedge {
-		return nil
+		return nil, nil
 	}
 
-	if !ok {
-		if e.amountThresholdBps.Cmp(bps) < 0 {
-			amount0, err := e.hedgeToken(newPosInfo.Token0)
-			if err != nil {
-				l.Warnw("Fail to hedge for token", "token", newPosInfo.Token0.String(), "error", err)
-			}
+	// Unchanged positions are planned too, so hedges whose orders failed or were refused are retried.
+	var prev *position.Position
+	if ok {
+		prev = &posInfo
+	} else {
+		// Shorts adopted from the exchange are assigned to new positions instead of opening new ones.
+		e.adoptUnallocatedAmount(&newPosInfo, 0)
+		e.adoptUnallocatedAmount(&newPosInfo, 1)
+		e.positionMap[newPosInfo.ID] = newPosInfo
+	}
 
-			amount1, err := e.hedgeToken(newPosInfo.Token1)
-			if err != nil {
-				l.Warnw("Fail to hedge for token", "token", newPosInfo.Token1.String(), "error", err)
-			}
-			newPosInfo.HedgedAmount0 = amount0
-			newPosInfo.HedgedAmount1 = amount1
-			e.positionMap[newPosInfo.ID] = newPosInfo
+	plan, err := e.planPosition(ctx, prev, newPosInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.applyPlan(plan), nil
+}
+
+// addHedgeDelta appends the token's delta amount of a position to the deltas to hedge.
+// Stable tokens are considered hedged immediately.
+func (e *ElasticLM) addHedgeDelta(deltas []hedgeDelta, positionID string, tokenIndex int, token common.Token) []hedgeDelta {
+	if common.BigIsZero(token.Amount) {
+		return deltas
+	}
+
+	if token.IsStable() {
+		pos := e.positionMap[positionID]
+		pos.AddHedgedAmount(tokenIndex, token.Amount)
+		e.positionMap[positionID] = pos
+		return deltas
+	}
+
+	return append(deltas, hedgeDelta{PositionID: positionID, TokenIndex: tokenIndex, Token: token})
+}
+
+// hedgeDeltas nets the deltas of all positions per Binance symbol and sends one order for each symbol.
+// Residuals which were too small to trade are hedged together with the new deltas.
+func (e *ElasticLM) hedgeDeltas(ctx context.Context, deltas []hedgeDelta) {
+	deltas = append(deltas, e.takeResiduals()...)
+
+	var symbols []string
+	symbolDeltas := make(map[string][]hedgeDelta)
+	for _, delta := range deltas {
+		symbol := e.getBinancePerpetualSymbol(delta.Token)
+		if _, ok := symbolDeltas[symbol]; !ok {
+			symbols = append(symbols, symbol)
 		}
+		symbolDeltas[symbol] = append(symbolDeltas[symbol], delta)
+	}
+
+	for _, symbol := range symbols {
+		err := e.hedgeSymbol(ctx, symbol, symbolDeltas[symbol], e.execution.Style)
+		if err != nil {
+			e.logger.Warnw("Fail to hedge for symbol", "symbol", symbol, "error", err)
+		}
+	}
+}
+
+// hedgeSymbol sends one order for the net delta of a symbol's positions in the execution style.
+// Each position gets a share of the order in proportion to its delta, so positions moving in opposite directions
+// offset each other instead of trading. The hedged amounts are updated when the order is filled.
+func (e *ElasticLM) hedgeSymbol(ctx context.Context, symbol string, deltas []hedgeDelta, style ExecutionStyle) error {
+	precision := e.symbolInfoMap[symbol].QuantityPrecision
+	decimals := deltas[0].Token.Decimals
+
+	amounts := make([]*big.Int, len(deltas))
+	net := big.NewInt(0)
+	for i, delta := range deltas {
+		amounts[i] = common.ScaleAmount(delta.Token.Amount, delta.Token.Decimals, decimals)
+		net = common.BigAdd(net, amounts[i])
+	}
+
+	amount := common.BigAbs(net)
+	side := futures.SideTypeSell
+	reduceOnly := false
+	if net.Cmp(common.Big0) < 0 {
+		side = futures.SideTypeBuy
+		reduceOnly = true
+	}
+
+	quantity := common.RoundAmount(amount, decimals, precision, common.RoundTypeFloor)
+	if common.BigIsZero(quantity) {
+		e.keepResiduals(symbol, deltas, "quantity is below precision")
+		return nil
+	}
+
+	if _, ok := e.makerOrders[symbol]; ok {
+		e.keepResiduals(symbol, deltas, "symbol has a working maker order")
 		return nil
 	}
 
-	// Check deltaAmount threshold for hedging base on token0.
-	absThreshold := common.BigDiv(common.BigMul(posInfo.MaxAmount0, e.amountThresholdBps), bps)
-	token0 := newPosInfo.Token0
-	token0.Amount = common.BigSub(token0.Amount, posInfo.HedgedAmount0)
-	if common.BigAbs(token0.Amount).Cmp(absThreshold) <= 0 &&
-		newPosInfo.Token0.Amount.Cmp(newPosInfo.MaxAmount0) < 0 &&
-		newPosInfo.Token0.Amount.Cmp(common.Big0) > 0 {
-		l.Infow(
-			"Ignore hedging for small change of amount",
-			"token", token0,
-			"absThreshold", common.FormatAmount(absThreshold, token0.Decimals, 5),
+	if remaining := e.getCooldownRemaining(symbol); remaining > 0 {
+		e.logger.Infow(
+			"Skip hedging for symbol in cooldown",
+			"symbol", symbol,
+			"netAmount", common.FormatAmount(net, decimals, 5),
+			"remaining", remaining,
 		)
+		e.keepResiduals(symbol, deltas, "symbol is in cooldown")
 		return nil
 	}
 
-	// Hedge for token0 delta
-	amount0, err := e.hedgeToken(token0)
-	if err != nil {
-		l.Warnw("Fail to hedge for token", "token", token0, "error", err)
+	// Orders below the symbol's min notional are rejected unless they are reduce only.
+	if !reduceOnly {
+		ok, err := e.checkMinNotional(ctx, symbol, quantity, decimals)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			e.keepResiduals(symbol, deltas, "notional is below min notional")
+			return nil
+		}
 	}
-	newPosInfo.HedgedAmount0 = common.BigAdd(posInfo.HedgedAmount0, amount0)
 
-	// Hedge for token1 delta
-	token1 := newPosInfo.Token1
-	token1.Amount = common.BigSub(token1.Amount, posInfo.HedgedAmount1)
-	amount1, err := e.hedgeToken(token1)
+	ok, err := e.checkCost(ctx, symbol, deltas, side, quantity, decimals, style)
 	if err != nil {
-		l.Warnw("Fail to hedge for token", "token", token1, "error", err)
+		return err
+	}
+	if !ok {
+		e.keepResiduals(symbol, deltas, "rebalance is not worth its cost")
+		return nil
 	}
-	newPosInfo.HedgedAmount1 = common.BigAdd(posInfo.HedgedAmount1, amount1)
-
-	l.Infow("Update position hedged amounts", "newPosInfo", newPosInfo)
-	e.positionMap[posInfo.ID] = newPosInfo
 
-	return nil
-}
+	order := &pendingOrder{
+		Symbol:   symbol,
+		Decimals: decimals,
+		Side:     side,
+		Quantity: quantity,
+		Filled:   big.NewInt(0),
+	}
+	remaining := order.signedAmount(quantity)
+	for i, delta := range deltas {
+		share := remaining
+		if i < len(deltas)-1 {
+			share = common.BigDiv(common.BigMul(amounts[i], quantity), amount)
+		}
+		remaining = common.BigSub(remaining, share)
 
-func (e *ElasticLM) hedgeToken(token common.Token) (*big.Int, error) {
-	if token.IsStable() {
-		return token.Amount, nil
+		order.Allocations = append(order.Allocations, &orderAllocation{
+			PositionID: delta.PositionID,
+			TokenIndex: delta.TokenIndex,
+			Amount:     share,
+			Applied:    big.NewInt(0),
+		})
 	}
 
-	symbol := e.getBinancePerpetualSymbol(token)
-	symbolInfo := e.symbolInfoMap[symbol]
-	precision := symbolInfo.QuantityPrecision
+	e.lastOrderTimes[symbol] = time.Now()
 
-	amount := token.Amount
-	side := futures.SideTypeSell
-	reduceOnly := false
-	if amount.Cmp(common.Big0) < 0 {
-		amount = common.BigNeg(amount)
-		side = futures.SideTypeBuy
-		reduceOnly = true
+	sliceQuantity, err := e.getSliceQuantity(ctx, symbol, quantity, decimals, reduceOnly)
+	if err != nil {
+		return err
+	}
+	if sliceQuantity == nil && style == ExecutionStyleMaker {
+		e.createMakerOrder(order, reduceOnly)
+		return nil
 	}
 
-	amount = common.RoundAmount(amount, token.Decimals, precision, common.RoundTypeFloor)
-	if common.BigIsZero(amount) {
-		return common.Big0, nil
+	sliceQuantity, ok, err = e.guardSlippage(ctx, deltas, order, sliceQuantity, reduceOnly)
+	if err != nil || !ok {
+		return err
+	}
+	if sliceQuantity != nil {
+		return e.createParentOrder(order, sliceQuantity, reduceOnly)
 	}
 
 	e.logger.Infow(
-		"Hedging for token",
-		"token", token,
+		"Hedging for symbol",
+		"symbol", symbol,
+		"positions", len(deltas),
+		"netAmount", common.FormatAmount(net, decimals, 5),
 		"precision", precision,
-		"roundAmount", common.FormatAmount(amount, token.Decimals, 5),
+		"roundAmount", common.FormatAmount(quantity, decimals, 5),
 	)
 
-	resp, err := e.bclient.CreateFutureOrder(
-		context.Background(),
-		symbol,
-		common.FormatAmount(amount, token.Decimals, precision),
+	resp, err := e.createOrder(
+		ctx,
+		order,
+		common.FormatAmount(quantity, decimals, precision),
 		"0",
-		side,
+		"",
 		futures.OrderTypeMarket,
 		futures.TimeInForceTypeGTC,
 		reduceOnly,
 	)
 	if err != nil {
-		if strings.Contains(err.Error(), "code=-4164") {
-			return common.Big0, nil
+		if isMinNotionalError(err) {
+			e.keepResiduals(symbol, deltas, err.Error())
+			return nil
+		}
+		if errors.Is(err, errLimitExceeded) {
+			return nil
 		}
 		e.logger.Errorw("Fail to create future order", "error", err)
-		return common.Big0, err
+		return err
 	}
 
 	e.logger.Infow("Successfully create futures' order", "resp", resp)
 
-	if side == futures.SideTypeBuy {
-		amount = common.BigNeg(amount)
-	}
-
-	return amount, nil
+	return nil
 }
 
 func (e *ElasticLM) getPositions(ctx context.Context) ([]position.Position, error) {
@@ -267,11 +694,13 @@ func (e *ElasticLM) getPositions(ctx context.Context) ([]position.Position, erro
 
 	l.Debugw("Get positions' information", "positions", e.positionIDs)
 
-	positions, err := e.client.GetPositions(e.positionIDs)
+	positions, block, err := e.client.GetPositionsWithBlock(e.positionIDs)
 	if err != nil {
 		l.Errorw("Fail to get position liquidity", "positions", e.positionIDs, "error", err)
 		return nil, err
 	}
+	l.Debugw("Subgraph's latest block", "number", block.Number, "timestamp", block.Timestamp)
+	e.subgraphBlock = block
 
 	res := make([]position.Position, 0, len(positions))
 	for _, posData := range positions {
@@ -297,6 +726,10 @@ func (e *ElasticLM) getPositions(ctx context.Context) ([]position.Position, erro
 
 		sqrtPrice := common.NewBigIntFromString(posData.Pool.SqrtPrice, 10)
 		liquidity := common.NewBigIntFromString(posData.Liquidity, 10)
+		if posData.Owner == zeroAddress {
+			// The position's NFT is burned.
+			liquidity = big.NewInt(0)
+		}
 
 		token0Decimals, err := strconv.Atoi(posData.Pool.Token0.Decimals)
 		if err != nil {
@@ -321,6 +754,7 @@ func (e *ElasticLM) getPositions(ctx context.Context) ([]position.Position, erro
 			Liquidity:     liquidity,
 			TickLower:     tickLower,
 			TickUpper:     tickUpper,
+			Tick:          currentTick,
 			MaxAmount0:    maxAmount0,
 			MaxAmount1:    maxAmount1,
 			HedgedAmount0: big.NewInt(0),
@@ -346,12 +780,22 @@ func (e *ElasticLM) loadPositions() error {
 
 	l.Infow("Load open positions from database")
 	var positions []models.Position
-	err := e.db.Where("id IN ? AND liquidity > 0", e.positionIDs).Find(&positions).Error
+	err := e.db.Where("id IN ? AND closed_at IS NULL", e.positionIDs).Find(&positions).Error
 	if err != nil {
 		l.Errorw("Fail to get positions from database", "error", err)
 		return err
 	}
 
+	var closedIDs []string
+	err = e.db.Model(&models.Position{}).Where("id IN ? AND closed_at IS NOT NULL", e.positionIDs).Pluck("id", &closedIDs).Error
+	if err != nil {
+		l.Errorw("Fail to get archived positions from database", "error", err)
+		return err
+	}
+	for _, id := range closedIDs {
+		e.closedPositionIDs[id] = struct{}{}
+	}
+
 	l.Infow("Open positions from database loaded", "positions", positions)
 
 	for _, pos := range positions {
@@ -374,6 +818,7 @@ func (e *ElasticLM) loadPositions() error {
 			MaxAmount1:    maxAmount1,
 			HedgedAmount0: hedgedAmount0,
 			HedgedAmount1: hedgedAmount1,
+			HedgeRatioBps: pos.HedgeRatioBps,
 			Token0: common.Token{
 				Symbol:   pos.Symbol0,
 				Decimals: pos.Decimals0,
@@ -391,6 +836,10 @@ func (e *ElasticLM) loadPositions() error {
 }
 
 func (e *ElasticLM) savePositions() error {
+	return e.savePositionsWith(e.db)
+}
+
+func (e *ElasticLM) savePositionsWith(db *gorm.DB) error {
 	if len(e.positionMap) == 0 {
 		return nil
 	}
@@ -410,11 +859,31 @@ func (e *ElasticLM) savePositions() error {
 			Amount1:       pos.Token1.Amount.String(),
 			Decimals1:     pos.Token1.Decimals,
 			HedgedAmount1: pos.HedgedAmount1.String(),
+			HedgeRatioBps: pos.HedgeRatioBps,
 			UpdatedAt:     time.Now(),
 		})
 	}
 
-	return e.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&positions).Error
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&positions).Error
+}
+
+// validateHedgeRatios checks that no position has a negative hedge ratio.
+func (e *ElasticLM) validateHedgeRatios() error {
+	for positionID, ratio := range e.hedgeRatios {
+		if ratio < 0 {
+			return fmt.Errorf("invalid hedge ratio of position %s: %v", positionID, ratio)
+		}
+	}
+	return nil
+}
+
+// getHedgeRatioBps returns the position's hedge ratio in bps, scaled down by the hedges reduced on margin.
+func (e *ElasticLM) getHedgeRatioBps(positionID string) int64 {
+	ratio, ok := e.hedgeRatios[positionID]
+	if !ok {
+		ratio = 1
+	}
+	return int64(math.Round(ratio * e.marginHedgeScale * float64(bps.Int64())))
 }
 
 func (e *ElasticLM) getBinancePerpetualSymbol(token common.Token) string {
@@ -0,0 +1,348 @@
+package elasticlm
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/glebarez/sqlite"
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+	"github.com/hiepnv90/elastic-lm/pkg/graphql"
+	"github.com/hiepnv90/elastic-lm/pkg/models"
+	"github.com/hiepnv90/elastic-lm/pkg/position"
+	"github.com/hiepnv90/elastic-lm/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	testSymbol   = "ETHBUSD"
+	testDecimals = 18
+	testPrice    = 1000.0
+	// testPriceTick is the tick of a WETH/USDC pool at the test price.
+	testPriceTick = -207243
+)
+
+type testMarketData struct{}
+
+func (testMarketData) GetExchangeInfo(_ context.Context) (*futures.ExchangeInfo, error) {
+	return &futures.ExchangeInfo{
+		Symbols: []futures.Symbol{
+			{
+				Symbol:            testSymbol,
+				QuantityPrecision: 3,
+				PricePrecision:    2,
+				MarginAsset:       "BUSD",
+				Filters: []map[string]interface{}{
+					{"filterType": "MARKET_LOT_SIZE", "minQty": "0.001", "maxQty": "10", "stepSize": "0.001"},
+					{"filterType": "LOT_SIZE", "minQty": "0.001", "maxQty": "1000", "stepSize": "0.001"},
+					{"filterType": "PRICE_FILTER", "minPrice": "0.01", "maxPrice": "100000", "tickSize": "0.01"},
+					{"filterType": "MIN_NOTIONAL", "notional": "5"},
+				},
+			},
+		},
+	}, nil
+}
+
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+
+	// Every connection opens its own in-memory database, so all queries share one connection.
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	require.NoError(t, models.AutoMigrate(db, false))
+	return db
+}
+
+// newTestElasticLM returns an ElasticLM hedging on a simulated exchange which fills ETHBUSD at the test price.
+func newTestElasticLM(t *testing.T, opts Options) (*ElasticLM, *simulator.Exchange) {
+	return newTestElasticLMAt(t, simulator.StaticPrices{testSymbol: testPrice}, opts)
+}
+
+// newTestElasticLMAt returns an ElasticLM trading on a simulator at prices, which tests change to move the market.
+func newTestElasticLMAt(t *testing.T, prices simulator.StaticPrices, opts Options) (*ElasticLM, *simulator.Exchange) {
+	sim := simulator.New(testMarketData{}, prices, 4, map[string]float64{"BUSD": 1000000})
+	return newTestElasticLMOn(t, sim, opts), sim
+}
+
+// newTestElasticLMOn returns an ElasticLM trading on a simulator which the test sets up.
+func newTestElasticLMOn(t *testing.T, sim *simulator.Exchange, opts Options) *ElasticLM {
+	e := New(newTestDB(t), nil, sim, []string{"1", "2"}, 100, "BUSD", time.Minute, nil, opts)
+
+	exchangeInfo, err := sim.GetExchangeInfo(context.Background())
+	require.NoError(t, err)
+	for _, symbolInfo := range exchangeInfo.Symbols {
+		e.symbolInfoMap[symbolInfo.Symbol] = symbolInfo
+	}
+	return e
+}
+
+// testSubgraph serves the positions of its field as the subgraph's response.
+type testSubgraph struct {
+	positions []graphql.Position
+}
+
+// newTestSubgraph makes an ElasticLM read its positions from a test subgraph.
+func newTestSubgraph(t *testing.T, e *ElasticLM) *testSubgraph {
+	subgraph := &testSubgraph{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		var resp graphql.PositionsResponse
+		resp.Data.Positions = subgraph.positions
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+
+	e.client = graphql.New(srv.URL, nil)
+	return subgraph
+}
+
+// newTestSubgraphPosition returns a WETH/USDC position ranging 1000 ticks around the test price, which holds about
+// 1 WETH with the liquidity of 650000000000000.
+func newTestSubgraphPosition(id string, liquidity string) graphql.Position {
+	return graphql.Position{
+		ID:        id,
+		Owner:     "0x0000000000000000000000000000000000000001",
+		Liquidity: liquidity,
+		Pool: graphql.Pool{
+			SqrtPrice: common.GetSqrtRatioAtTick(testPriceTick).String(),
+			Tick:      strconv.Itoa(testPriceTick),
+			Token0:    graphql.Token{Symbol: "WETH", Decimals: "18"},
+			Token1:    graphql.Token{Symbol: "USDC", Decimals: "6"},
+		},
+		TickLower: graphql.Tick{TickIdx: strconv.Itoa(testPriceTick - 1000)},
+		TickUpper: graphql.Tick{TickIdx: strconv.Itoa(testPriceTick + 1000)},
+	}
+}
+
+func ethAmount(s string) *big.Int {
+	amount, err := common.ParseAmount(s, testDecimals)
+	if err != nil {
+		panic(err)
+	}
+	return amount
+}
+
+// newTestPosition returns a WETH/USDC position which holds amount0 of WETH and has hedged hedged0 of it.
+func newTestPosition(id string, amount0 string, hedged0 string) position.Position {
+	return position.Position{
+		ID:            id,
+		Liquidity:     big.NewInt(1),
+		HedgedAmount0: ethAmount(hedged0),
+		HedgedAmount1: big.NewInt(0),
+		HedgeRatioBps: 10000,
+		Token0:        common.Token{Amount: ethAmount(amount0), Symbol: "WETH", Decimals: testDecimals},
+		Token1:        common.Token{Amount: big.NewInt(1000000000), Symbol: "USDC", Decimals: 6},
+	}
+}
+
+func newTestDelta(positionID string, amount string) hedgeDelta {
+	return hedgeDelta{
+		PositionID: positionID,
+		TokenIndex: 0,
+		Token:      common.Token{Amount: ethAmount(amount), Symbol: "WETH", Decimals: testDecimals},
+	}
+}
+
+func newTestAllocation(positionID string, amount string) *orderAllocation {
+	return &orderAllocation{PositionID: positionID, TokenIndex: 0, Amount: ethAmount(amount), Applied: big.NewInt(0)}
+}
+
+func assertAmount(t *testing.T, expected string, actual *big.Int, msgAndArgs ...interface{}) {
+	t.Helper()
+	assert.Equal(t, ethAmount(expected).String(), actual.String(), msgAndArgs...)
+}
+
+func TestHedgeDeltasNetsPerSymbol(t *testing.T) {
+	tests := []struct {
+		name     string
+		deltas   []hedgeDelta
+		expected []string
+		short    float64
+	}{
+		{
+			name:     "net opposite deltas",
+			deltas:   []hedgeDelta{newTestDelta("1", "0.5"), newTestDelta("2", "-0.2")},
+			expected: []string{"0.5", "0"},
+			short:    0.3,
+		},
+		{
+			name:     "net delta below precision",
+			deltas:   []hedgeDelta{newTestDelta("1", "0.5004"), newTestDelta("2", "-0.2")},
+			expected: []string{"0.499733688415446071", "0.000266311584553929"},
+			short:    0.3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, sim := newTestElasticLM(t, Options{})
+			e.positionMap["1"] = newTestPosition("1", "1", "0")
+			e.positionMap["2"] = newTestPosition("2", "1", "0.2")
+
+			e.hedgeDeltas(context.Background(), test.deltas)
+			assert.Equal(t, test.short, getTestShort(sim), "one order for the net delta")
+			assertAmount(t, test.expected[0], e.positionMap["1"].HedgedAmount0)
+			assertAmount(t, test.expected[1], e.positionMap["2"].HedgedAmount0)
+			changed := common.BigAdd(e.positionMap["1"].HedgedAmount0, e.positionMap["2"].HedgedAmount0)
+			assertAmount(t, "0.5", changed, "shares add up to the order's quantity")
+			assert.Empty(t, e.pendingOrders)
+
+			orders, err := models.ListOrders(e.db, "", testSymbol, 0)
+			require.NoError(t, err)
+			require.Len(t, orders, 1)
+			allocations, err := models.ListOrderAllocations(e.db, orders[0].ID)
+			require.NoError(t, err)
+			assert.Len(t, allocations, 2)
+		})
+	}
+}
+
+func TestUpdatePositionsAppliesNewHedgeRatio(t *testing.T) {
+	// The threshold of 500 BUSD is above the changes of the hedge ratio.
+	e, sim := newTestElasticLM(t, Options{Rebalance: RebalanceOptions{ThresholdNotional: 500}})
+	subgraph := newTestSubgraph(t, e)
+	subgraph.positions = []graphql.Position{newTestSubgraphPosition("1", "650000000000000")}
+	require.NoError(t, e.updatePositions(context.Background(), true))
+	full := getTestShort(sim)
+	require.Greater(t, full, 0.9)
+
+	e.hedgeRatios = map[string]float64{"1": 0.7}
+	require.NoError(t, e.updatePositions(context.Background(), true))
+	assert.Equal(t, int64(7000), e.positionMap["1"].HedgeRatioBps)
+	assert.InDelta(t, full*0.7, getTestShort(sim), 0.002, "new hedge ratio is applied")
+
+	e.marginHedgeScale = 0.5
+	require.NoError(t, e.updatePositions(context.Background(), true))
+	assert.Equal(t, int64(3500), e.positionMap["1"].HedgeRatioBps)
+	assert.InDelta(t, full*0.35, getTestShort(sim), 0.002, "new hedge scale is applied")
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		interval time.Duration
+		expected string
+	}{
+		{name: "defaults"},
+		{name: "invalid interval", interval: -time.Second, expected: "invalid interval: -1s"},
+		{
+			name:     "polling volatility without half-life",
+			opts:     Options{Polling: PollingOptions{MinInterval: time.Second, VolatilityBps: 100}},
+			expected: "invalid polling settings: polling volatility half-life 0s must be positive",
+		},
+		{
+			name:     "invalid reconcile mode",
+			opts:     Options{Reconcile: ReconcileOptions{Mode: "fix"}},
+			expected: "invalid reconcile settings: invalid reconcile mode: fix",
+		},
+		{
+			name:     "negative reconcile interval",
+			opts:     Options{Reconcile: ReconcileOptions{Mode: ReconcileModeReport, Interval: -time.Minute}},
+			expected: "invalid reconcile settings: negative reconcile interval: -1m0s",
+		},
+		{
+			name:     "target band above threshold",
+			opts:     Options{Rebalance: RebalanceOptions{TargetBps: 100}},
+			expected: "invalid rebalance settings: target band 100 bps must be less than threshold 100 bps",
+		},
+		{
+			name:     "target band above threshold in notional",
+			opts:     Options{Rebalance: RebalanceOptions{ThresholdNotional: 50, TargetNotional: 50}},
+			expected: "invalid rebalance settings: target band 50 must be less than threshold 50 in notional",
+		},
+		{
+			name:     "unknown hedge mode of position",
+			opts:     Options{HedgeModes: map[string]HedgeMode{"1": "grid"}},
+			expected: "invalid hedge mode settings: invalid hedge mode of position 1: grid",
+		},
+		{
+			name:     "ladder without rungs",
+			opts:     Options{HedgeMode: HedgeModeLadder},
+			expected: "invalid hedge mode settings: ladder needs at least 2 rungs: 0",
+		},
+		{
+			name:     "negative ladder slippage",
+			opts:     Options{Ladder: LadderOptions{SlippageBps: -1}},
+			expected: "invalid ladder settings: invalid ladder slippage: -1 bps",
+		},
+		{
+			name:     "negative TWAP duration",
+			opts:     Options{TWAP: TWAPOptions{Duration: -time.Hour}},
+			expected: "invalid TWAP settings: negative TWAP duration: -1h0m0s",
+		},
+		{
+			name:     "maker without timeout",
+			opts:     Options{Execution: ExecutionOptions{Style: ExecutionStyleMaker}},
+			expected: "invalid execution settings: maker timeout 0s must be positive",
+		},
+		{
+			name:     "invalid slippage action",
+			opts:     Options{Slippage: SlippageOptions{MaxBps: 10, Action: "wait"}},
+			expected: "invalid slippage settings: invalid slippage action: wait",
+		},
+		{
+			name:     "cost without volatility half-life",
+			opts:     Options{Cost: CostOptions{Horizon: time.Hour}},
+			expected: "invalid cost settings: cost volatility half-life 0s must be positive",
+		},
+		{
+			name:     "negative max lag of price estimate",
+			opts:     Options{PriceEstimate: PriceEstimateOptions{Enabled: true, MaxLag: -time.Minute}},
+			expected: "invalid price estimate settings: negative max lag of subgraph: -1m0s",
+		},
+		{
+			name:     "negative limit of symbol",
+			opts:     Options{Limits: LimitOptions{SymbolMaxNotionals: map[string]float64{testSymbol: -1}}},
+			expected: "invalid limit settings: negative limit of symbol ETHBUSD: -1",
+		},
+		{
+			name:     "invalid limit action",
+			opts:     Options{Limits: LimitOptions{Action: "drop"}},
+			expected: "invalid limit settings: invalid limit action: drop",
+		},
+		{
+			name:     "margin top-up without amount",
+			opts:     Options{Margin: MarginOptions{TopUpRatio: 0.5}},
+			expected: "invalid margin settings: top-up at margin ratio 0.5 needs an amount",
+		},
+		{
+			name:     "leverage above max",
+			opts:     Options{Leverage: LeverageOptions{Leverage: maxLeverage + 1}},
+			expected: "invalid leverage settings: invalid leverage: 126",
+		},
+		{
+			name:     "negative hedge ratio",
+			opts:     Options{HedgeRatios: map[string]float64{"1": -0.5}},
+			expected: "invalid hedge ratio settings: invalid hedge ratio of position 1: -0.5",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, _ := newTestElasticLM(t, test.opts)
+			if test.interval != 0 {
+				e.interval = test.interval
+			}
+
+			err := e.validate()
+			if test.expected == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.expected)
+			}
+		})
+	}
+}
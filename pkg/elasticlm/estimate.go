@@ -0,0 +1,119 @@
+package elasticlm
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+	"github.com/hiepnv90/elastic-lm/pkg/position"
+)
+
+// PriceEstimateOptions holds the settings of estimating positions from mark prices between subgraph updates.
+type PriceEstimateOptions struct {
+	// With Enabled, positions are valued at the pool prices implied by mark prices instead of the subgraph's.
+	Enabled bool
+	// Estimates deviating from the subgraph's prices by more than MaxDeviationBps are not used.
+	MaxDeviationBps float64
+	// The subgraph's prices are preferred when they lag the latest block by at most MaxLag.
+	MaxLag time.Duration
+}
+
+func (o PriceEstimateOptions) validate() error {
+	if o.MaxDeviationBps < 0 {
+		return fmt.Errorf("negative max deviation of estimates: %v bps", o.MaxDeviationBps)
+	}
+	if o.MaxLag < 0 {
+		return fmt.Errorf("negative max lag of subgraph: %s", o.MaxLag)
+	}
+	return nil
+}
+
+// estimatePositions replaces the pool prices of the subgraph, which may lag the chain, with the prices implied by
+// the mark prices of the positions' tokens. The liquidity and ticks of positions still come from the subgraph.
+// Fresh subgraph data is used as is, so the estimates are corrected as soon as the subgraph catches up.
+func (e *ElasticLM) estimatePositions(ctx context.Context, posInfos []position.Position) []position.Position {
+	if !e.priceEstimate.Enabled || e.bclient == nil {
+		return posInfos
+	}
+
+	if lag, ok := e.getSubgraphLag(); ok && e.priceEstimate.MaxLag > 0 && lag <= e.priceEstimate.MaxLag {
+		e.logger.Debugw("Subgraph is fresh, use subgraph's prices", "lag", lag, "maxLag", e.priceEstimate.MaxLag)
+		return posInfos
+	}
+
+	for i, pos := range posInfos {
+		posInfos[i] = e.estimatePosition(ctx, pos)
+	}
+	return posInfos
+}
+
+// estimatePosition returns the position with the token amounts at the pool price implied by mark prices.
+// The position is returned as is when the implied price can't be found, or deviates from the subgraph's price by more
+// than the max.
+func (e *ElasticLM) estimatePosition(ctx context.Context, pos position.Position) position.Position {
+	l := e.logger.With("positionID", pos.ID)
+
+	if pos.IsClosed() || (pos.Token0.IsStable() && pos.Token1.IsStable()) {
+		return pos
+	}
+
+	price0, err := e.getTokenPrice(ctx, pos.Token0)
+	if err != nil {
+		l.Warnw("Fail to get price of token, use subgraph's price", "token", pos.Token0.Symbol, "error", err)
+		return pos
+	}
+	price1, err := e.getTokenPrice(ctx, pos.Token1)
+	if err != nil {
+		l.Warnw("Fail to get price of token, use subgraph's price", "token", pos.Token1.Symbol, "error", err)
+		return pos
+	}
+
+	// The pool price is the amount of token1 per token0 in their smallest units.
+	price := price0 / price1 * math.Pow10(pos.Token1.Decimals-pos.Token0.Decimals)
+	sqrtPrice := common.GetSqrtRatioAtPrice(price)
+	tick := common.GetTickAtSqrtRatio(sqrtPrice)
+
+	deviationBps := (math.Pow(1.0001, math.Abs(float64(tick-pos.Tick))) - 1) * 10000
+	if e.priceEstimate.MaxDeviationBps > 0 && deviationBps > e.priceEstimate.MaxDeviationBps {
+		l.Warnw(
+			"Estimated price of position deviates from subgraph, use subgraph's price",
+			"subgraphTick", pos.Tick,
+			"estimatedTick", tick,
+			"deviationBps", deviationBps,
+			"maxDeviationBps", e.priceEstimate.MaxDeviationBps,
+		)
+		return pos
+	}
+
+	amount0, amount1 := common.ExtractLiquidity(tick, pos.TickLower, pos.TickUpper, sqrtPrice, pos.Liquidity)
+	l.Debugw(
+		"Estimate position from mark prices",
+		"subgraphTick", pos.Tick,
+		"estimatedTick", tick,
+		"deviationBps", deviationBps,
+	)
+
+	pos.Tick = tick
+	pos.Token0.Amount = amount0
+	pos.Token1.Amount = amount1
+	return pos
+}
+
+// getTokenPrice returns the price of a token in quote currency, stable tokens are priced at 1.
+func (e *ElasticLM) getTokenPrice(ctx context.Context, token common.Token) (float64, error) {
+	if token.IsStable() {
+		return 1, nil
+	}
+	return e.getMarkPrice(ctx, e.getBinancePerpetualSymbol(token))
+}
+
+// getSubgraphLag returns how long ago the subgraph's latest block was mined, it isn't known when the subgraph doesn't
+// return the block's timestamp.
+func (e *ElasticLM) getSubgraphLag() (time.Duration, bool) {
+	if e.subgraphBlock.Timestamp <= 0 {
+		return 0, false
+	}
+	return time.Since(time.Unix(e.subgraphBlock.Timestamp, 0)), true
+}
@@ -0,0 +1,87 @@
+package elasticlm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+	"github.com/hiepnv90/elastic-lm/pkg/graphql"
+	"github.com/hiepnv90/elastic-lm/pkg/position"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimatePosition(t *testing.T) {
+	tests := []struct {
+		name            string
+		subgraphTick    int
+		maxDeviationBps float64
+		estimated       bool
+	}{
+		{name: "subgraph lags", subgraphTick: testPriceTick - 200, estimated: true},
+		{name: "deviation within max", subgraphTick: testPriceTick - 200, maxDeviationBps: 500, estimated: true},
+		{name: "deviation above max", subgraphTick: testPriceTick - 200, maxDeviationBps: 100},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, _ := newTestElasticLM(t, Options{
+				PriceEstimate: PriceEstimateOptions{Enabled: true, MaxDeviationBps: test.maxDeviationBps},
+			})
+			pos := newTestLadderPosition()
+			pos.Tick = test.subgraphTick
+
+			estimated := e.estimatePosition(context.Background(), pos)
+			if !test.estimated {
+				assert.Equal(t, pos, estimated)
+				return
+			}
+
+			// The mark price of 1000 BUSD is the pool price of 1000 USDC per WETH in their smallest units.
+			assert.InDelta(t, testPriceTick, estimated.Tick, 1)
+			amount0, amount1 := common.ExtractLiquidity(
+				estimated.Tick, pos.TickLower, pos.TickUpper, common.GetSqrtRatioAtPrice(1e-9), pos.Liquidity,
+			)
+			assert.InDelta(t, common.AmountToFloat(amount0, 18), common.AmountToFloat(estimated.Token0.Amount, 18), 1e-9)
+			assert.InDelta(t, common.AmountToFloat(amount1, 6), common.AmountToFloat(estimated.Token1.Amount, 6), 1e-6)
+			assert.Equal(t, pos.Liquidity, estimated.Liquidity)
+		})
+	}
+}
+
+func TestEstimatePositions(t *testing.T) {
+	tests := []struct {
+		name       string
+		blockTime  time.Time
+		noExchange bool
+		estimated  bool
+	}{
+		{name: "subgraph is fresh", blockTime: time.Now()},
+		{name: "subgraph lags", blockTime: time.Now().Add(-10 * time.Minute), estimated: true},
+		{name: "block time is unknown", estimated: true},
+		{name: "no exchange", noExchange: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, _ := newTestElasticLM(t, Options{PriceEstimate: PriceEstimateOptions{Enabled: true, MaxLag: time.Minute}})
+			if !test.blockTime.IsZero() {
+				e.subgraphBlock = graphql.Block{Number: 1, Timestamp: test.blockTime.Unix()}
+			}
+			if test.noExchange {
+				e.bclient = nil
+			}
+			pos := newTestLadderPosition()
+			pos.Tick = testPriceTick - 200
+
+			estimated := e.estimatePositions(context.Background(), []position.Position{pos})
+			require.Len(t, estimated, 1)
+			if test.estimated {
+				assert.InDelta(t, testPriceTick, estimated[0].Tick, 1)
+			} else {
+				assert.Equal(t, pos, estimated[0], "subgraph's prices are used")
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package elasticlm
+
+import (
+	"context"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// Exchange is the set of futures operations ElasticLM uses for hedging.
+// It is implemented by binance.Client for live trading and by simulator.Exchange for paper trading.
+type Exchange interface {
+	GetExchangeInfo(ctx context.Context) (*futures.ExchangeInfo, error)
+	CreateFutureOrder(
+		ctx context.Context,
+		symbol string,
+		quantity string,
+		price string,
+		stopPrice string,
+		side futures.SideType,
+		orderType futures.OrderType,
+		timeInForce futures.TimeInForceType,
+		reduceOnly bool,
+		positionSide futures.PositionSideType,
+		clientOrderID string,
+	) (*futures.CreateOrderResponse, error)
+	GetFutureOrder(ctx context.Context, symbol string, clientOrderID string) (*futures.Order, error)
+	CancelFutureOrder(ctx context.Context, symbol string, clientOrderID string) (*futures.CancelOrderResponse, error)
+	GetPositionRisk(ctx context.Context, symbol string) ([]*futures.PositionRisk, error)
+	GetPremiumIndex(ctx context.Context, symbol string) ([]*futures.PremiumIndex, error)
+	GetBookTicker(ctx context.Context, symbol string) (*futures.BookTicker, error)
+	GetOrderBook(ctx context.Context, symbol string, limit int) (*futures.DepthResponse, error)
+	ListenUserData(
+		ctx context.Context,
+		handler futures.WsUserDataHandler,
+	) (listenKey string, doneC, stopC chan struct{}, err error)
+	KeepaliveUserData(ctx context.Context, listenKey string) error
+	GetAccount(ctx context.Context) (*futures.Account, error)
+	TransferToFutures(ctx context.Context, asset string, amount string) error
+	GetPositionMode(ctx context.Context) (*futures.PositionMode, error)
+	ChangeLeverage(ctx context.Context, symbol string, leverage int) (*futures.SymbolLeverage, error)
+	ChangeMarginType(ctx context.Context, symbol string, marginType futures.MarginType) error
+}
@@ -0,0 +1,260 @@
+package elasticlm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+	"github.com/hiepnv90/elastic-lm/pkg/models"
+)
+
+const (
+	// flattenTimeout is how long flattening on exit waits for the closing orders to finish.
+	flattenTimeout = 30 * time.Second
+	// flattenPollInterval is the interval of looking up the closing orders while waiting for them.
+	flattenPollInterval = time.Second
+)
+
+// RequestFlatten asks the running ElasticLM to stop hedging and close all shorts, e.g. on a signal.
+func (e *ElasticLM) RequestFlatten() {
+	select {
+	case e.flattenC <- struct{}{}:
+	default:
+	}
+}
+
+// Flatten closes the shorts of all stored positions while ElasticLM isn't running, e.g. from the command line.
+// It cancels the open orders of the journal first, and waits until the closing orders are finished. A running
+// ElasticLM doesn't see its changes, so it must be flattened with RequestFlatten instead.
+func (e *ElasticLM) Flatten(ctx context.Context) error {
+	if e.bclient == nil {
+		return errors.New("no exchange to flatten shorts on")
+	}
+
+	err := e.loadExchangeInfo(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = e.loadPositionMode(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = e.loadPositions()
+	if err != nil {
+		e.logger.Errorw("Fail to load saved positions from database", "error", err)
+		return err
+	}
+
+	err = e.restoreOrders(ctx)
+	if err != nil {
+		return err
+	}
+
+	e.flatten(ctx)
+	return e.waitPendingOrders(ctx)
+}
+
+// flattenOnStop flattens all shorts after Run's context is cancelled, waiting for the closing orders up to the
+// flatten timeout.
+func (e *ElasticLM) flattenOnStop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), flattenTimeout)
+	defer cancel()
+
+	e.flatten(ctx)
+	err := e.waitPendingOrders(ctx)
+	if err != nil {
+		e.logger.Errorw("Fail to flatten shorts on exit", "error", err)
+	}
+	return err
+}
+
+// loadHalted loads whether hedging is halted by an earlier flattening. It stays halted across restarts until it is
+// resumed from the command line.
+func (e *ElasticLM) loadHalted(ctx context.Context) error {
+	value, err := models.GetSetting(e.db, models.SettingHalted)
+	if err != nil {
+		e.logger.Errorw("Fail to load halted setting", "error", err)
+		return err
+	}
+
+	e.halted = value != ""
+	if e.halted {
+		e.logger.Warnw("Hedging is halted, positions are only monitored", "haltedAt", value)
+		e.alert(ctx, "flatten", fmt.Sprintf("Hedging is halted since %s, run the resume command to hedge again", value))
+	}
+	return nil
+}
+
+// flatten stops hedging, cancels all working orders and closes the stored hedged amounts of every symbol with
+// reduce-only market orders. Positions are still monitored, but not hedged anymore until hedging is resumed.
+func (e *ElasticLM) flatten(ctx context.Context) {
+	e.halted = true
+	e.logger.Warnw("Stop hedging and flatten all shorts")
+	e.alert(ctx, "flatten", "Hedging is stopped, all shorts are being closed")
+
+	err := models.SetSetting(e.db, models.SettingHalted, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		e.logger.Errorw("Fail to save halted setting", "error", err)
+		e.alert(ctx, "flatten:halted", fmt.Sprintf("Fail to save that hedging is halted, it resumes on restart: %v", err))
+	}
+
+	e.makerOrders = make(map[string]*makerOrder)
+	e.ladders = make(map[string]*ladder)
+	e.residuals = make(map[hedgeLeg]hedgeDelta)
+
+	parentIDs := make([]uint64, 0, len(e.parentOrders))
+	for id := range e.parentOrders {
+		parentIDs = append(parentIDs, id)
+	}
+	sort.Slice(parentIDs, func(i, j int) bool { return parentIDs[i] < parentIDs[j] })
+	for _, id := range parentIDs {
+		e.finishParentOrder(e.parentOrders[id], models.ParentOrderStatusCanceled)
+	}
+
+	// Orders which can't be cancelled are looked up, their fills stay as shorts after flattening.
+	clientOrderIDs := make([]string, 0, len(e.pendingOrders))
+	for clientOrderID := range e.pendingOrders {
+		clientOrderIDs = append(clientOrderIDs, clientOrderID)
+	}
+	sort.Strings(clientOrderIDs)
+	for _, clientOrderID := range clientOrderIDs {
+		e.cancelOrder(ctx, e.pendingOrders[clientOrderID])
+	}
+
+	legs := e.getHedgeLegs()
+	symbols := make([]string, 0, len(legs))
+	for symbol := range legs {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	for _, symbol := range symbols {
+		err := e.closeShorts(ctx, symbol, legs[symbol], 1)
+		if err != nil {
+			e.alert(ctx, "flatten:"+symbol, fmt.Sprintf("Fail to close shorts of %s: %v", symbol, err))
+		}
+	}
+}
+
+// closeShorts sends reduce-only market orders for a fraction of the positive hedged amounts of a symbol's legs, in
+// orders of at most the symbol's max quantity of market orders and the max order notional.
+func (e *ElasticLM) closeShorts(ctx context.Context, symbol string, legs []hedgeLeg, fraction float64) error {
+	l := e.logger.With("symbol", symbol)
+	decimals := e.getSymbolDecimals(symbol, legs)
+	precision := e.symbolInfoMap[symbol].QuantityPrecision
+
+	fractionBps := big.NewInt(int64(math.Round(fraction * float64(bps.Int64()))))
+
+	var allocations []*orderAllocation
+	total := big.NewInt(0)
+	for _, leg := range legs {
+		pos := e.positionMap[leg.PositionID]
+		amount := common.ScaleAmount(pos.HedgedAmount(leg.TokenIndex), pos.Token(leg.TokenIndex).Decimals, decimals)
+		amount = common.BigDiv(common.BigMul(amount, fractionBps), bps)
+		if amount.Sign() <= 0 {
+			continue
+		}
+		allocations = append(allocations, &orderAllocation{
+			PositionID: leg.PositionID,
+			TokenIndex: leg.TokenIndex,
+			Amount:     common.BigNeg(amount),
+		})
+		total = common.BigAdd(total, amount)
+	}
+
+	remaining := common.RoundAmount(total, decimals, precision, common.RoundTypeFloor)
+	if common.BigIsZero(remaining) {
+		return nil
+	}
+
+	maxQuantity, err := e.getMaxMarketQuantity(symbol, decimals)
+	if err != nil {
+		return err
+	}
+	limitQuantity, err := e.getMaxOrderQuantity(ctx, symbol, decimals)
+	if err != nil {
+		return err
+	}
+	if limitQuantity != nil && !common.BigIsZero(limitQuantity) &&
+		(maxQuantity == nil || limitQuantity.Cmp(maxQuantity) < 0) {
+		maxQuantity = limitQuantity
+	}
+
+	for !common.BigIsZero(remaining) {
+		quantity := remaining
+		if maxQuantity != nil && quantity.Cmp(maxQuantity) > 0 {
+			quantity = maxQuantity
+		}
+
+		order := &pendingOrder{
+			Symbol:   symbol,
+			Decimals: decimals,
+			Side:     futures.SideTypeBuy,
+			Quantity: quantity,
+			Filled:   big.NewInt(0),
+		}
+		rest := common.BigNeg(quantity)
+		for i, allocation := range allocations {
+			share := rest
+			if i < len(allocations)-1 {
+				share = common.BigDiv(common.BigMul(allocation.Amount, quantity), total)
+			}
+			rest = common.BigSub(rest, share)
+			allocation.Amount = common.BigSub(allocation.Amount, share)
+
+			order.Allocations = append(order.Allocations, &orderAllocation{
+				PositionID: allocation.PositionID,
+				TokenIndex: allocation.TokenIndex,
+				Amount:     share,
+				Applied:    big.NewInt(0),
+			})
+		}
+		total = common.BigSub(total, quantity)
+
+		l.Infow("Close shorts of symbol", "quantity", common.FormatAmount(quantity, decimals, 5), "fraction", fraction)
+		_, err = e.createOrder(
+			ctx,
+			order,
+			common.FormatAmount(quantity, decimals, precision),
+			"0",
+			"",
+			futures.OrderTypeMarket,
+			futures.TimeInForceTypeGTC,
+			true,
+		)
+		if err != nil {
+			l.Errorw("Fail to close shorts of symbol", "error", err)
+			return err
+		}
+
+		remaining = common.BigSub(remaining, quantity)
+	}
+	return nil
+}
+
+// waitPendingOrders looks up the pending orders until all of them are finished.
+func (e *ElasticLM) waitPendingOrders(ctx context.Context) error {
+	ticker := time.NewTicker(flattenPollInterval)
+	defer ticker.Stop()
+
+	for {
+		e.resyncPendingOrders(ctx)
+		if len(e.pendingOrders) == 0 {
+			e.logger.Infow("Closing orders are finished")
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d orders are still pending: %w", len(e.pendingOrders), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
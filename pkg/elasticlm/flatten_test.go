@@ -0,0 +1,91 @@
+package elasticlm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/hiepnv90/elastic-lm/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloseShorts(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           Options
+		hedged         [2]string
+		fraction       float64
+		expectedOrders []string
+		expectedHedged [2]string
+	}{
+		{
+			name:           "fraction of shorts",
+			hedged:         [2]string{"0.3", "0.1"},
+			fraction:       0.5,
+			expectedOrders: []string{"0.200"},
+			expectedHedged: [2]string{"0.15", "0.05"},
+		},
+		{
+			name:           "above max market quantity",
+			hedged:         [2]string{"12", "6"},
+			fraction:       1,
+			expectedOrders: []string{"10.000", "8.000"},
+			expectedHedged: [2]string{"0", "0"},
+		},
+		{
+			name:           "above max order notional",
+			opts:           Options{Limits: LimitOptions{MaxOrderNotional: 8000}},
+			hedged:         [2]string{"12", "6"},
+			fraction:       1,
+			expectedOrders: []string{"8.000", "8.000", "2.000"},
+			expectedHedged: [2]string{"0", "0"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, sim := newTestElasticLM(t, test.opts)
+			e.positionMap["1"] = newTestPosition("1", test.hedged[0], test.hedged[0])
+			e.positionMap["2"] = newTestPosition("2", test.hedged[1], test.hedged[1])
+			openTestShort(t, sim, "10")
+			openTestShort(t, sim, "8")
+
+			legs := e.getHedgeLegs()
+			e.closeShorts(context.Background(), testSymbol, legs[testSymbol], test.fraction)
+
+			var rows []models.Order
+			require.NoError(t, e.db.Order("id").Find(&rows).Error)
+			require.Len(t, rows, len(test.expectedOrders))
+			for i, row := range rows {
+				assert.True(t, row.ReduceOnly)
+				assert.Equal(t, test.expectedOrders[i], row.Quantity, "order %d", i)
+			}
+			assertAmount(t, test.expectedHedged[0], e.positionMap["1"].HedgedAmount0)
+			assertAmount(t, test.expectedHedged[1], e.positionMap["2"].HedgedAmount0)
+		})
+	}
+}
+
+func TestFlattenHaltsHedging(t *testing.T) {
+	e, sim := newTestElasticLM(t, Options{})
+	e.positionMap["1"] = newTestPosition("1", "1", "0")
+	e.hedgeDeltas(context.Background(), []hedgeDelta{newTestDelta("1", "0.5")})
+	require.Equal(t, 0.5, getTestShort(sim))
+	working := placeTestLimitSell(t, e, "0.2", newTestAllocation("1", "0.2"))
+
+	e.flatten(context.Background())
+	assert.True(t, e.halted)
+	assert.Empty(t, e.pendingOrders, "working orders are cancelled")
+	assert.Equal(t, string(futures.OrderStatusTypeCanceled), getJournalOrder(t, e, working.ClientOrderID).Status)
+	assert.Equal(t, 0.0, getTestShort(sim))
+	assertAmount(t, "0", e.positionMap["1"].HedgedAmount0)
+
+	restarted := restartTestElasticLM(t, e, Options{})
+	require.NoError(t, restarted.loadHalted(context.Background()))
+	assert.True(t, restarted.halted, "halt is kept across restarts")
+
+	require.NoError(t, models.SetSetting(e.db, models.SettingHalted, ""))
+	require.NoError(t, restarted.loadHalted(context.Background()))
+	assert.False(t, restarted.halted, "resumed hedging")
+}
@@ -0,0 +1,165 @@
+package elasticlm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+	"github.com/hiepnv90/elastic-lm/pkg/position"
+	"github.com/hiepnv90/elastic-lm/pkg/strategy"
+)
+
+// HedgeMode is the name of the strategy which decides the hedge of a position.
+type HedgeMode string
+
+const (
+	// HedgeModeDynamic hedges the current token amounts and rebalances when the price moves.
+	HedgeModeDynamic HedgeMode = strategy.DynamicName
+	// HedgeModeStatic hedges the max token amounts, which are the amounts at the edges of the range.
+	HedgeModeStatic HedgeMode = strategy.StaticName
+	// HedgeModeLadder hedges with a ladder of resting stop orders across the range.
+	HedgeModeLadder HedgeMode = strategy.LadderName
+)
+
+func (e *ElasticLM) validateHedgeModes() error {
+	if _, ok := e.strategies[string(e.getHedgeMode(""))]; !ok {
+		return fmt.Errorf("invalid hedge mode: %s", e.hedgeMode)
+	}
+
+	for positionID, mode := range e.hedgeModes {
+		if _, ok := e.strategies[string(e.getHedgeMode(positionID))]; !ok {
+			return fmt.Errorf("invalid hedge mode of position %s: %s", positionID, mode)
+		}
+	}
+
+	if e.usesHedgeMode(HedgeModeLadder) && e.ladder.Rungs < 2 {
+		return fmt.Errorf("ladder needs at least 2 rungs: %d", e.ladder.Rungs)
+	}
+
+	if e.getHedgeMode("") == HedgeModeDynamic && e.amountThresholdBps.Cmp(bps) >= 0 {
+		e.logger.Warnw(
+			"New positions in dynamic mode are not hedged with amount threshold of 10000 bps or more",
+			"amountThresholdBps", e.amountThresholdBps,
+		)
+	}
+
+	return nil
+}
+
+func (e *ElasticLM) getHedgeMode(positionID string) HedgeMode {
+	mode, ok := e.hedgeModes[positionID]
+	if !ok || mode == "" {
+		mode = e.hedgeMode
+	}
+	if mode == "" {
+		return HedgeModeDynamic
+	}
+	return mode
+}
+
+func (e *ElasticLM) usesHedgeMode(mode HedgeMode) bool {
+	if e.getHedgeMode("") == mode {
+		return true
+	}
+	for positionID := range e.hedgeModes {
+		if e.getHedgeMode(positionID) == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// newStrategies returns the built-in strategies and the custom ones by name.
+func (e *ElasticLM) newStrategies(custom []strategy.Strategy) map[string]strategy.Strategy {
+	dynamic := strategy.Dynamic{
+		ThresholdBps:      e.amountThresholdBps.Int64(),
+		TargetBps:         int64(e.rebalance.TargetBps),
+		ThresholdNotional: e.rebalance.ThresholdNotional,
+		TargetNotional:    e.rebalance.TargetNotional,
+	}
+	strategies := map[string]strategy.Strategy{
+		strategy.DynamicName: dynamic,
+		strategy.StaticName:  strategy.Static{Dynamic: dynamic},
+		strategy.LadderName:  strategy.Ladder{Dynamic: dynamic, Rungs: e.ladder.Rungs},
+	}
+	for _, s := range custom {
+		strategies[s.Name()] = s
+	}
+	return strategies
+}
+
+// marketData provides mark prices to strategies during an update of positions.
+type marketData struct {
+	ctx context.Context
+	e   *ElasticLM
+}
+
+func (m marketData) MarkPrice(symbol string) (float64, error) {
+	if m.e.bclient == nil {
+		return 0, fmt.Errorf("no exchange to get mark price of symbol %s", symbol)
+	}
+
+	markPrice, err := m.e.getMarkPrice(m.ctx, symbol)
+	if err != nil {
+		m.e.logger.Warnw("Fail to get mark price, use amount threshold in bps instead", "symbol", symbol, "error", err)
+	}
+	return markPrice, err
+}
+
+// planPosition returns the hedge plan of a position by its strategy.
+// The previous information is nil for new positions.
+func (e *ElasticLM) planPosition(
+	ctx context.Context, prev *position.Position, pos position.Position,
+) (strategy.Plan, error) {
+	mode := e.getHedgeMode(pos.ID)
+	s, ok := e.strategies[string(mode)]
+	if !ok {
+		return strategy.Plan{}, fmt.Errorf("unknown hedge mode: %s", mode)
+	}
+
+	in := strategy.Input{
+		Position: pos,
+		Previous: prev,
+		Market:   marketData{ctx: ctx, e: e},
+	}
+	for tokenIndex := 0; tokenIndex < 2; tokenIndex++ {
+		// Amounts of orders waiting to be filled are counted as hedged to avoid hedging twice.
+		in.HedgedAmounts[tokenIndex] = common.BigAdd(pos.HedgedAmount(tokenIndex), e.getPendingAmount(pos.ID, tokenIndex))
+		in.Symbols[tokenIndex] = e.getBinancePerpetualSymbol(pos.Token(tokenIndex))
+	}
+
+	return s.Plan(in)
+}
+
+// applyPlan returns the deltas of a plan's targets to hedge.
+// The plan's deltas replace the residuals of the tokens which it evaluates.
+func (e *ElasticLM) applyPlan(plan strategy.Plan) []hedgeDelta {
+	var deltas []hedgeDelta
+	for _, target := range plan.Targets {
+		delete(e.residuals, hedgeLeg{PositionID: plan.PositionID, TokenIndex: target.TokenIndex})
+
+		token := target.Token
+		token.Amount = target.Delta
+		if common.BigIsZero(token.Amount) {
+			e.logger.Debugw(
+				"Keep hedge of token",
+				"positionID", plan.PositionID,
+				"strategy", plan.Strategy,
+				"token", token.Symbol,
+				"reason", target.Reason,
+			)
+			continue
+		}
+
+		e.logger.Infow(
+			"Plan hedge of token",
+			"positionID", plan.PositionID,
+			"strategy", plan.Strategy,
+			"token", token.Symbol,
+			"delta", common.FormatAmount(token.Amount, token.Decimals, 5),
+			"reason", target.Reason,
+		)
+		deltas = e.addHedgeDelta(deltas, plan.PositionID, target.TokenIndex, token)
+	}
+	return deltas
+}
@@ -0,0 +1,278 @@
+package elasticlm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+
+	bcommon "github.com/adshao/go-binance/v2/common"
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+	"github.com/hiepnv90/elastic-lm/pkg/models"
+	"gorm.io/gorm"
+)
+
+const clientOrderIDPrefix = "elm-"
+
+// createOrder records the order's intent in the journal, then sends the order to the exchange.
+// The client order ID is derived from the journal entry, so an order can always be looked up after a crash, and from
+// the database's install ID, so a recreated database doesn't reuse the IDs of earlier orders.
+func (e *ElasticLM) createOrder(
+	ctx context.Context,
+	order *pendingOrder,
+	quantity string,
+	price string,
+	stopPrice string,
+	orderType futures.OrderType,
+	timeInForce futures.TimeInForceType,
+	reduceOnly bool,
+) (*futures.CreateOrderResponse, error) {
+	l := e.logger.With("symbol", order.Symbol)
+
+	// Orders reducing shorts are sent anyway, e.g. the margin type of a symbol can't be changed with a position.
+	if !reduceOnly {
+		err := e.setupSymbol(ctx, order.Symbol)
+		if err != nil {
+			l.Errorw("Fail to set up leverage and margin type of symbol", "error", err)
+			e.alert(ctx, "setup:"+order.Symbol, fmt.Sprintf("Fail to set up %s before its order: %v", order.Symbol, err))
+			return nil, err
+		}
+	}
+
+	limited, err := e.checkLimits(ctx, order, reduceOnly)
+	if err != nil {
+		return nil, err
+	}
+	if limited.Cmp(order.Quantity) < 0 {
+		clipOrder(order, limited)
+		quantity = common.FormatAmount(limited, order.Decimals, e.symbolInfoMap[order.Symbol].QuantityPrecision)
+	}
+
+	prefix, err := e.getOrderIDPrefix()
+	if err != nil {
+		l.Errorw("Fail to get prefix of client order IDs", "error", err)
+		return nil, err
+	}
+
+	row := models.Order{
+		Symbol:          order.Symbol,
+		ParentOrderID:   order.ParentOrderID,
+		Side:            string(order.Side),
+		Type:            string(orderType),
+		ReduceOnly:      reduceOnly,
+		Price:           price,
+		StopPrice:       stopPrice,
+		Quantity:        quantity,
+		Decimals:        order.Decimals,
+		AppliedQuantity: order.Filled.String(),
+		Status:          models.OrderStatusIntent,
+	}
+	if len(order.Allocations) == 1 {
+		row.PositionID = order.Allocations[0].PositionID
+	}
+	err = e.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&row).Error
+		if err != nil {
+			return err
+		}
+
+		for _, allocation := range order.Allocations {
+			allocationRow := models.OrderAllocation{
+				OrderID:       row.ID,
+				PositionID:    allocation.PositionID,
+				TokenIndex:    allocation.TokenIndex,
+				Amount:        allocation.Amount.String(),
+				AppliedAmount: allocation.Applied.String(),
+			}
+			err = tx.Create(&allocationRow).Error
+			if err != nil {
+				return err
+			}
+			allocation.ID = allocationRow.ID
+		}
+
+		row.ClientOrderID = fmt.Sprintf("%s%d", prefix, row.ID)
+		return tx.Model(&row).Update("client_order_id", row.ClientOrderID).Error
+	})
+	if err != nil {
+		l.Errorw("Fail to record order intent", "error", err)
+		return nil, err
+	}
+
+	l = l.With("clientOrderID", row.ClientOrderID)
+	order.ClientOrderID = row.ClientOrderID
+	order.StopPrice = stopPrice
+	e.pendingOrders[row.ClientOrderID] = order
+
+	positionSide, sentReduceOnly := e.getOrderPositionSide(reduceOnly)
+	resp, err := e.bclient.CreateFutureOrder(
+		ctx, order.Symbol, quantity, price, stopPrice, order.Side, orderType, timeInForce, sentReduceOnly, positionSide,
+		row.ClientOrderID,
+	)
+	if err != nil {
+		if !bcommon.IsAPIError(err) {
+			// The order may have reached the exchange, so keep its intent open until it is looked up.
+			l.Warnw("Unknown result of creating order", "error", err)
+			e.requestResync()
+			return nil, err
+		}
+
+		delete(e.pendingOrders, row.ClientOrderID)
+		dbErr := e.db.Model(&row).Updates(models.Order{Status: models.OrderStatusFailed, Error: err.Error()}).Error
+		if dbErr != nil {
+			l.Warnw("Fail to update order in journal", "error", dbErr)
+		}
+		return nil, err
+	}
+
+	e.updateJournalOrder(row.ClientOrderID, resp.Status, resp.ExecutedQuantity, resp.AvgPrice, resp.UpdateTime)
+	dbErr := e.db.Model(&row).Update("order_id", resp.OrderID).Error
+	if dbErr != nil {
+		l.Warnw("Fail to update order in journal", "error", dbErr)
+	}
+
+	filled, err := common.ParseAmount(resp.ExecutedQuantity, order.Decimals)
+	if err == nil {
+		e.applyOrderFill(row.ClientOrderID, order, filled, resp.Status)
+	}
+
+	return resp, nil
+}
+
+// updateJournalOrder records the latest state of an order reported by the exchange.
+// Orders in a final state are not updated, as updates of the exchange may arrive late, e.g. after a cancellation.
+func (e *ElasticLM) updateJournalOrder(
+	clientOrderID string,
+	status futures.OrderStatusType,
+	executedQuantity string,
+	avgPrice string,
+	updateTime int64,
+) {
+	err := e.db.Model(&models.Order{}).
+		Where("client_order_id = ? AND status IN ?", clientOrderID, models.OpenOrderStatuses).
+		Updates(models.Order{
+			ExecutedQuantity: executedQuantity,
+			AvgPrice:         avgPrice,
+			Status:           string(status),
+			UpdateTime:       updateTime,
+		}).Error
+	if err != nil {
+		e.logger.Warnw("Fail to update order in journal", "clientOrderID", clientOrderID, "error", err)
+	}
+}
+
+// failJournalOrder marks an order which can't be found on the exchange as failed.
+func (e *ElasticLM) failJournalOrder(clientOrderID string, reason string) {
+	err := e.db.Model(&models.Order{}).
+		Where("client_order_id = ?", clientOrderID).
+		Updates(models.Order{Status: models.OrderStatusFailed, Error: reason}).Error
+	if err != nil {
+		e.logger.Warnw("Fail to update order in journal", "clientOrderID", clientOrderID, "error", err)
+	}
+}
+
+// loadOpenOrders restores the journaled orders which are not in a final state as pending orders.
+func (e *ElasticLM) loadOpenOrders() error {
+	l := e.logger
+
+	orders, err := models.ListOpenOrders(e.db)
+	if err != nil {
+		l.Errorw("Fail to get open orders from journal", "error", err)
+		return err
+	}
+
+	for _, row := range orders {
+		quantity, err := common.ParseAmount(row.Quantity, row.Decimals)
+		if err != nil {
+			l.Errorw("Fail to parse order quantity", "clientOrderID", row.ClientOrderID, "quantity", row.Quantity, "error", err)
+			return err
+		}
+
+		applied := big.NewInt(0)
+		if row.AppliedQuantity != "" {
+			applied = common.NewBigIntFromString(row.AppliedQuantity, 10)
+		}
+
+		order := &pendingOrder{
+			ClientOrderID: row.ClientOrderID,
+			ParentOrderID: row.ParentOrderID,
+			Symbol:        row.Symbol,
+			Decimals:      row.Decimals,
+			Side:          futures.SideType(row.Side),
+			Quantity:      quantity,
+			Filled:        applied,
+			Resting:       row.Type == string(futures.OrderTypeStop),
+			PostOnly:      row.Type == string(futures.OrderTypeLimit),
+			StopPrice:     row.StopPrice,
+		}
+
+		allocations, err := models.ListOrderAllocations(e.db, row.ID)
+		if err != nil {
+			l.Errorw("Fail to get order allocations from journal", "clientOrderID", row.ClientOrderID, "error", err)
+			return err
+		}
+		for _, allocation := range allocations {
+			order.Allocations = append(order.Allocations, &orderAllocation{
+				ID:         allocation.ID,
+				PositionID: allocation.PositionID,
+				TokenIndex: allocation.TokenIndex,
+				Amount:     common.NewBigIntFromString(allocation.Amount, 10),
+				Applied:    common.NewBigIntFromString(allocation.AppliedAmount, 10),
+			})
+		}
+
+		l.Infow("Restore open order from journal", "clientOrderID", row.ClientOrderID, "status", row.Status)
+		e.pendingOrders[row.ClientOrderID] = order
+	}
+
+	return nil
+}
+
+// getOrderIDPrefix returns the prefix of client order IDs, which carries the install ID of the database. The install
+// ID is generated and saved on the first order.
+func (e *ElasticLM) getOrderIDPrefix() (string, error) {
+	if e.orderIDPrefix != "" {
+		return e.orderIDPrefix, nil
+	}
+
+	installID, err := models.GetSetting(e.db, models.SettingInstallID)
+	if err != nil {
+		return "", err
+	}
+	if installID == "" {
+		b := make([]byte, 4)
+		_, err = rand.Read(b)
+		if err != nil {
+			return "", err
+		}
+		installID = hex.EncodeToString(b)
+
+		err = models.SetSetting(e.db, models.SettingInstallID, installID)
+		if err != nil {
+			return "", err
+		}
+		e.logger.Infow("Generate install ID of database", "installID", installID)
+	}
+
+	e.orderIDPrefix = clientOrderIDPrefix + installID + "-"
+	return e.orderIDPrefix, nil
+}
+
+func (e *ElasticLM) requestResync() {
+	select {
+	case e.resyncC <- struct{}{}:
+	default:
+	}
+}
+
+func isOrderNotFound(err error) bool {
+	apiErr, ok := err.(*bcommon.APIError)
+	return ok && apiErr.Code == -2013
+}
+
+func isMinNotionalError(err error) bool {
+	apiErr, ok := err.(*bcommon.APIError)
+	return ok && apiErr.Code == -4164
+}
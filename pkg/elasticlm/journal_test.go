@@ -0,0 +1,194 @@
+package elasticlm
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/hiepnv90/elastic-lm/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// restartTestElasticLM returns a new ElasticLM which shares the database and the exchange of a stopped one.
+func restartTestElasticLM(t *testing.T, e *ElasticLM, opts Options) *ElasticLM {
+	restarted := New(e.db, nil, e.bclient, e.positionIDs, 100, e.quoteCurrency, e.interval, nil, opts)
+	restarted.symbolInfoMap = e.symbolInfoMap
+	require.NoError(t, restarted.loadPositions())
+	return restarted
+}
+
+// journalTestOrder records a market sell order with the status and allocations, as if the program stopped before
+// the order was finished.
+func journalTestOrder(
+	t *testing.T, e *ElasticLM, quantity string, status string, allocations ...*orderAllocation,
+) string {
+	row := models.Order{
+		Symbol:          testSymbol,
+		Side:            string(futures.SideTypeSell),
+		Type:            string(futures.OrderTypeMarket),
+		Quantity:        quantity,
+		Decimals:        testDecimals,
+		AppliedQuantity: "0",
+		Status:          status,
+	}
+	if len(allocations) == 1 {
+		row.PositionID = allocations[0].PositionID
+	}
+	require.NoError(t, e.db.Create(&row).Error)
+	row.ClientOrderID = fmt.Sprintf("%s%d", clientOrderIDPrefix, row.ID)
+	require.NoError(t, e.db.Model(&row).Update("client_order_id", row.ClientOrderID).Error)
+
+	for _, allocation := range allocations {
+		require.NoError(t, e.db.Create(&models.OrderAllocation{
+			OrderID:       row.ID,
+			PositionID:    allocation.PositionID,
+			TokenIndex:    allocation.TokenIndex,
+			Amount:        allocation.Amount.String(),
+			AppliedAmount: allocation.Applied.String(),
+		}).Error)
+	}
+	return row.ClientOrderID
+}
+
+// placeTestLimitSell places a limit sell order through ElasticLM which rests above the price.
+func placeTestLimitSell(t *testing.T, e *ElasticLM, quantity string, allocations ...*orderAllocation) *pendingOrder {
+	order := &pendingOrder{
+		Symbol:      testSymbol,
+		Decimals:    testDecimals,
+		Side:        futures.SideTypeSell,
+		Quantity:    ethAmount(quantity),
+		Filled:      big.NewInt(0),
+		Allocations: allocations,
+	}
+	_, err := e.createOrder(
+		context.Background(), order, quantity, "2000", "", futures.OrderTypeLimit, futures.TimeInForceTypeGTC, false,
+	)
+	require.NoError(t, err)
+	return order
+}
+
+func getJournalOrder(t *testing.T, e *ElasticLM, clientOrderID string) models.Order {
+	var row models.Order
+	require.NoError(t, e.db.Where("client_order_id = ?", clientOrderID).First(&row).Error)
+	return row
+}
+
+func TestCreateOrderRecordsJournal(t *testing.T) {
+	e, _ := newTestElasticLM(t, Options{})
+	e.positionMap["1"] = newTestPosition("1", "20", "0")
+	e.positionMap["2"] = newTestPosition("2", "1", "0")
+
+	e.hedgeDeltas(context.Background(), []hedgeDelta{newTestDelta("1", "0.3"), newTestDelta("2", "0.2")})
+	rejected := &pendingOrder{
+		Symbol:      testSymbol,
+		Decimals:    testDecimals,
+		Side:        futures.SideTypeSell,
+		Quantity:    ethAmount("11"),
+		Filled:      big.NewInt(0),
+		Allocations: []*orderAllocation{newTestAllocation("1", "11")},
+	}
+	_, err := e.createOrder(
+		context.Background(), rejected, "11.000", "", "", futures.OrderTypeMarket, futures.TimeInForceTypeGTC, false,
+	)
+	require.Error(t, err)
+	assert.Empty(t, e.pendingOrders, "rejected order isn't pending")
+
+	orders, err := models.ListOrders(e.db, "1", testSymbol, 0)
+	require.NoError(t, err)
+	require.Len(t, orders, 2)
+	assert.Equal(t, models.OrderStatusFailed, orders[0].Status, "quantity is above max market quantity")
+	assert.Equal(t, "11.000", orders[0].Quantity)
+	assert.NotEmpty(t, orders[0].Error)
+
+	sold := orders[1]
+	installID, err := models.GetSetting(e.db, models.SettingInstallID)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%s%s-%d", clientOrderIDPrefix, installID, sold.ID), sold.ClientOrderID)
+	assert.Empty(t, sold.PositionID, "netted order belongs to its allocations")
+	assert.Equal(t, string(futures.SideTypeSell), sold.Side)
+	assert.Equal(t, "0.500", sold.Quantity)
+	assert.Equal(t, string(futures.OrderStatusTypeFilled), sold.Status)
+	assert.Equal(t, ethAmount("0.5").String(), sold.AppliedQuantity, "fill of the response is applied")
+	assertAmount(t, "0.3", e.positionMap["1"].HedgedAmount0)
+	assertAmount(t, "0.2", e.positionMap["2"].HedgedAmount0)
+	assert.Empty(t, e.pendingOrders)
+
+	allocations, err := models.ListOrderAllocations(e.db, sold.ID)
+	require.NoError(t, err)
+	require.Len(t, allocations, 2)
+	assert.Equal(t, ethAmount("0.3").String(), allocations[0].AppliedAmount)
+	assert.Equal(t, ethAmount("0.2").String(), allocations[1].AppliedAmount)
+}
+
+func TestGetOrderIDPrefix(t *testing.T) {
+	e, _ := newTestElasticLM(t, Options{})
+	prefix, err := e.getOrderIDPrefix()
+	require.NoError(t, err)
+	assert.Regexp(t, "^elm-[0-9a-f]{8}-$", prefix)
+
+	restarted := restartTestElasticLM(t, e, Options{})
+	restartedPrefix, err := restarted.getOrderIDPrefix()
+	require.NoError(t, err)
+	assert.Equal(t, prefix, restartedPrefix, "install ID is kept in the database")
+
+	recreated, _ := newTestElasticLM(t, Options{})
+	recreatedPrefix, err := recreated.getOrderIDPrefix()
+	require.NoError(t, err)
+	assert.NotEqual(t, prefix, recreatedPrefix, "recreated database doesn't reuse client order IDs")
+}
+
+func TestLoadOpenOrders(t *testing.T) {
+	e, _ := newTestElasticLM(t, Options{})
+	partial := newTestAllocation("1", "0.6")
+	partial.Applied = ethAmount("0.24")
+	clientOrderID := journalTestOrder(
+		t, e, "1", string(futures.OrderStatusTypePartiallyFilled), partial, newTestAllocation("2", "0.4"),
+	)
+	require.NoError(t, e.db.Model(&models.Order{}).
+		Where("client_order_id = ?", clientOrderID).
+		Update("applied_quantity", ethAmount("0.4").String()).Error)
+	journalTestOrder(t, e, "0.2", string(futures.OrderStatusTypeFilled), newTestAllocation("1", "0.2"))
+
+	require.NoError(t, e.loadOpenOrders())
+	require.Len(t, e.pendingOrders, 1)
+	require.Contains(t, e.pendingOrders, clientOrderID)
+	order := e.pendingOrders[clientOrderID]
+	assert.Equal(t, futures.SideTypeSell, order.Side)
+	assertAmount(t, "1", order.Quantity)
+	assertAmount(t, "0.4", order.Filled)
+	require.Len(t, order.Allocations, 2)
+	assert.Equal(t, "1", order.Allocations[0].PositionID)
+	assertAmount(t, "0.6", order.Allocations[0].Amount)
+	assertAmount(t, "0.24", order.Allocations[0].Applied)
+	assert.Equal(t, "2", order.Allocations[1].PositionID)
+	assertAmount(t, "0", order.Allocations[1].Applied)
+}
+
+func TestResolveIntentsOnRestart(t *testing.T) {
+	e, sim := newTestElasticLM(t, Options{})
+	e.positionMap["1"] = newTestPosition("1", "1", "0")
+	require.NoError(t, e.savePositions())
+
+	// The program stops after recording two intents, only the first one reaches the exchange.
+	sentID := journalTestOrder(t, e, "0.2", models.OrderStatusIntent, newTestAllocation("1", "0.2"))
+	_, err := sim.CreateFutureOrder(
+		context.Background(), testSymbol, "0.2", "0", "", futures.SideTypeSell, futures.OrderTypeMarket,
+		futures.TimeInForceTypeGTC, false, "", sentID,
+	)
+	require.NoError(t, err)
+	lostID := journalTestOrder(t, e, "0.3", models.OrderStatusIntent, newTestAllocation("1", "0.3"))
+
+	restarted := restartTestElasticLM(t, e, Options{})
+	require.NoError(t, restarted.loadOpenOrders())
+	restarted.resyncPendingOrders(context.Background())
+
+	assert.Empty(t, restarted.pendingOrders)
+	assertAmount(t, "0.2", restarted.positionMap["1"].HedgedAmount0)
+	sent := getJournalOrder(t, restarted, sentID)
+	assert.Equal(t, string(futures.OrderStatusTypeFilled), sent.Status)
+	assert.Equal(t, ethAmount("0.2").String(), sent.AppliedQuantity)
+	assert.Equal(t, models.OrderStatusFailed, getJournalOrder(t, restarted, lostID).Status)
+}
@@ -0,0 +1,345 @@
+package elasticlm
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+	"github.com/hiepnv90/elastic-lm/pkg/position"
+	"github.com/hiepnv90/elastic-lm/pkg/strategy"
+)
+
+// LadderOptions holds the settings of ladders of positions in ladder mode.
+type LadderOptions struct {
+	// Rungs is the number of rungs of ladders.
+	Rungs int
+	// SlippageBps is the distance of the ladders' limit prices from their stop prices. A price gapping past a limit
+	// price leaves its rung unfilled.
+	SlippageBps float64
+}
+
+func (o LadderOptions) validate() error {
+	if o.Rungs < 0 {
+		return fmt.Errorf("negative ladder rungs: %d", o.Rungs)
+	}
+	if o.SlippageBps < 0 || o.SlippageBps >= 10000 {
+		return fmt.Errorf("invalid ladder slippage: %v bps", o.SlippageBps)
+	}
+	return nil
+}
+
+// ladder is the set of resting stop orders which mirrors the range of a position.
+// It is rebuilt when the position's liquidity, range or hedge ratio changes. When any of its orders finishes, only the
+// rungs which differ from the resting orders are replaced.
+type ladder struct {
+	Liquidity     *big.Int
+	TickLower     int
+	TickUpper     int
+	HedgeRatioBps int64
+	Orders        []*pendingOrder
+}
+
+func (l *ladder) matches(pos position.Position) bool {
+	return l.Liquidity != nil &&
+		l.Liquidity.Cmp(pos.Liquidity) == 0 &&
+		l.TickLower == pos.TickLower &&
+		l.TickUpper == pos.TickUpper &&
+		l.HedgeRatioBps == pos.HedgeRatioBps
+}
+
+func (e *ElasticLM) isLadderIntact(l *ladder) bool {
+	for _, order := range l.Orders {
+		if _, ok := e.pendingOrders[order.ClientOrderID]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// ladderRung is an order of a ladder before it is placed.
+type ladderRung struct {
+	Order      *pendingOrder
+	Quantity   string
+	Price      string
+	ReduceOnly bool
+}
+
+// matches returns whether a resting order is the rung's order, which is kept instead of being replaced.
+func (r ladderRung) matches(order *pendingOrder) bool {
+	return order.Symbol == r.Order.Symbol &&
+		order.Side == r.Order.Side &&
+		order.StopPrice == r.Order.StopPrice &&
+		order.Quantity.Cmp(r.Order.Quantity) == 0 &&
+		common.BigIsZero(order.Filled)
+}
+
+// restoreLadders groups the resting orders restored from the journal by position,
+// so they are replaced by new ladders on the first update of positions.
+func (e *ElasticLM) restoreLadders() {
+	for _, order := range e.pendingOrders {
+		if !order.Resting || len(order.Allocations) == 0 {
+			continue
+		}
+
+		positionID := order.Allocations[0].PositionID
+		l, ok := e.ladders[positionID]
+		if !ok {
+			l = &ladder{}
+			e.ladders[positionID] = l
+		}
+		l.Orders = append(l.Orders, order)
+	}
+}
+
+// updateLadders keeps the ladders of open positions in ladder mode in line with their ranges,
+// and cancels the ladders of other positions.
+func (e *ElasticLM) updateLadders(ctx context.Context) {
+	ids := make([]string, 0, len(e.positionMap))
+	for id := range e.positionMap {
+		ids = append(ids, id)
+	}
+	for id := range e.ladders {
+		if _, ok := e.positionMap[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		pos, ok := e.positionMap[id]
+		l, hasLadder := e.ladders[id]
+		if !ok || pos.IsClosed() || e.getHedgeMode(id) != HedgeModeLadder {
+			if hasLadder {
+				e.cancelLadder(ctx, id)
+			}
+			continue
+		}
+
+		if hasLadder {
+			if l.matches(pos) {
+				if !e.isLadderIntact(l) {
+					e.refreshLadder(ctx, pos, l)
+				}
+				continue
+			}
+			if !e.cancelLadder(ctx, id) {
+				continue
+			}
+		}
+		e.placeLadder(ctx, pos)
+	}
+}
+
+// placeLadder places a stop order for each rung of a position's range.
+func (e *ElasticLM) placeLadder(ctx context.Context, pos position.Position) {
+	l := e.logger.With("positionID", pos.ID)
+
+	ld := &ladder{
+		Liquidity:     pos.Liquidity,
+		TickLower:     pos.TickLower,
+		TickUpper:     pos.TickUpper,
+		HedgeRatioBps: pos.HedgeRatioBps,
+	}
+	e.ladders[pos.ID] = ld
+
+	rungs, err := e.getLadderRungs(pos)
+	if err != nil {
+		l.Warnw("Fail to build ladder of position", "error", err)
+		return
+	}
+
+	for _, rung := range rungs {
+		e.placeLadderRung(ctx, ld, rung)
+	}
+
+	l.Infow("Place ladder of position", "rungs", len(rungs), "orders", len(ld.Orders))
+}
+
+// refreshLadder replaces the rungs of a ladder whose orders finished, e.g. a filled rung becomes a rung of the other
+// side. Resting orders which still match a rung are kept, the others are cancelled before the missing rungs are placed.
+func (e *ElasticLM) refreshLadder(ctx context.Context, pos position.Position, ld *ladder) {
+	l := e.logger.With("positionID", pos.ID)
+
+	rungs, err := e.getLadderRungs(pos)
+	if err != nil {
+		l.Warnw("Fail to build ladder of position", "error", err)
+		return
+	}
+
+	var resting []*pendingOrder
+	for _, order := range ld.Orders {
+		if _, ok := e.pendingOrders[order.ClientOrderID]; ok {
+			resting = append(resting, order)
+		}
+	}
+
+	var kept []*pendingOrder
+	var missing []ladderRung
+	for _, rung := range rungs {
+		found := false
+		for i, order := range resting {
+			if rung.matches(order) {
+				kept = append(kept, order)
+				resting = append(resting[:i], resting[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, rung)
+		}
+	}
+
+	// Orders which can't be cancelled stay in the ladder, so it is refreshed again on the next update.
+	for _, order := range resting {
+		if !e.cancelOrder(ctx, order) {
+			return
+		}
+	}
+
+	ld.Orders = kept
+	for _, rung := range missing {
+		e.placeLadderRung(ctx, ld, rung)
+	}
+
+	l.Infow("Refresh ladder of position", "kept", len(kept), "canceled", len(resting), "placed", len(missing))
+}
+
+// getLadderRungs returns the stop orders of a position's range.
+// Rungs below the quantity precision or min notional are merged into the next rungs of the same side.
+func (e *ElasticLM) getLadderRungs(pos position.Position) ([]ladderRung, error) {
+	rungs, err := strategy.LadderRungs(pos, e.ladder.Rungs)
+	if err != nil {
+		return nil, err
+	}
+
+	var ladderRungs []ladderRung
+	carries := make(map[int]*big.Int)
+	for _, rung := range rungs {
+		token := pos.Token(rung.TokenIndex)
+		symbol := e.getBinancePerpetualSymbol(token)
+		symbolInfo := e.symbolInfoMap[symbol]
+		precision := symbolInfo.QuantityPrecision
+
+		sign := rung.Amount.Sign()
+		amount := rung.Amount
+		if carry, ok := carries[sign]; ok {
+			amount = common.BigAdd(amount, carry)
+		}
+		carries[sign] = amount
+
+		side := futures.SideTypeSell
+		reduceOnly := false
+		limitPrice := rung.Price * (1 - e.ladder.SlippageBps/10000)
+		if sign < 0 {
+			side = futures.SideTypeBuy
+			reduceOnly = true
+			limitPrice = rung.Price * (1 + e.ladder.SlippageBps/10000)
+		}
+
+		quantity := common.RoundAmount(common.BigAbs(amount), token.Decimals, precision, common.RoundTypeFloor)
+		if common.BigIsZero(quantity) {
+			continue
+		}
+		if !reduceOnly {
+			ok, err := e.checkMinNotionalAt(symbol, quantity, token.Decimals, limitPrice)
+			if err != nil || !ok {
+				continue
+			}
+		}
+
+		order := &pendingOrder{
+			Symbol:    symbol,
+			Decimals:  token.Decimals,
+			Side:      side,
+			Quantity:  quantity,
+			Filled:    big.NewInt(0),
+			Resting:   true,
+			StopPrice: formatPrice(symbolInfo, rung.Price),
+		}
+		signedQuantity := order.signedAmount(quantity)
+		carries[sign] = common.BigSub(amount, signedQuantity)
+		order.Allocations = []*orderAllocation{{
+			PositionID: pos.ID,
+			TokenIndex: rung.TokenIndex,
+			Amount:     signedQuantity,
+			Applied:    big.NewInt(0),
+		}}
+
+		// The limit price allows slippage from the stop price, so a triggered order is filled.
+		ladderRungs = append(ladderRungs, ladderRung{
+			Order:      order,
+			Quantity:   common.FormatAmount(quantity, token.Decimals, precision),
+			Price:      formatPrice(symbolInfo, limitPrice),
+			ReduceOnly: reduceOnly,
+		})
+	}
+	return ladderRungs, nil
+}
+
+// placeLadderRung places the stop order of a rung and adds it to the ladder.
+func (e *ElasticLM) placeLadderRung(ctx context.Context, ld *ladder, rung ladderRung) {
+	order := rung.Order
+	_, err := e.createOrder(
+		ctx,
+		order,
+		rung.Quantity,
+		rung.Price,
+		order.StopPrice,
+		futures.OrderTypeStop,
+		futures.TimeInForceTypeGTC,
+		rung.ReduceOnly,
+	)
+	if err != nil {
+		e.logger.Warnw(
+			"Fail to place ladder order",
+			"symbol", order.Symbol,
+			"side", order.Side,
+			"stopPrice", order.StopPrice,
+			"error", err,
+		)
+	}
+	// Orders of unknown results stay pending until they are looked up.
+	if _, ok := e.pendingOrders[order.ClientOrderID]; ok {
+		ld.Orders = append(ld.Orders, order)
+	}
+}
+
+// cancelLadder cancels the open orders of a position's ladder and applies their fills.
+// It returns false if any order can't be cancelled, so the ladder is retried on the next update.
+func (e *ElasticLM) cancelLadder(ctx context.Context, positionID string) bool {
+	l := e.logger.With("positionID", positionID)
+
+	ok := true
+	for _, order := range e.ladders[positionID].Orders {
+		if _, pending := e.pendingOrders[order.ClientOrderID]; !pending {
+			continue
+		}
+
+		if !e.cancelOrder(ctx, order) {
+			ok = false
+		}
+	}
+
+	if ok {
+		l.Infow("Cancel ladder of position")
+		delete(e.ladders, positionID)
+	}
+	return ok
+}
+
+// formatPrice rounds a price to the symbol's tick size.
+func formatPrice(symbolInfo futures.Symbol, price float64) string {
+	if filter := symbolInfo.PriceFilter(); filter != nil {
+		tickSize, err := strconv.ParseFloat(filter.TickSize, 64)
+		if err == nil && tickSize > 0 {
+			price = math.Round(price/tickSize) * tickSize
+		}
+	}
+	return strconv.FormatFloat(price, 'f', symbolInfo.PricePrecision, 64)
+}
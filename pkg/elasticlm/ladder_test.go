@@ -0,0 +1,168 @@
+package elasticlm
+
+import (
+	"context"
+	"math/big"
+	"strconv"
+	"testing"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+	"github.com/hiepnv90/elastic-lm/pkg/models"
+	"github.com/hiepnv90/elastic-lm/pkg/position"
+	"github.com/hiepnv90/elastic-lm/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testLadderOptions = Options{HedgeMode: HedgeModeLadder, Ladder: LadderOptions{Rungs: 10, SlippageBps: 50}}
+
+// newTestLadderPosition returns a position ranging 1000 ticks around the test price, whose rungs hold about 0.2 WETH.
+func newTestLadderPosition() position.Position {
+	pos := newTestPosition("1", "1", "0")
+	pos.Liquidity = big.NewInt(650000000000000)
+	pos.TickLower = testPriceTick - 1000
+	pos.TickUpper = testPriceTick + 1000
+	pos.Tick = testPriceTick
+	return pos
+}
+
+func getLadderClientOrderIDs(l *ladder) []string {
+	ids := make([]string, 0, len(l.Orders))
+	for _, order := range l.Orders {
+		ids = append(ids, order.ClientOrderID)
+	}
+	return ids
+}
+
+func assertLadderCanceled(t *testing.T, e *ElasticLM, clientOrderIDs []string) {
+	for _, id := range clientOrderIDs {
+		assert.NotContains(t, e.pendingOrders, id)
+		assert.Equal(t, string(futures.OrderStatusTypeCanceled), getJournalOrder(t, e, id).Status)
+	}
+}
+
+func TestUpdateLaddersPlacesRungs(t *testing.T) {
+	e, _ := newTestElasticLM(t, testLadderOptions)
+	e.positionMap["1"] = newTestLadderPosition()
+
+	e.updateLadders(context.Background())
+	require.Contains(t, e.ladders, "1")
+	l := e.ladders["1"]
+	assert.True(t, l.matches(e.positionMap["1"]))
+	require.Len(t, l.Orders, 10)
+
+	sells := 0
+	for _, order := range l.Orders {
+		assert.True(t, order.Resting)
+		require.Contains(t, e.pendingOrders, order.ClientOrderID)
+
+		row := getJournalOrder(t, e, order.ClientOrderID)
+		assert.Equal(t, string(futures.OrderTypeStop), row.Type)
+		stopPrice, err := strconv.ParseFloat(row.StopPrice, 64)
+		require.NoError(t, err)
+		if order.Side == futures.SideTypeSell {
+			sells++
+			assert.Less(t, stopPrice, testPrice, "sell rungs are below the price")
+			assert.False(t, row.ReduceOnly)
+		} else {
+			assert.Greater(t, stopPrice, testPrice, "buy rungs are above the price")
+			assert.True(t, row.ReduceOnly)
+		}
+	}
+	assert.Equal(t, 5, sells)
+
+	e.updateLadders(context.Background())
+	assert.Same(t, l, e.ladders["1"], "intact ladder is kept")
+}
+
+func TestUpdateLaddersRebuildsLadder(t *testing.T) {
+	t.Run("range changes", func(t *testing.T) {
+		e, _ := newTestElasticLM(t, testLadderOptions)
+		pos := newTestLadderPosition()
+		e.positionMap["1"] = pos
+		e.updateLadders(context.Background())
+		previous := getLadderClientOrderIDs(e.ladders["1"])
+
+		pos.TickUpper += 200
+		e.positionMap["1"] = pos
+		e.updateLadders(context.Background())
+		assertLadderCanceled(t, e, previous)
+		assert.True(t, e.ladders["1"].matches(pos))
+		assert.Len(t, e.ladders["1"].Orders, 11, "rung of the current tick is split")
+	})
+
+	t.Run("rung is filled", func(t *testing.T) {
+		prices := simulator.StaticPrices{testSymbol: testPrice}
+		e, sim := newTestElasticLMAt(t, prices, testLadderOptions)
+		pos := newTestLadderPosition()
+		e.positionMap["1"] = pos
+		e.updateLadders(context.Background())
+		previous := getLadderClientOrderIDs(e.ladders["1"])
+
+		// The nearest sell rung is triggered at 990.05 and filled above its limit price of 985.1.
+		prices[testSymbol] = 988
+		sim.MatchOrders()
+		e.resyncPendingOrders(context.Background())
+		assert.Greater(t, getTestShort(sim), 0.19)
+		assert.Equal(t, getTestShort(sim), common.AmountToFloat(e.positionMap["1"].HedgedAmount0, testDecimals))
+
+		pos.Tick = testPriceTick - 121
+		e.positionMap["1"] = pos
+		e.updateLadders(context.Background())
+		assert.True(t, e.isLadderIntact(e.ladders["1"]))
+		assert.Len(t, e.ladders["1"].Orders, 11, "rung of the current tick is split")
+
+		// Rungs away from the price are kept, only the rungs around it are replaced.
+		current := getLadderClientOrderIDs(e.ladders["1"])
+		var kept []string
+		for _, id := range previous {
+			switch getJournalOrder(t, e, id).Status {
+			case string(futures.OrderStatusTypeNew):
+				assert.Contains(t, current, id)
+				kept = append(kept, id)
+			case string(futures.OrderStatusTypeFilled), string(futures.OrderStatusTypeCanceled):
+				assert.NotContains(t, current, id)
+			default:
+				t.Errorf("unexpected status of order %s", id)
+			}
+		}
+		assert.Len(t, kept, 7)
+	})
+
+	t.Run("restart", func(t *testing.T) {
+		e, _ := newTestElasticLM(t, testLadderOptions)
+		pos := newTestLadderPosition()
+		e.positionMap["1"] = pos
+		e.updateLadders(context.Background())
+		require.NoError(t, e.savePositions())
+		previous := getLadderClientOrderIDs(e.ladders["1"])
+
+		restarted := restartTestElasticLM(t, e, testLadderOptions)
+		require.NoError(t, restarted.loadOpenOrders())
+		restarted.restoreLadders()
+		require.Contains(t, restarted.ladders, "1")
+		assert.ElementsMatch(t, previous, getLadderClientOrderIDs(restarted.ladders["1"]))
+
+		restarted.positionMap["1"] = pos
+		restarted.updateLadders(context.Background())
+		assertLadderCanceled(t, restarted, previous)
+		assert.True(t, restarted.ladders["1"].matches(pos))
+		assert.Len(t, restarted.ladders["1"].Orders, 10)
+	})
+
+	t.Run("hedge mode changes", func(t *testing.T) {
+		e, _ := newTestElasticLM(t, testLadderOptions)
+		e.positionMap["1"] = newTestLadderPosition()
+		e.updateLadders(context.Background())
+		previous := getLadderClientOrderIDs(e.ladders["1"])
+
+		e.hedgeModes = map[string]HedgeMode{"1": HedgeModeDynamic}
+		e.updateLadders(context.Background())
+		assertLadderCanceled(t, e, previous)
+		assert.NotContains(t, e.ladders, "1")
+		orders, err := models.ListOpenOrders(e.db)
+		require.NoError(t, err)
+		assert.Empty(t, orders)
+	})
+}
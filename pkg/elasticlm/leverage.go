@@ -0,0 +1,191 @@
+package elasticlm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+const maxLeverage = 125
+
+// MarginType is the margin type of a symbol's position on the exchange.
+type MarginType string
+
+const (
+	// MarginTypeCross shares the balance of the account as margin of all positions.
+	MarginTypeCross MarginType = "cross"
+	// MarginTypeIsolated limits the margin of a position to the amount which is added to it.
+	MarginTypeIsolated MarginType = "isolated"
+)
+
+func (t MarginType) validate() error {
+	switch t {
+	case "", MarginTypeCross, MarginTypeIsolated:
+		return nil
+	default:
+		return fmt.Errorf("invalid margin type: %s", t)
+	}
+}
+
+func (t MarginType) futuresMarginType() futures.MarginType {
+	if t == MarginTypeIsolated {
+		return futures.MarginTypeIsolated
+	}
+	return futures.MarginTypeCrossed
+}
+
+// LeverageOptions holds the leverage and margin type which are applied to hedged symbols on the exchange.
+type LeverageOptions struct {
+	// Leverage is applied to every hedged symbol before its first order, which is overridden by SymbolLeverages.
+	// The account's setting is kept when it is unset.
+	Leverage int
+	// MarginType is applied to every hedged symbol before its first order, which is overridden by
+	// SymbolMarginTypes. The account's setting is kept when it is unset.
+	MarginType        MarginType
+	SymbolLeverages   map[string]int
+	SymbolMarginTypes map[string]MarginType
+}
+
+// validate checks the leverages and margin types of symbols.
+func (o LeverageOptions) validate() error {
+	if o.Leverage < 0 || o.Leverage > maxLeverage {
+		return fmt.Errorf("invalid leverage: %d", o.Leverage)
+	}
+	for symbol, leverage := range o.SymbolLeverages {
+		if leverage < 1 || leverage > maxLeverage {
+			return fmt.Errorf("invalid leverage of symbol %s: %d", symbol, leverage)
+		}
+	}
+
+	err := o.MarginType.validate()
+	if err != nil {
+		return err
+	}
+	for symbol, marginType := range o.SymbolMarginTypes {
+		err = marginType.validate()
+		if err != nil {
+			return fmt.Errorf("symbol %s: %w", symbol, err)
+		}
+	}
+	return nil
+}
+
+// getLeverage returns the leverage of a symbol, 0 to keep the account's one.
+func (e *ElasticLM) getLeverage(symbol string) int {
+	if leverage, ok := e.leverage.SymbolLeverages[symbol]; ok {
+		return leverage
+	}
+	return e.leverage.Leverage
+}
+
+// getMarginType returns the margin type of a symbol, empty to keep the account's one.
+func (e *ElasticLM) getMarginType(symbol string) MarginType {
+	if marginType, ok := e.leverage.SymbolMarginTypes[symbol]; ok && marginType != "" {
+		return marginType
+	}
+	return e.leverage.MarginType
+}
+
+// setupSymbols applies the leverage and margin type of the symbols of stored positions and of the symbols which
+// have their own settings.
+func (e *ElasticLM) setupSymbols(ctx context.Context) error {
+	symbolSet := make(map[string]struct{})
+	for symbol := range e.getHedgeLegs() {
+		symbolSet[symbol] = struct{}{}
+	}
+	for symbol := range e.leverage.SymbolLeverages {
+		symbolSet[symbol] = struct{}{}
+	}
+	for symbol := range e.leverage.SymbolMarginTypes {
+		symbolSet[symbol] = struct{}{}
+	}
+
+	symbols := make([]string, 0, len(symbolSet))
+	for symbol := range symbolSet {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	for _, symbol := range symbols {
+		err := e.setupSymbol(ctx, symbol)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setupSymbol applies the leverage and margin type of a symbol on the exchange and checks them, once per run. It is
+// called before the first order of a symbol which isn't set up at startup.
+func (e *ElasticLM) setupSymbol(ctx context.Context, symbol string) error {
+	if _, ok := e.setupSymbolSet[symbol]; ok {
+		return nil
+	}
+
+	leverage, marginType := e.getLeverage(symbol), e.getMarginType(symbol)
+	if leverage == 0 && marginType == "" {
+		e.setupSymbolSet[symbol] = struct{}{}
+		return nil
+	}
+	if _, ok := e.symbolInfoMap[symbol]; !ok {
+		return fmt.Errorf("unknown symbol %s to set leverage and margin type of", symbol)
+	}
+
+	l := e.logger.With("symbol", symbol, "leverage", leverage, "marginType", marginType)
+	risk, err := e.getSymbolRisk(ctx, symbol)
+	if err != nil {
+		l.Errorw("Fail to get position risk of symbol", "error", err)
+		return err
+	}
+
+	if marginType != "" && !strings.EqualFold(risk.MarginType, string(marginType)) {
+		l.Infow("Change margin type of symbol", "currentMarginType", risk.MarginType)
+		err = e.bclient.ChangeMarginType(ctx, symbol, marginType.futuresMarginType())
+		if err != nil {
+			l.Errorw("Fail to change margin type of symbol", "error", err)
+			return fmt.Errorf("change margin type of %s to %s: %w", symbol, marginType, err)
+		}
+	}
+	if leverage > 0 && risk.Leverage != strconv.Itoa(leverage) {
+		l.Infow("Change leverage of symbol", "currentLeverage", risk.Leverage)
+		_, err = e.bclient.ChangeLeverage(ctx, symbol, leverage)
+		if err != nil {
+			l.Errorw("Fail to change leverage of symbol", "error", err)
+			return fmt.Errorf("change leverage of %s to %d: %w", symbol, leverage, err)
+		}
+	}
+
+	risk, err = e.getSymbolRisk(ctx, symbol)
+	if err != nil {
+		l.Errorw("Fail to get position risk of symbol", "error", err)
+		return err
+	}
+	if (marginType != "" && !strings.EqualFold(risk.MarginType, string(marginType))) ||
+		(leverage > 0 && risk.Leverage != strconv.Itoa(leverage)) {
+		return fmt.Errorf(
+			"%s has leverage %s and margin type %s after setting leverage %d and margin type %s",
+			symbol, risk.Leverage, risk.MarginType, leverage, marginType,
+		)
+	}
+
+	l.Infow("Set up leverage and margin type of symbol")
+	e.setupSymbolSet[symbol] = struct{}{}
+	return nil
+}
+
+// getSymbolRisk returns a position risk of a symbol, the sides of hedge mode share their leverage and margin type.
+func (e *ElasticLM) getSymbolRisk(ctx context.Context, symbol string) (*futures.PositionRisk, error) {
+	risks, err := e.bclient.GetPositionRisk(ctx, symbol)
+	if err != nil {
+		return nil, err
+	}
+	for _, risk := range risks {
+		if risk.Symbol == symbol {
+			return risk, nil
+		}
+	}
+	return nil, fmt.Errorf("no position risk of symbol %s", symbol)
+}
@@ -0,0 +1,86 @@
+package elasticlm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateLeverage(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  LeverageOptions
+		valid bool
+	}{
+		{name: "account settings", valid: true},
+		{
+			name: "leverage and margin type",
+			opts: LeverageOptions{
+				Leverage:          5,
+				MarginType:        MarginTypeIsolated,
+				SymbolLeverages:   map[string]int{testSymbol: 2},
+				SymbolMarginTypes: map[string]MarginType{testSymbol: MarginTypeCross},
+			},
+			valid: true,
+		},
+		{name: "negative leverage", opts: LeverageOptions{Leverage: -1}},
+		{name: "leverage above max", opts: LeverageOptions{Leverage: maxLeverage + 1}},
+		{name: "zero symbol leverage", opts: LeverageOptions{SymbolLeverages: map[string]int{testSymbol: 0}}},
+		{name: "invalid margin type", opts: LeverageOptions{MarginType: "crossed"}},
+		{
+			name: "invalid symbol margin type",
+			opts: LeverageOptions{SymbolMarginTypes: map[string]MarginType{testSymbol: "x"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.opts.validate()
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestSetupSymbol(t *testing.T) {
+	e, sim := newTestElasticLM(t, Options{
+		Leverage: LeverageOptions{
+			Leverage:          5,
+			SymbolMarginTypes: map[string]MarginType{testSymbol: MarginTypeIsolated},
+		},
+	})
+
+	require.NoError(t, e.setupSymbol(context.Background(), testSymbol))
+	risks, err := sim.GetPositionRisk(context.Background(), testSymbol)
+	require.NoError(t, err)
+	require.Len(t, risks, 1)
+	assert.Equal(t, "5", risks[0].Leverage)
+	assert.Equal(t, "isolated", risks[0].MarginType)
+	assert.Contains(t, e.setupSymbolSet, testSymbol)
+}
+
+func TestCreateOrderAfterSetupFailure(t *testing.T) {
+	alerter := &testAlerter{}
+	e, sim := newTestElasticLM(t, Options{
+		Leverage: LeverageOptions{MarginType: MarginTypeIsolated},
+		Alerter:  alerter,
+	})
+	e.positionMap["1"] = newTestPosition("1", "1", "0.5")
+	openTestShort(t, sim, "0.5")
+
+	// The margin type of a symbol can't be changed while it has a position.
+	e.hedgeDeltas(context.Background(), []hedgeDelta{newTestDelta("1", "0.2")})
+	assert.Equal(t, 0.5, getTestShort(sim), "order increasing the short is refused")
+	assert.Equal(t, []string{"setup:" + testSymbol}, alerter.keys)
+	assert.NotContains(t, e.setupSymbolSet, testSymbol)
+	assertAmount(t, "0.5", e.positionMap["1"].HedgedAmount0)
+
+	e.hedgeDeltas(context.Background(), []hedgeDelta{newTestDelta("1", "-0.2")})
+	assert.Equal(t, 0.3, getTestShort(sim), "order reducing the short is sent")
+	assertAmount(t, "0.3", e.positionMap["1"].HedgedAmount0)
+}
@@ -0,0 +1,302 @@
+package elasticlm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+)
+
+// notionalDecimals is the number of decimals of notionals and prices in the limit checks, which are computed with
+// integers, so e.g. 300 of a 600 limit at a price of 1000 allows exactly 0.3 instead of 0.299.
+const notionalDecimals = 18
+
+// errLimitExceeded is returned for orders which are refused by the exposure limits before they are sent.
+var errLimitExceeded = errors.New("order exceeds exposure limits")
+
+// LimitAction defines what to do with an order which would break an exposure limit.
+type LimitAction string
+
+const (
+	// LimitActionClip reduces the order's quantity to the largest one within the limits.
+	LimitActionClip LimitAction = "clip"
+	// LimitActionRefuse doesn't send the order.
+	LimitActionRefuse LimitAction = "refuse"
+)
+
+func (a LimitAction) validate() error {
+	switch a {
+	case "", LimitActionClip, LimitActionRefuse:
+		return nil
+	default:
+		return fmt.Errorf("invalid limit action: %s", a)
+	}
+}
+
+// LimitOptions holds the exposure limits which orders are checked against before they are sent, 0 for no limit.
+type LimitOptions struct {
+	// MaxOrderNotional is the max notional of an order of either side.
+	MaxOrderNotional float64
+	// MaxSymbolNotional is the max short notional of a symbol, which is overridden by SymbolMaxNotionals.
+	MaxSymbolNotional  float64
+	SymbolMaxNotionals map[string]float64
+	// MaxTotalNotional is the max short notional across symbols.
+	MaxTotalNotional float64
+	// Action decides what to do with orders breaking the limits, they are clipped by default.
+	Action LimitAction
+}
+
+func (o LimitOptions) validate() error {
+	if o.MaxOrderNotional < 0 || o.MaxSymbolNotional < 0 || o.MaxTotalNotional < 0 {
+		return fmt.Errorf(
+			"negative limit: order=%v symbol=%v total=%v", o.MaxOrderNotional, o.MaxSymbolNotional, o.MaxTotalNotional,
+		)
+	}
+	for symbol, maxNotional := range o.SymbolMaxNotionals {
+		if maxNotional < 0 {
+			return fmt.Errorf("negative limit of symbol %s: %v", symbol, maxNotional)
+		}
+	}
+	return o.Action.validate()
+}
+
+func (e *ElasticLM) hasLimits() bool {
+	return e.limits.MaxOrderNotional > 0 || e.limits.MaxSymbolNotional > 0 || len(e.limits.SymbolMaxNotionals) > 0 ||
+		e.limits.MaxTotalNotional > 0
+}
+
+// getMaxSymbolNotional returns the max short notional of a symbol, 0 for no limit.
+func (e *ElasticLM) getMaxSymbolNotional(symbol string) float64 {
+	if maxNotional, ok := e.limits.SymbolMaxNotionals[symbol]; ok {
+		return maxNotional
+	}
+	return e.limits.MaxSymbolNotional
+}
+
+// getShortAmount returns the short amount of a symbol in the decimals, which is the stored hedged amounts and the
+// unfilled quantities of sell orders on the exchange.
+func (e *ElasticLM) getShortAmount(symbol string, legs []hedgeLeg, decimals int) *big.Int {
+	amount := e.getStoredHedgedAmount(legs, decimals)
+	if unallocated, ok := e.unallocatedAmounts[symbol]; ok {
+		amount = common.BigAdd(amount, common.ScaleAmount(unallocated.Amount, unallocated.Decimals, decimals))
+	}
+	for _, order := range e.pendingOrders {
+		if order.Symbol != symbol || order.Side != futures.SideTypeSell {
+			continue
+		}
+		unfilled := common.BigSub(order.Quantity, order.Filled)
+		amount = common.BigAdd(amount, common.ScaleAmount(unfilled, order.Decimals, decimals))
+	}
+	if amount.Sign() < 0 {
+		return big.NewInt(0)
+	}
+	return amount
+}
+
+// getNotional returns the notional of an amount at a price, both in notional decimals.
+func getNotional(amount *big.Int, decimals int, price *big.Int) *big.Int {
+	return common.BigDiv(common.BigMul(amount, price), common.BigExp(big.NewInt(10), int64(decimals)))
+}
+
+// getShortNotionals returns the short notional of every symbol which has a tracked short at mark prices, in notional
+// decimals.
+func (e *ElasticLM) getShortNotionals(ctx context.Context) (map[string]*big.Int, error) {
+	legs := e.getHedgeLegs()
+	symbols := make(map[string]struct{}, len(legs))
+	for symbol := range legs {
+		symbols[symbol] = struct{}{}
+	}
+	for symbol := range e.unallocatedAmounts {
+		symbols[symbol] = struct{}{}
+	}
+	for _, order := range e.pendingOrders {
+		symbols[order.Symbol] = struct{}{}
+	}
+
+	notionals := make(map[string]*big.Int, len(symbols))
+	for symbol := range symbols {
+		decimals := e.getSymbolDecimals(symbol, legs[symbol])
+		amount := e.getShortAmount(symbol, legs[symbol], decimals)
+		if common.BigIsZero(amount) {
+			continue
+		}
+
+		markPrice, err := e.getMarkPrice(ctx, symbol)
+		if err != nil {
+			return nil, err
+		}
+		notionals[symbol] = getNotional(amount, decimals, common.DecimalToAmount(markPrice, notionalDecimals))
+	}
+	return notionals, nil
+}
+
+// getMaxOrderQuantity returns the largest quantity of an order of a symbol within the max order notional at mark price,
+// nil for no limit.
+func (e *ElasticLM) getMaxOrderQuantity(ctx context.Context, symbol string, decimals int) (*big.Int, error) {
+	if e.limits.MaxOrderNotional <= 0 {
+		return nil, nil
+	}
+
+	markPrice, err := e.getMarkPrice(ctx, symbol)
+	if err != nil {
+		return nil, err
+	}
+	price := common.DecimalToAmount(markPrice, notionalDecimals)
+	if price.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid mark price of %s: %v", symbol, markPrice)
+	}
+	limit := common.DecimalToAmount(e.limits.MaxOrderNotional, notionalDecimals)
+	return getLimitQuantity(limit, price, decimals, e.symbolInfoMap[symbol].QuantityPrecision), nil
+}
+
+// getLimitQuantity returns the largest quantity in the decimals and precision whose notional at a price is within a
+// limit, both in notional decimals.
+func getLimitQuantity(limit *big.Int, price *big.Int, decimals int, precision int) *big.Int {
+	if limit.Sign() <= 0 || price.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+	return common.RoundAmount(
+		common.BigDiv(common.BigMul(limit, common.BigExp(big.NewInt(10), int64(decimals))), price),
+		decimals,
+		precision,
+		common.RoundTypeFloor,
+	)
+}
+
+// checkLimits returns the quantity of an order which is within the max order notional and, for orders increasing
+// shorts, the max short notional of its symbol and the max total short notional. An order breaking a limit is clipped
+// or refused by the limit action with an alert, refused orders return errLimitExceeded.
+func (e *ElasticLM) checkLimits(ctx context.Context, order *pendingOrder, reduceOnly bool) (*big.Int, error) {
+	if !e.hasLimits() {
+		return order.Quantity, nil
+	}
+	increasesShorts := !reduceOnly && order.Side == futures.SideTypeSell
+	if !increasesShorts && e.limits.MaxOrderNotional <= 0 {
+		return order.Quantity, nil
+	}
+
+	markPrice, err := e.getMarkPrice(ctx, order.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	notionals := make(map[string]*big.Int)
+	if increasesShorts {
+		notionals, err = e.getShortNotionals(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	price := common.DecimalToAmount(markPrice, notionalDecimals)
+	orderNotional := getNotional(order.Quantity, order.Decimals, price)
+	var allowed *big.Int
+	limit := ""
+	restrict := func(remaining *big.Int, name string) {
+		if allowed == nil || remaining.Cmp(allowed) < 0 {
+			allowed, limit = remaining, name
+		}
+	}
+	if e.limits.MaxOrderNotional > 0 {
+		restrict(common.DecimalToAmount(e.limits.MaxOrderNotional, notionalDecimals), "order")
+	}
+	if maxNotional := e.getMaxSymbolNotional(order.Symbol); increasesShorts && maxNotional > 0 {
+		remaining := common.DecimalToAmount(maxNotional, notionalDecimals)
+		if notional, ok := notionals[order.Symbol]; ok {
+			remaining = common.BigSub(remaining, notional)
+		}
+		restrict(remaining, "symbol")
+	}
+	if increasesShorts && e.limits.MaxTotalNotional > 0 {
+		remaining := common.DecimalToAmount(e.limits.MaxTotalNotional, notionalDecimals)
+		for _, notional := range notionals {
+			remaining = common.BigSub(remaining, notional)
+		}
+		restrict(remaining, "total")
+	}
+	if allowed == nil || orderNotional.Cmp(allowed) <= 0 {
+		return order.Quantity, nil
+	}
+
+	quantity := getLimitQuantity(allowed, price, order.Decimals, e.symbolInfoMap[order.Symbol].QuantityPrecision)
+	if quantity.Cmp(order.Quantity) > 0 {
+		quantity = order.Quantity
+	}
+
+	action := e.limits.Action
+	if action == "" {
+		action = LimitActionClip
+	}
+	// Reduce-only orders are exempt from the min notional.
+	if action == LimitActionClip && !reduceOnly && !common.BigIsZero(quantity) {
+		ok, err := e.checkMinNotionalAt(order.Symbol, quantity, order.Decimals, markPrice)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			quantity = big.NewInt(0)
+		}
+	}
+	if action == LimitActionRefuse {
+		quantity = big.NewInt(0)
+	}
+
+	side := "Sell"
+	if order.Side == futures.SideTypeBuy {
+		side = "Buy"
+	}
+	message := fmt.Sprintf(
+		"%s order of %s for %s (%.2f %s) exceeds the %s exposure limit, ",
+		side,
+		order.Symbol,
+		common.FormatAmount(order.Quantity, order.Decimals, 5),
+		common.AmountToFloat(orderNotional, notionalDecimals),
+		e.quoteCurrency,
+		limit,
+	)
+	if common.BigIsZero(quantity) {
+		message += "it is refused"
+	} else {
+		message += fmt.Sprintf("it is clipped to %s", common.FormatAmount(quantity, order.Decimals, 5))
+	}
+	e.logger.Warnw(
+		"Order exceeds exposure limit",
+		"symbol", order.Symbol,
+		"side", order.Side,
+		"quantity", common.FormatAmount(order.Quantity, order.Decimals, 5),
+		"notional", common.AmountToFloat(orderNotional, notionalDecimals),
+		"allowedNotional", common.AmountToFloat(allowed, notionalDecimals),
+		"limit", limit,
+		"clippedQuantity", common.FormatAmount(quantity, order.Decimals, 5),
+	)
+	e.alert(ctx, fmt.Sprintf("limit:%s:%s", limit, order.Symbol), message)
+
+	if common.BigIsZero(quantity) {
+		return nil, errLimitExceeded
+	}
+	return quantity, nil
+}
+
+// clipOrder reduces the quantity of an order and shares the reduced quantity among its allocations pro rata.
+func clipOrder(order *pendingOrder, quantity *big.Int) {
+	remaining := order.signedAmount(quantity)
+	for i, allocation := range order.Allocations {
+		share := remaining
+		if i < len(order.Allocations)-1 {
+			share = common.BigDiv(common.BigMul(allocation.Amount, quantity), order.Quantity)
+		}
+		remaining = common.BigSub(remaining, share)
+		allocation.Amount = share
+	}
+	order.Quantity = quantity
+}
+
+// alert sends an alert to the operators, failures are only logged.
+func (e *ElasticLM) alert(ctx context.Context, key string, message string) {
+	err := e.alerter.Alert(ctx, key, message)
+	if err != nil {
+		e.logger.Warnw("Fail to send alert", "key", key, "message", message, "error", err)
+	}
+}
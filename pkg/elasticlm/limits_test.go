@@ -0,0 +1,235 @@
+package elasticlm
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testAlerter records the keys of alerts.
+type testAlerter struct {
+	keys []string
+}
+
+func (a *testAlerter) Alert(_ context.Context, key string, _ string) error {
+	a.keys = append(a.keys, key)
+	return nil
+}
+
+// placeTestStopSell places a resting stop sell order below the test price, like a rung of a ladder.
+func placeTestStopSell(t *testing.T, e *ElasticLM, quantity string, allocations ...*orderAllocation) *pendingOrder {
+	order := &pendingOrder{
+		Symbol:      testSymbol,
+		Decimals:    testDecimals,
+		Side:        futures.SideTypeSell,
+		Quantity:    ethAmount(quantity),
+		Filled:      big.NewInt(0),
+		Resting:     true,
+		StopPrice:   "900",
+		Allocations: allocations,
+	}
+	_, err := e.createOrder(
+		context.Background(),
+		order,
+		quantity,
+		"890",
+		order.StopPrice,
+		futures.OrderTypeStop,
+		futures.TimeInForceTypeGTC,
+		false,
+	)
+	require.NoError(t, err)
+	require.Contains(t, e.pendingOrders, order.ClientOrderID)
+	return order
+}
+
+func TestCheckLimits(t *testing.T) {
+	tests := []struct {
+		name        string
+		limits      LimitOptions
+		pending     string
+		resting     string
+		side        futures.SideType
+		reduceOnly  bool
+		expected    string
+		expectedKey string
+	}{
+		{name: "no limits", expected: "0.5"},
+		{
+			name:        "order limit",
+			limits:      LimitOptions{MaxOrderNotional: 400},
+			expected:    "0.4",
+			expectedKey: "limit:order:ETHBUSD",
+		},
+		{
+			name:        "symbol limit",
+			limits:      LimitOptions{MaxSymbolNotional: 649.5},
+			expected:    "0.349",
+			expectedKey: "limit:symbol:ETHBUSD",
+		},
+		{
+			name:        "symbol limit counts pending sell orders",
+			limits:      LimitOptions{MaxSymbolNotional: 600},
+			pending:     "0.2",
+			expected:    "0.1",
+			expectedKey: "limit:symbol:ETHBUSD",
+		},
+		{
+			name:        "symbol limit allows exactly its remaining notional",
+			limits:      LimitOptions{MaxSymbolNotional: 600},
+			expected:    "0.3",
+			expectedKey: "limit:symbol:ETHBUSD",
+		},
+		{
+			name:        "symbol limit is just below remaining notional",
+			limits:      LimitOptions{MaxSymbolNotional: 599.999},
+			expected:    "0.299",
+			expectedKey: "limit:symbol:ETHBUSD",
+		},
+		{name: "order limit at order notional", limits: LimitOptions{MaxOrderNotional: 500}, expected: "0.5"},
+		{
+			name:        "symbol limit override",
+			limits:      LimitOptions{MaxSymbolNotional: 100, SymbolMaxNotionals: map[string]float64{testSymbol: 700}},
+			expected:    "0.4",
+			expectedKey: "limit:symbol:ETHBUSD",
+		},
+		{
+			name:        "total limit",
+			limits:      LimitOptions{MaxTotalNotional: 500},
+			expected:    "0.2",
+			expectedKey: "limit:total:ETHBUSD",
+		},
+		{
+			name:        "most restrictive limit",
+			limits:      LimitOptions{MaxOrderNotional: 400, MaxTotalNotional: 649.5},
+			expected:    "0.349",
+			expectedKey: "limit:total:ETHBUSD",
+		},
+		{
+			name:        "symbol limit is reached",
+			limits:      LimitOptions{MaxSymbolNotional: 300},
+			expectedKey: "limit:symbol:ETHBUSD",
+		},
+		{
+			name:        "clipped below min notional",
+			limits:      LimitOptions{MaxOrderNotional: 4},
+			expectedKey: "limit:order:ETHBUSD",
+		},
+		{
+			name:        "refuse action",
+			limits:      LimitOptions{MaxOrderNotional: 400, Action: LimitActionRefuse},
+			expectedKey: "limit:order:ETHBUSD",
+		},
+		{
+			name:        "symbol limit counts resting stop sell orders",
+			limits:      LimitOptions{MaxSymbolNotional: 600},
+			resting:     "0.2",
+			expected:    "0.1",
+			expectedKey: "limit:symbol:ETHBUSD",
+		},
+		{
+			name:        "reduce only",
+			limits:      LimitOptions{MaxOrderNotional: 400},
+			reduceOnly:  true,
+			expected:    "0.4",
+			expectedKey: "limit:order:ETHBUSD",
+		},
+		{
+			name:        "buy order limit",
+			limits:      LimitOptions{MaxOrderNotional: 400},
+			side:        futures.SideTypeBuy,
+			expected:    "0.4",
+			expectedKey: "limit:order:ETHBUSD",
+		},
+		{
+			name:     "buy ignores symbol limit",
+			limits:   LimitOptions{MaxSymbolNotional: 300},
+			side:     futures.SideTypeBuy,
+			expected: "0.5",
+		},
+		{
+			name:       "reduce only ignores total limit",
+			limits:     LimitOptions{MaxTotalNotional: 300},
+			reduceOnly: true,
+			expected:   "0.5",
+		},
+		{
+			name:        "reduce only below min notional",
+			limits:      LimitOptions{MaxOrderNotional: 4},
+			reduceOnly:  true,
+			expected:    "0.004",
+			expectedKey: "limit:order:ETHBUSD",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			alerter := &testAlerter{}
+			e, _ := newTestElasticLM(t, Options{Limits: test.limits, Alerter: alerter})
+			e.positionMap["1"] = newTestPosition("1", "1", "0.3")
+			if test.pending != "" {
+				placeTestLimitSell(t, e, test.pending, newTestAllocation("1", test.pending))
+			}
+			if test.resting != "" {
+				placeTestStopSell(t, e, test.resting, newTestAllocation("1", test.resting))
+			}
+			side := test.side
+			if side == "" {
+				side = futures.SideTypeSell
+			}
+
+			order := &pendingOrder{
+				Symbol:      testSymbol,
+				Decimals:    testDecimals,
+				Side:        side,
+				Quantity:    ethAmount("0.5"),
+				Filled:      big.NewInt(0),
+				Allocations: []*orderAllocation{newTestAllocation("1", "0.5")},
+			}
+			quantity, err := e.checkLimits(context.Background(), order, test.reduceOnly)
+			if test.expected == "" {
+				assert.ErrorIs(t, err, errLimitExceeded)
+			} else {
+				require.NoError(t, err)
+				assertAmount(t, test.expected, quantity)
+			}
+			if test.expectedKey == "" {
+				assert.Empty(t, alerter.keys)
+			} else {
+				assert.Equal(t, []string{test.expectedKey}, alerter.keys)
+			}
+		})
+	}
+}
+
+func TestClipOrder(t *testing.T) {
+	for _, side := range []futures.SideType{futures.SideTypeSell, futures.SideTypeBuy} {
+		order := &pendingOrder{Side: side, Quantity: ethAmount("0.5")}
+		order.Allocations = []*orderAllocation{
+			newTestAllocation("1", "0.3"), newTestAllocation("2", "0.2"),
+		}
+		for _, allocation := range order.Allocations {
+			allocation.Amount = order.signedAmount(allocation.Amount)
+		}
+
+		clipOrder(order, ethAmount("0.25"))
+		assertAmount(t, "0.25", order.Quantity)
+		assert.Equal(t, order.signedAmount(ethAmount("0.15")).String(), order.Allocations[0].Amount.String(), side)
+		assert.Equal(t, order.signedAmount(ethAmount("0.1")).String(), order.Allocations[1].Amount.String(), side)
+	}
+}
+
+func TestHedgeDeltasClipsOrdersByLimits(t *testing.T) {
+	e, sim := newTestElasticLM(t, Options{Limits: LimitOptions{MaxOrderNotional: 250}})
+	e.positionMap["1"] = newTestPosition("1", "1", "0")
+	e.positionMap["2"] = newTestPosition("2", "1", "0")
+
+	e.hedgeDeltas(context.Background(), []hedgeDelta{newTestDelta("1", "0.3"), newTestDelta("2", "0.2")})
+	assert.Equal(t, 0.25, getTestShort(sim))
+	assertAmount(t, "0.15", e.positionMap["1"].HedgedAmount0)
+	assertAmount(t, "0.1", e.positionMap["2"].HedgedAmount0)
+}
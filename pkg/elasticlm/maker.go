@@ -0,0 +1,336 @@
+package elasticlm
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	bcommon "github.com/adshao/go-binance/v2/common"
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+)
+
+// ExecutionStyle defines how hedge orders are sent to the exchange.
+type ExecutionStyle string
+
+const (
+	// ExecutionStyleMarket sends hedge orders as market orders.
+	ExecutionStyleMarket ExecutionStyle = "market"
+	// ExecutionStyleMaker sends hedge orders as post-only limit orders at the best price of their side.
+	ExecutionStyleMaker ExecutionStyle = "maker"
+)
+
+func (s ExecutionStyle) validate() error {
+	switch s {
+	case "", ExecutionStyleMarket, ExecutionStyleMaker:
+		return nil
+	default:
+		return fmt.Errorf("invalid execution style: %s", s)
+	}
+}
+
+// ExecutionOptions holds the settings of sending hedge orders to the exchange.
+type ExecutionOptions struct {
+	// Style decides how hedge orders are sent, they are market orders by default.
+	Style ExecutionStyle
+	// MakerTimeout is how long maker orders are re-priced at the best price before they are given up.
+	MakerTimeout time.Duration
+	// With MakerFallback, the unfilled amounts of maker orders are sent as market orders after MakerTimeout.
+	MakerFallback bool
+}
+
+func (o ExecutionOptions) validate() error {
+	err := o.Style.validate()
+	if err != nil {
+		return err
+	}
+	if o.Style == ExecutionStyleMaker && o.MakerTimeout <= 0 {
+		return fmt.Errorf("maker timeout %s must be positive", o.MakerTimeout)
+	}
+	return nil
+}
+
+// makerOrder is a hedge order which is worked as post-only limit orders at the best price of its side.
+// The working order is re-priced as the book moves, until the whole quantity is filled or the deadline passes.
+// Allocations are the signed amounts which are not on the book while there is no working order.
+type makerOrder struct {
+	Symbol      string
+	Decimals    int
+	Side        futures.SideType
+	ReduceOnly  bool
+	Deadline    time.Time
+	Price       string
+	Allocations []*orderAllocation
+	Order       *pendingOrder
+}
+
+// remainders returns the signed amounts of the maker order's allocations which are not filled yet.
+func (m *makerOrder) remainders() []*orderAllocation {
+	if m.Order == nil {
+		return m.Allocations
+	}
+
+	allocations := make([]*orderAllocation, 0, len(m.Order.Allocations))
+	for _, allocation := range m.Order.Allocations {
+		allocations = append(allocations, &orderAllocation{
+			PositionID: allocation.PositionID,
+			TokenIndex: allocation.TokenIndex,
+			Amount:     common.BigSub(allocation.Amount, allocation.Applied),
+			Applied:    big.NewInt(0),
+		})
+	}
+	return allocations
+}
+
+func (m *makerOrder) quantity() *big.Int {
+	net := big.NewInt(0)
+	for _, allocation := range m.Allocations {
+		net = common.BigAdd(net, allocation.Amount)
+	}
+	return common.BigAbs(net)
+}
+
+// createMakerOrder starts working an order as post-only limit orders, the first one is placed on the same update.
+func (e *ElasticLM) createMakerOrder(order *pendingOrder, reduceOnly bool) {
+	e.makerOrders[order.Symbol] = &makerOrder{
+		Symbol:      order.Symbol,
+		Decimals:    order.Decimals,
+		Side:        order.Side,
+		ReduceOnly:  reduceOnly,
+		Deadline:    time.Now().Add(e.execution.MakerTimeout),
+		Allocations: order.Allocations,
+	}
+
+	e.logger.Infow(
+		"Work hedge order as maker",
+		"symbol", order.Symbol,
+		"side", order.Side,
+		"quantity", common.FormatAmount(order.Quantity, order.Decimals, 5),
+		"deadline", e.execution.MakerTimeout,
+	)
+}
+
+// restoreMakerOrders works the post-only orders restored from the journal again with a new deadline.
+// They are re-priced at the best price on the first update of positions.
+func (e *ElasticLM) restoreMakerOrders() {
+	for _, order := range e.pendingOrders {
+		if !order.PostOnly {
+			continue
+		}
+
+		e.makerOrders[order.Symbol] = &makerOrder{
+			Symbol:     order.Symbol,
+			Decimals:   order.Decimals,
+			Side:       order.Side,
+			ReduceOnly: order.Side == futures.SideTypeBuy,
+			Deadline:   time.Now().Add(e.execution.MakerTimeout),
+			Order:      order,
+		}
+	}
+}
+
+// chaseMakerOrders re-prices the working orders of maker orders when the best prices move,
+// and falls back to market orders, or keeps the unfilled amounts as residuals, once their deadlines pass.
+func (e *ElasticLM) chaseMakerOrders(ctx context.Context) {
+	symbols := make([]string, 0, len(e.makerOrders))
+	for symbol := range e.makerOrders {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	for _, symbol := range symbols {
+		e.chaseMakerOrder(ctx, e.makerOrders[symbol])
+	}
+}
+
+func (e *ElasticLM) chaseMakerOrder(ctx context.Context, m *makerOrder) {
+	l := e.logger.With("symbol", m.Symbol)
+
+	expired := time.Now().After(m.Deadline)
+	price := ""
+	if !expired {
+		var err error
+		price, err = e.getBestPrice(ctx, m.Symbol, m.Side)
+		if err != nil {
+			l.Warnw("Fail to get best price of symbol", "error", err)
+			return
+		}
+	}
+
+	if m.Order != nil {
+		if _, ok := e.pendingOrders[m.Order.ClientOrderID]; ok {
+			if !expired && price == m.Price {
+				return
+			}
+			if !e.cancelOrder(ctx, m.Order) {
+				return
+			}
+		}
+		m.Allocations = m.remainders()
+		m.Order = nil
+	}
+
+	quantity := m.quantity()
+	if common.BigIsZero(quantity) {
+		l.Infow("Maker order is filled")
+		delete(e.makerOrders, m.Symbol)
+		return
+	}
+
+	if expired {
+		e.finishMakerOrder(ctx, m, "deadline passed")
+		return
+	}
+
+	e.placeMakerOrder(ctx, m, quantity, price)
+}
+
+// placeMakerOrder places the unfilled quantity of a maker order as a post-only limit order at the price.
+// An order which would take liquidity is rejected, it is placed again on the next update.
+func (e *ElasticLM) placeMakerOrder(ctx context.Context, m *makerOrder, quantity *big.Int, price string) {
+	l := e.logger.With("symbol", m.Symbol)
+	precision := e.symbolInfoMap[m.Symbol].QuantityPrecision
+
+	order := &pendingOrder{
+		Symbol:   m.Symbol,
+		Decimals: m.Decimals,
+		Side:     m.Side,
+		Quantity: quantity,
+		Filled:   big.NewInt(0),
+		PostOnly: true,
+	}
+	for _, allocation := range m.Allocations {
+		order.Allocations = append(order.Allocations, &orderAllocation{
+			PositionID: allocation.PositionID,
+			TokenIndex: allocation.TokenIndex,
+			Amount:     allocation.Amount,
+			Applied:    big.NewInt(0),
+		})
+	}
+
+	l.Infow(
+		"Place maker order",
+		"side", m.Side,
+		"quantity", common.FormatAmount(quantity, m.Decimals, 5),
+		"price", price,
+	)
+	_, err := e.createOrder(
+		ctx,
+		order,
+		common.FormatAmount(quantity, m.Decimals, precision),
+		price,
+		"",
+		futures.OrderTypeLimit,
+		futures.TimeInForceTypeGTX,
+		m.ReduceOnly,
+	)
+	// Orders of unknown results stay pending until they are looked up.
+	if _, ok := e.pendingOrders[order.ClientOrderID]; ok {
+		m.Order = order
+		m.Price = price
+		return
+	}
+	if err != nil && bcommon.IsAPIError(err) && !isPostOnlyRejected(err) {
+		l.Warnw("Fail to place maker order", "error", err)
+		e.finishMakerOrder(ctx, m, err.Error())
+	}
+}
+
+// finishMakerOrder stops working a maker order and hedges its unfilled amounts as market orders if falling back
+// is enabled, otherwise they are kept as residuals for the strategies to decide on. Amounts which fail to be sent are
+// kept as residuals too.
+func (e *ElasticLM) finishMakerOrder(ctx context.Context, m *makerOrder, reason string) {
+	l := e.logger.With("symbol", m.Symbol)
+	delete(e.makerOrders, m.Symbol)
+
+	l.Infow(
+		"Stop working maker order",
+		"quantity", common.FormatAmount(m.quantity(), m.Decimals, 5),
+		"fallback", e.execution.MakerFallback,
+		"reason", reason,
+	)
+
+	deltas := e.allocationDeltas(m.Allocations, m.Decimals)
+	if len(deltas) == 0 {
+		return
+	}
+	if !e.execution.MakerFallback {
+		e.keepResiduals(m.Symbol, deltas, reason)
+		return
+	}
+
+	// The fallback is a market hedge of the unfilled amounts, which is sliced and guarded against slippage as usual.
+	// It continues the maker order, so it isn't held back by the cooldown which the maker order started.
+	delete(e.lastOrderTimes, m.Symbol)
+	orders, parents := len(e.pendingOrders), len(e.parentOrders)
+	err := e.hedgeSymbol(ctx, m.Symbol, deltas, ExecutionStyleMarket)
+	if err == nil {
+		return
+	}
+	l.Errorw("Fail to fall back to market order", "error", err)
+	// Orders of unknown results stay pending until they are looked up, so their amounts aren't kept twice.
+	if len(e.pendingOrders) == orders && len(e.parentOrders) == parents {
+		e.keepResiduals(m.Symbol, deltas, err.Error())
+	}
+}
+
+// allocationDeltas returns the signed amounts of allocations in an order's decimals as deltas of their tokens.
+func (e *ElasticLM) allocationDeltas(allocations []*orderAllocation, decimals int) []hedgeDelta {
+	var deltas []hedgeDelta
+	for _, allocation := range allocations {
+		pos, ok := e.positionMap[allocation.PositionID]
+		if !ok {
+			continue
+		}
+
+		token := pos.Token(allocation.TokenIndex)
+		token.Amount = common.ScaleAmount(allocation.Amount, decimals, token.Decimals)
+		if common.BigIsZero(token.Amount) {
+			continue
+		}
+		deltas = append(deltas, hedgeDelta{PositionID: allocation.PositionID, TokenIndex: allocation.TokenIndex, Token: token})
+	}
+	return deltas
+}
+
+// getBestPrice returns the best price of the book's side which an order of the side rests on.
+func (e *ElasticLM) getBestPrice(ctx context.Context, symbol string, side futures.SideType) (string, error) {
+	book, err := e.bclient.GetBookTicker(ctx, symbol)
+	if err != nil {
+		return "", err
+	}
+	if side == futures.SideTypeBuy {
+		return book.BidPrice, nil
+	}
+	return book.AskPrice, nil
+}
+
+// cancelOrder cancels an open order and applies its fills.
+// It returns false if the order can't be cancelled, it is then looked up to apply its final state.
+func (e *ElasticLM) cancelOrder(ctx context.Context, order *pendingOrder) bool {
+	l := e.logger.With("clientOrderID", order.ClientOrderID, "symbol", order.Symbol)
+
+	resp, err := e.bclient.CancelFutureOrder(ctx, order.Symbol, order.ClientOrderID)
+	if err != nil {
+		// The order may be filled meanwhile, so it is looked up to apply its final state.
+		l.Warnw("Fail to cancel order", "error", err)
+		e.requestResync()
+		return false
+	}
+	e.updateJournalOrder(order.ClientOrderID, resp.Status, resp.ExecutedQuantity, "", resp.UpdateTime)
+
+	filled, err := common.ParseAmount(resp.ExecutedQuantity, order.Decimals)
+	if err != nil {
+		l.Errorw("Fail to parse filled quantity", "quantity", resp.ExecutedQuantity, "error", err)
+		return false
+	}
+	e.applyOrderFill(order.ClientOrderID, order, filled, resp.Status)
+	return true
+}
+
+func isPostOnlyRejected(err error) bool {
+	apiErr, ok := err.(*bcommon.APIError)
+	return ok && apiErr.Code == -5022
+}
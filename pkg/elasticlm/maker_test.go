@@ -0,0 +1,114 @@
+package elasticlm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/hiepnv90/elastic-lm/pkg/models"
+	"github.com/hiepnv90/elastic-lm/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testMakerOptions = Options{Execution: ExecutionOptions{Style: ExecutionStyleMaker, MakerTimeout: time.Minute}}
+
+func TestChaseMakerOrders(t *testing.T) {
+	prices := simulator.StaticPrices{testSymbol: testPrice}
+	e, sim := newTestElasticLMAt(t, prices, testMakerOptions)
+	e.positionMap["1"] = newTestPosition("1", "1", "0")
+
+	e.hedgeDeltas(context.Background(), []hedgeDelta{newTestDelta("1", "0.5")})
+	require.Contains(t, e.makerOrders, testSymbol)
+	m := e.makerOrders[testSymbol]
+
+	e.chaseMakerOrders(context.Background())
+	require.NotNil(t, m.Order)
+	first := m.Order.ClientOrderID
+	row := getJournalOrder(t, e, first)
+	assert.Equal(t, string(futures.OrderTypeLimit), row.Type)
+	assert.Equal(t, "1000.01", row.Price, "sell order rests at the best ask")
+	assert.Equal(t, 0.0, getTestShort(sim))
+
+	e.chaseMakerOrders(context.Background())
+	assert.Equal(t, first, m.Order.ClientOrderID, "order at the best price is kept")
+
+	prices[testSymbol] = 999
+	e.chaseMakerOrders(context.Background())
+	assert.Equal(t, string(futures.OrderStatusTypeCanceled), getJournalOrder(t, e, first).Status)
+	assert.Equal(t, "999.01", getJournalOrder(t, e, m.Order.ClientOrderID).Price, "order is re-priced")
+
+	prices[testSymbol] = 999.01
+	sim.MatchOrders()
+	e.resyncPendingOrders(context.Background())
+	e.chaseMakerOrders(context.Background())
+	assert.Equal(t, 0.5, getTestShort(sim))
+	assert.Empty(t, e.makerOrders)
+	assertAmount(t, "0.5", e.positionMap["1"].HedgedAmount0)
+}
+
+func TestChaseMakerOrdersAfterDeadline(t *testing.T) {
+	tests := []struct {
+		name     string
+		fallback bool
+		short    float64
+		hedged   string
+	}{
+		{name: "fallback to market order", fallback: true, short: 0.5, hedged: "0.5"},
+		{name: "keep residuals", hedged: "0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := testMakerOptions
+			opts.Execution.MakerFallback = test.fallback
+			e, sim := newTestElasticLM(t, opts)
+			e.positionMap["1"] = newTestPosition("1", "1", "0")
+			e.hedgeDeltas(context.Background(), []hedgeDelta{newTestDelta("1", "0.5")})
+			e.chaseMakerOrders(context.Background())
+			working := e.makerOrders[testSymbol].Order.ClientOrderID
+
+			e.makerOrders[testSymbol].Deadline = time.Now().Add(-time.Second)
+			e.chaseMakerOrders(context.Background())
+			assert.Empty(t, e.makerOrders)
+			assert.Equal(t, string(futures.OrderStatusTypeCanceled), getJournalOrder(t, e, working).Status)
+			assert.Equal(t, test.short, getTestShort(sim))
+			assertAmount(t, test.hedged, e.positionMap["1"].HedgedAmount0)
+			if test.fallback {
+				assert.Empty(t, e.residuals)
+				orders, err := models.ListOrders(e.db, "1", testSymbol, 1)
+				require.NoError(t, err)
+				assert.Equal(t, string(futures.OrderTypeMarket), orders[0].Type)
+			} else {
+				require.Contains(t, e.residuals, hedgeLeg{PositionID: "1"})
+				assertAmount(t, "0.5", e.residuals[hedgeLeg{PositionID: "1"}].Token.Amount)
+			}
+		})
+	}
+}
+
+func TestChaseMakerOrdersKeepsFailedFallback(t *testing.T) {
+	opts := testMakerOptions
+	opts.Execution.MakerFallback = true
+	e, sim := newTestElasticLM(t, opts)
+	openTestShort(t, sim, "0.5")
+	e.positionMap["1"] = newTestPosition("1", "1", "0.5")
+	e.hedgeDeltas(context.Background(), []hedgeDelta{newTestDelta("1", "-0.5")})
+	e.chaseMakerOrders(context.Background())
+	require.NotNil(t, e.makerOrders[testSymbol].Order)
+
+	// The short is closed by hand meanwhile, so the reduce only market order is rejected.
+	_, err := sim.CreateFutureOrder(
+		context.Background(), testSymbol, "0.5", "0", "", futures.SideTypeBuy, futures.OrderTypeMarket,
+		futures.TimeInForceTypeGTC, true, "", "manual-close",
+	)
+	require.NoError(t, err)
+	e.makerOrders[testSymbol].Deadline = time.Now().Add(-time.Second)
+	e.chaseMakerOrders(context.Background())
+	assert.Empty(t, e.makerOrders)
+	assert.Empty(t, e.pendingOrders)
+	assertAmount(t, "0.5", e.positionMap["1"].HedgedAmount0)
+	require.Contains(t, e.residuals, hedgeLeg{PositionID: "1"})
+	assertAmount(t, "-0.5", e.residuals[hedgeLeg{PositionID: "1"}].Token.Amount, "failed fallback is kept as residual")
+}
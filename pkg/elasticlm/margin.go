@@ -0,0 +1,265 @@
+package elasticlm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/hiepnv90/elastic-lm/pkg/models"
+)
+
+// MarginOptions holds the settings of monitoring the margin of the futures account and acting on it.
+type MarginOptions struct {
+	// Interval is the interval of checking the margin, it isn't checked when it is unset.
+	Interval time.Duration
+	// An alert is sent when the margin ratio reaches AlertRatio.
+	AlertRatio float64
+	// An alert is sent when a hedged symbol's mark price is within LiquidationAlertBps of its liquidation price.
+	LiquidationAlertBps float64
+	// TopUpAmount is moved from the spot wallet when the margin ratio reaches TopUpRatio.
+	TopUpRatio  float64
+	TopUpAmount float64
+	// ReduceFraction of the shorts is closed when the margin ratio reaches ReduceRatio.
+	ReduceRatio    float64
+	ReduceFraction float64
+}
+
+// validate checks the thresholds of margin monitoring and its actions.
+func (o MarginOptions) validate() error {
+	if o.Interval < 0 || o.AlertRatio < 0 || o.LiquidationAlertBps < 0 ||
+		o.TopUpRatio < 0 || o.TopUpAmount < 0 || o.ReduceRatio < 0 {
+		return errors.New("negative margin settings")
+	}
+	if o.TopUpRatio > 0 && o.TopUpAmount <= 0 {
+		return fmt.Errorf("top-up at margin ratio %v needs an amount", o.TopUpRatio)
+	}
+	if o.ReduceRatio > 0 && (o.ReduceFraction <= 0 || o.ReduceFraction > 1) {
+		return fmt.Errorf("invalid fraction of hedges to reduce: %v", o.ReduceFraction)
+	}
+	return nil
+}
+
+// getMarginRatio returns the margin ratio of a futures account, which is its maintenance margin over its margin
+// balance. The account is liquidated when it reaches 1.
+func getMarginRatio(account *futures.Account) (float64, error) {
+	maintMargin, err := strconv.ParseFloat(account.TotalMaintMargin, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid maintenance margin %s: %w", account.TotalMaintMargin, err)
+	}
+	marginBalance, err := strconv.ParseFloat(account.TotalMarginBalance, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid margin balance %s: %w", account.TotalMarginBalance, err)
+	}
+
+	if maintMargin <= 0 {
+		return 0, nil
+	}
+	if marginBalance <= 0 {
+		return math.Inf(1), nil
+	}
+	return maintMargin / marginBalance, nil
+}
+
+// checkMargin looks up the margin ratio of the futures account and the liquidation prices of the hedged symbols, and
+// alerts when they reach their levels. When the margin ratio reaches the top-up ratio, collateral is moved from the
+// spot wallet, and when it still reaches the reduce ratio, a fraction of the shorts is closed.
+func (e *ElasticLM) checkMargin(ctx context.Context) {
+	l := e.logger
+
+	account, err := e.bclient.GetAccount(ctx)
+	if err != nil {
+		l.Errorw("Fail to get futures account", "error", err)
+		return
+	}
+	ratio, err := getMarginRatio(account)
+	if err != nil {
+		l.Errorw("Fail to get margin ratio", "error", err)
+		return
+	}
+	l.Debugw(
+		"Check margin ratio",
+		"marginRatio", ratio,
+		"maintMargin", account.TotalMaintMargin,
+		"marginBalance", account.TotalMarginBalance,
+	)
+
+	if e.margin.AlertRatio > 0 && ratio >= e.margin.AlertRatio {
+		l.Warnw("Margin ratio reaches alert level", "marginRatio", ratio, "alertRatio", e.margin.AlertRatio)
+		e.alert(ctx, "margin:ratio", fmt.Sprintf(
+			"Margin ratio of the futures account is %.2f%% (maintenance margin %s, margin balance %s %s)",
+			ratio*100, account.TotalMaintMargin, account.TotalMarginBalance, e.quoteCurrency,
+		))
+	}
+
+	if e.margin.LiquidationAlertBps > 0 {
+		e.checkLiquidationPrices(ctx)
+	}
+
+	if e.margin.TopUpRatio > 0 && ratio >= e.margin.TopUpRatio && e.topUpMargin(ctx, ratio) {
+		account, err = e.bclient.GetAccount(ctx)
+		if err != nil {
+			l.Errorw("Fail to get futures account", "error", err)
+			return
+		}
+		ratio, err = getMarginRatio(account)
+		if err != nil {
+			l.Errorw("Fail to get margin ratio", "error", err)
+			return
+		}
+	}
+
+	if e.margin.ReduceRatio <= 0 {
+		return
+	}
+	// Hedges are reduced once per breach of the reduce ratio, as closing orders take time to lower the margin ratio.
+	if ratio < e.margin.ReduceRatio {
+		if e.marginReduced {
+			l.Infow("Margin ratio is below reduce level again", "marginRatio", ratio, "reduceRatio", e.margin.ReduceRatio)
+			e.marginReduced = false
+		}
+		return
+	}
+	if !e.marginReduced && !e.halted {
+		e.reduceHedges(ctx, ratio)
+	}
+}
+
+// loadMarginHedgeScale loads the scale of hedge ratios which is left by reducing hedges on margin. Hedges which are
+// reduced before a restart aren't reduced again until the margin ratio falls below the reduce ratio.
+func (e *ElasticLM) loadMarginHedgeScale() error {
+	value, err := models.GetSetting(e.db, models.SettingMarginHedgeScale)
+	if err != nil {
+		e.logger.Errorw("Fail to load margin hedge scale", "error", err)
+		return err
+	}
+	if value == "" {
+		return nil
+	}
+
+	scale, err := strconv.ParseFloat(value, 64)
+	if err != nil || scale < 0 || scale > 1 {
+		return fmt.Errorf("invalid margin hedge scale: %s", value)
+	}
+	e.marginHedgeScale = scale
+	e.marginReduced = scale < 1
+	e.logger.Warnw("Hedge ratios are scaled down by margin reduction", "hedgeScale", scale)
+	return nil
+}
+
+// checkLiquidationPrices alerts for every hedged symbol whose mark price is within the alert distance of its
+// liquidation price.
+func (e *ElasticLM) checkLiquidationPrices(ctx context.Context) {
+	risks, err := e.bclient.GetPositionRisk(ctx, "")
+	if err != nil {
+		e.logger.Errorw("Fail to get position risks", "error", err)
+		return
+	}
+
+	legs := e.getHedgeLegs()
+	for _, risk := range risks {
+		if _, ok := legs[risk.Symbol]; !ok || !e.isHedgeSide(risk) {
+			continue
+		}
+
+		l := e.logger.With("symbol", risk.Symbol)
+		amount, err := strconv.ParseFloat(risk.PositionAmt, 64)
+		if err != nil || amount == 0 {
+			continue
+		}
+		liquidationPrice, err := strconv.ParseFloat(risk.LiquidationPrice, 64)
+		if err != nil || liquidationPrice <= 0 {
+			continue
+		}
+		markPrice, err := strconv.ParseFloat(risk.MarkPrice, 64)
+		if err != nil || markPrice <= 0 {
+			l.Warnw("Invalid mark price of position risk", "markPrice", risk.MarkPrice)
+			continue
+		}
+
+		distanceBps := math.Abs(liquidationPrice-markPrice) / markPrice * 10000
+		l.Debugw(
+			"Check liquidation price",
+			"liquidationPrice", liquidationPrice,
+			"markPrice", markPrice,
+			"distanceBps", distanceBps,
+		)
+		if distanceBps > e.margin.LiquidationAlertBps {
+			continue
+		}
+
+		l.Warnw(
+			"Mark price is near liquidation price",
+			"liquidationPrice", liquidationPrice,
+			"markPrice", markPrice,
+			"distanceBps", distanceBps,
+		)
+		e.alert(ctx, "margin:liquidation:"+risk.Symbol, fmt.Sprintf(
+			"Mark price %v of %s is %.0f bps from its liquidation price %v",
+			markPrice, risk.Symbol, distanceBps, liquidationPrice,
+		))
+	}
+}
+
+// topUpMargin moves the top-up amount of the quote currency from the spot wallet to the futures wallet, it returns
+// whether the transfer succeeded.
+func (e *ElasticLM) topUpMargin(ctx context.Context, ratio float64) bool {
+	amount := strconv.FormatFloat(e.margin.TopUpAmount, 'f', -1, 64)
+	e.logger.Warnw("Top up futures margin from spot", "marginRatio", ratio, "asset", e.quoteCurrency, "amount", amount)
+
+	err := e.bclient.TransferToFutures(ctx, e.quoteCurrency, amount)
+	if err != nil {
+		e.logger.Errorw("Fail to top up futures margin", "asset", e.quoteCurrency, "amount", amount, "error", err)
+		e.alert(ctx, "margin:topup", fmt.Sprintf(
+			"Fail to move %s %s from spot to futures at margin ratio %.2f%%: %v",
+			amount, e.quoteCurrency, ratio*100, err,
+		))
+		return false
+	}
+
+	e.alert(ctx, "margin:topup", fmt.Sprintf(
+		"Moved %s %s from spot to futures at margin ratio %.2f%%", amount, e.quoteCurrency, ratio*100,
+	))
+	return true
+}
+
+// reduceHedges closes the reduce fraction of the shorts of every symbol, and scales down the hedge ratios of all
+// positions by it, so the closed shorts aren't sold again. The scale is saved, so the hedges stay reduced across
+// restarts until hedging is resumed from the command line.
+func (e *ElasticLM) reduceHedges(ctx context.Context, ratio float64) {
+	e.marginReduced = true
+	e.marginHedgeScale *= 1 - e.margin.ReduceFraction
+	err := models.SetSetting(
+		e.db, models.SettingMarginHedgeScale, strconv.FormatFloat(e.marginHedgeScale, 'f', -1, 64),
+	)
+	if err != nil {
+		e.logger.Errorw("Fail to save margin hedge scale", "error", err)
+	}
+	e.logger.Warnw(
+		"Reduce hedges on margin ratio",
+		"marginRatio", ratio,
+		"fraction", e.margin.ReduceFraction,
+		"hedgeScale", e.marginHedgeScale,
+	)
+	e.alert(ctx, "margin:reduce", fmt.Sprintf(
+		"Margin ratio of the futures account is %.2f%%, %.0f%% of the shorts are being closed",
+		ratio*100, e.margin.ReduceFraction*100,
+	))
+
+	legs := e.getHedgeLegs()
+	symbols := make([]string, 0, len(legs))
+	for symbol := range legs {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	for _, symbol := range symbols {
+		err := e.closeShorts(ctx, symbol, legs[symbol], e.margin.ReduceFraction)
+		if err != nil {
+			e.alert(ctx, "margin:reduce:"+symbol, fmt.Sprintf("Fail to reduce shorts of %s: %v", symbol, err))
+		}
+	}
+}
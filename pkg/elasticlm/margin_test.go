@@ -0,0 +1,103 @@
+package elasticlm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hiepnv90/elastic-lm/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckMargin(t *testing.T) {
+	tests := []struct {
+		name          string
+		spotBalance   float64
+		balance       float64
+		short         float64
+		hedged        string
+		alerts        []string
+		expectedScale float64
+	}{
+		{
+			name:          "top up",
+			spotBalance:   1000,
+			balance:       199.6,
+			short:         1,
+			hedged:        "1",
+			alerts:        []string{"margin:ratio", "margin:topup"},
+			expectedScale: 1,
+		},
+		{
+			name:          "reduce hedges when top-up fails",
+			balance:       59.384, // closing half of the short realizes half of the loss
+			short:         0.5,
+			hedged:        "0.5",
+			alerts:        []string{"margin:ratio", "margin:topup", "margin:reduce"},
+			expectedScale: 0.5,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			prices := simulator.StaticPrices{testSymbol: testPrice}
+			sim := simulator.New(testMarketData{}, prices, 4, map[string]float64{"BUSD": 100})
+			sim.SetSpotBalance("BUSD", test.spotBalance)
+			alerter := &testAlerter{}
+			e := newTestElasticLMOn(t, sim, Options{
+				Margin: MarginOptions{
+					AlertRatio:     0.1,
+					TopUpRatio:     0.2,
+					TopUpAmount:    100,
+					ReduceRatio:    0.2,
+					ReduceFraction: 0.5,
+				},
+				Alerter: alerter,
+			})
+			e.positionMap["1"] = newTestPosition("1", "1", "1")
+			openTestShort(t, sim, "1")
+
+			// The loss of 80 BUSD leaves a margin balance of 19.6 BUSD for the maintenance margin of 4.32 BUSD.
+			prices[testSymbol] = 1080
+			e.checkMargin(context.Background())
+			assert.Equal(t, test.alerts, alerter.keys)
+			assert.InDelta(t, test.balance, sim.Balance("BUSD"), 1e-6)
+			assert.Equal(t, test.short, getTestShort(sim))
+			assertAmount(t, test.hedged, e.positionMap["1"].HedgedAmount0)
+			assert.Equal(t, test.expectedScale, e.marginHedgeScale)
+		})
+	}
+}
+
+func TestCheckMarginReducesOncePerBreach(t *testing.T) {
+	prices := simulator.StaticPrices{testSymbol: testPrice}
+	sim := simulator.New(testMarketData{}, prices, 4, map[string]float64{"BUSD": 100})
+	opts := Options{Margin: MarginOptions{ReduceRatio: 0.2, ReduceFraction: 0.5}, Alerter: &testAlerter{}}
+	e := newTestElasticLMOn(t, sim, opts)
+	e.positionMap["1"] = newTestPosition("1", "1", "1")
+	require.NoError(t, e.savePositions())
+	openTestShort(t, sim, "1")
+
+	prices[testSymbol] = 1080
+	e.checkMargin(context.Background())
+	require.Equal(t, 0.5, getTestShort(sim))
+
+	// The margin ratio rises further within the same breach.
+	prices[testSymbol] = 1100
+	e.checkMargin(context.Background())
+	assert.Equal(t, 0.5, getTestShort(sim), "hedges are reduced once per breach")
+	assert.Equal(t, 0.5, e.marginHedgeScale)
+
+	restarted := restartTestElasticLM(t, e, opts)
+	require.NoError(t, restarted.loadMarginHedgeScale())
+	assert.Equal(t, 0.5, restarted.marginHedgeScale, "scale is kept across restarts")
+	restarted.checkMargin(context.Background())
+	assert.Equal(t, 0.5, getTestShort(sim), "hedges reduced before the restart aren't reduced again")
+
+	prices[testSymbol] = testPrice
+	restarted.checkMargin(context.Background())
+	prices[testSymbol] = 1100
+	restarted.checkMargin(context.Background())
+	assert.Equal(t, 0.25, getTestShort(sim), "hedges are reduced again on the next breach")
+	assert.Equal(t, 0.25, restarted.marginHedgeScale)
+}
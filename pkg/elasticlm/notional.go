@@ -0,0 +1,154 @@
+package elasticlm
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+)
+
+// getMarkPrice returns the mark price of a symbol, which is cached until the next update of positions.
+// The last funding rate of the symbol is kept along with it.
+func (e *ElasticLM) getMarkPrice(ctx context.Context, symbol string) (float64, error) {
+	if markPrice, ok := e.markPrices[symbol]; ok {
+		return markPrice, nil
+	}
+	if e.bclient == nil {
+		return 0, fmt.Errorf("no exchange to get mark price of symbol %s", symbol)
+	}
+
+	premiumIndexes, err := e.bclient.GetPremiumIndex(ctx, symbol)
+	if err != nil {
+		e.logger.Errorw("Fail to get mark price", "symbol", symbol, "error", err)
+		return 0, err
+	}
+	if len(premiumIndexes) == 0 {
+		return 0, fmt.Errorf("no mark price of symbol %s", symbol)
+	}
+
+	markPrice, err := strconv.ParseFloat(premiumIndexes[0].MarkPrice, 64)
+	if err != nil {
+		e.logger.Errorw("Fail to parse mark price", "symbol", symbol, "markPrice", premiumIndexes[0].MarkPrice, "error", err)
+		return 0, err
+	}
+
+	fundingRate, err := strconv.ParseFloat(premiumIndexes[0].LastFundingRate, 64)
+	if err == nil {
+		e.fundingRates[symbol] = fundingRate
+	}
+
+	e.markPrices[symbol] = markPrice
+	return markPrice, nil
+}
+
+// checkMinNotional returns whether the notional of an order's quantity satisfies the symbol's MIN_NOTIONAL filter.
+func (e *ElasticLM) checkMinNotional(ctx context.Context, symbol string, quantity *big.Int, decimals int) (bool, error) {
+	symbolInfo := e.symbolInfoMap[symbol]
+	if symbolInfo.MinNotionalFilter() == nil {
+		return true, nil
+	}
+
+	markPrice, err := e.getMarkPrice(ctx, symbol)
+	if err != nil {
+		return false, err
+	}
+
+	return e.checkMinNotionalAt(symbol, quantity, decimals, markPrice)
+}
+
+// checkMinNotionalAt returns whether the notional of an order's quantity at a price satisfies the symbol's
+// MIN_NOTIONAL filter.
+func (e *ElasticLM) checkMinNotionalAt(symbol string, quantity *big.Int, decimals int, price float64) (bool, error) {
+	symbolInfo := e.symbolInfoMap[symbol]
+	filter := symbolInfo.MinNotionalFilter()
+	if filter == nil {
+		return true, nil
+	}
+
+	minNotional, err := strconv.ParseFloat(filter.Notional, 64)
+	if err != nil {
+		e.logger.Errorw("Fail to parse min notional", "symbol", symbol, "notional", filter.Notional, "error", err)
+		return false, err
+	}
+
+	return common.AmountToFloat(quantity, decimals)*price >= minNotional, nil
+}
+
+// keepResiduals tracks the deltas of a symbol which are too small to trade, until they can be hedged
+// together with later deltas.
+func (e *ElasticLM) keepResiduals(symbol string, deltas []hedgeDelta, reason string) {
+	net := big.NewInt(0)
+	decimals := deltas[0].Token.Decimals
+	for _, delta := range deltas {
+		e.residuals[hedgeLeg{PositionID: delta.PositionID, TokenIndex: delta.TokenIndex}] = delta
+		net = common.BigAdd(net, common.ScaleAmount(delta.Token.Amount, delta.Token.Decimals, decimals))
+	}
+
+	e.logger.Debugw(
+		"Keep net delta as residual",
+		"symbol", symbol,
+		"netAmount", common.FormatAmount(net, decimals, 5),
+		"reason", reason,
+	)
+}
+
+// takeResiduals returns the tracked residuals in a stable order and stops tracking them.
+func (e *ElasticLM) takeResiduals() []hedgeDelta {
+	deltas := make([]hedgeDelta, 0, len(e.residuals))
+	for _, delta := range e.residuals {
+		deltas = append(deltas, delta)
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		if deltas[i].PositionID != deltas[j].PositionID {
+			return deltas[i].PositionID < deltas[j].PositionID
+		}
+		return deltas[i].TokenIndex < deltas[j].TokenIndex
+	})
+
+	e.residuals = make(map[hedgeLeg]hedgeDelta)
+	return deltas
+}
+
+// RebalanceOptions holds the bands of rebalancing a token's delta and the pace of its orders.
+type RebalanceOptions struct {
+	// ThresholdNotional is the threshold in quote currency for hedging a token's delta, it replaces the threshold in
+	// bps when it is set.
+	ThresholdNotional float64
+	// TargetBps and TargetNotional are the bands which a triggered rebalance hedges the delta back to.
+	TargetBps      int
+	TargetNotional float64
+	// OrderCooldown is the minimum time between orders of a symbol.
+	OrderCooldown time.Duration
+}
+
+// validate checks that the target bands are inside the trigger bands.
+func (o RebalanceOptions) validate(thresholdBps int64) error {
+	if o.TargetBps < 0 || o.TargetNotional < 0 {
+		return fmt.Errorf("negative target band: bps=%d notional=%v", o.TargetBps, o.TargetNotional)
+	}
+	if o.TargetBps > 0 && int64(o.TargetBps) >= thresholdBps {
+		return fmt.Errorf("target band %d bps must be less than threshold %d bps", o.TargetBps, thresholdBps)
+	}
+	if o.TargetNotional > 0 && o.TargetNotional >= o.ThresholdNotional {
+		return fmt.Errorf(
+			"target band %v must be less than threshold %v in notional", o.TargetNotional, o.ThresholdNotional,
+		)
+	}
+	if o.OrderCooldown < 0 {
+		return fmt.Errorf("negative order cooldown: %s", o.OrderCooldown)
+	}
+	return nil
+}
+
+// getCooldownRemaining returns how long to wait before sending the next order of a symbol.
+func (e *ElasticLM) getCooldownRemaining(symbol string) time.Duration {
+	lastOrderTime, ok := e.lastOrderTimes[symbol]
+	if !ok || e.rebalance.OrderCooldown <= 0 {
+		return 0
+	}
+	return e.rebalance.OrderCooldown - time.Since(lastOrderTime)
+}
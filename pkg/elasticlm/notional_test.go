@@ -0,0 +1,44 @@
+package elasticlm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHedgeDeltasKeepsOffsettingDeltasAsResiduals(t *testing.T) {
+	e, sim := newTestElasticLM(t, Options{})
+	e.positionMap["1"] = newTestPosition("1", "1", "0")
+	e.positionMap["2"] = newTestPosition("2", "1", "0.3")
+
+	e.hedgeDeltas(context.Background(), []hedgeDelta{newTestDelta("1", "0.3"), newTestDelta("2", "-0.3004")})
+	assert.Equal(t, 0.0, getTestShort(sim), "net delta rounds to zero")
+	require.Len(t, e.residuals, 2)
+	assertAmount(t, "0.3", e.residuals[hedgeLeg{PositionID: "1"}].Token.Amount)
+	assertAmount(t, "-0.3004", e.residuals[hedgeLeg{PositionID: "2"}].Token.Amount)
+
+	e.hedgeDeltas(context.Background(), nil)
+	assert.Len(t, e.residuals, 2, "residuals are kept until they can be traded")
+
+	e.hedgeDeltas(context.Background(), []hedgeDelta{newTestDelta("2", "0.0504")})
+	assert.Equal(t, 0.05, getTestShort(sim))
+	assert.Empty(t, e.residuals)
+	assertAmount(t, "0.3", e.positionMap["1"].HedgedAmount0)
+	assertAmount(t, "0.05", e.positionMap["2"].HedgedAmount0)
+}
+
+func TestHedgeDeltasKeepsDeltasBelowMinNotional(t *testing.T) {
+	e, sim := newTestElasticLM(t, Options{})
+	e.positionMap["1"] = newTestPosition("1", "1", "0")
+
+	e.hedgeDeltas(context.Background(), []hedgeDelta{newTestDelta("1", "0.004")})
+	assert.Equal(t, 0.0, getTestShort(sim))
+	assert.Len(t, e.residuals, 1)
+
+	e.hedgeDeltas(context.Background(), []hedgeDelta{newTestDelta("1", "0.002")})
+	assert.Equal(t, 0.006, getTestShort(sim), "residual is hedged with the next delta")
+	assert.Empty(t, e.residuals)
+	assertAmount(t, "0.006", e.positionMap["1"].HedgedAmount0)
+}
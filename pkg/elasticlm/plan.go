@@ -0,0 +1,97 @@
+package elasticlm
+
+import (
+	"context"
+
+	"github.com/hiepnv90/elastic-lm/pkg/position"
+	"github.com/hiepnv90/elastic-lm/pkg/strategy"
+)
+
+// Plan returns the hedge plans of the positions by their strategies without executing them.
+// The plans are decided on the stored hedged amounts, including the open and parent orders of the journal.
+func (e *ElasticLM) Plan(ctx context.Context) ([]strategy.Plan, error) {
+	l := e.logger
+
+	err := e.validateHedgeModes()
+	if err != nil {
+		l.Errorw("Invalid hedge mode settings", "error", err)
+		return nil, err
+	}
+
+	err = e.loadPositions()
+	if err != nil {
+		l.Errorw("Fail to load saved positions from database", "error", err)
+		return nil, err
+	}
+
+	err = e.loadMarginHedgeScale()
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.loadOpenOrders()
+	if err != nil {
+		l.Errorw("Fail to load open orders", "error", err)
+		return nil, err
+	}
+
+	e.restoreMakerOrders()
+
+	err = e.loadParentOrders()
+	if err != nil {
+		l.Errorw("Fail to load parent orders", "error", err)
+		return nil, err
+	}
+
+	posInfos, err := e.getPositions(ctx)
+	if err != nil {
+		l.Errorw("Fail to get positions' information", "positions", e.positionIDs, "error", err)
+		return nil, err
+	}
+
+	e.markPrices = make(map[string]float64)
+	posInfos = e.estimatePositions(ctx, posInfos)
+
+	var plans []strategy.Plan
+	seen := make(map[string]bool, len(posInfos))
+	for _, posInfo := range posInfos {
+		seen[posInfo.ID] = true
+		if _, ok := e.closedPositionIDs[posInfo.ID]; ok && posInfo.IsClosed() {
+			continue
+		}
+
+		plan, err := e.previewPosition(ctx, posInfo)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+
+	for _, id := range e.getMissingPositionIDs(seen) {
+		plan, err := e.previewPosition(ctx, closedPosition(e.positionMap[id]))
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+
+	return plans, nil
+}
+
+func (e *ElasticLM) previewPosition(ctx context.Context, pos position.Position) (strategy.Plan, error) {
+	pos.HedgeRatioBps = e.getHedgeRatioBps(pos.ID)
+
+	var prev *position.Position
+	if posInfo, ok := e.positionMap[pos.ID]; ok {
+		pos.HedgedAmount0 = posInfo.HedgedAmount0
+		pos.HedgedAmount1 = posInfo.HedgedAmount1
+		prev = &posInfo
+	}
+
+	plan, err := e.planPosition(ctx, prev, pos)
+	if err != nil {
+		e.logger.Errorw("Fail to plan hedge of position", "positionID", pos.ID, "error", err)
+		return strategy.Plan{}, err
+	}
+	return plan, nil
+}
@@ -0,0 +1,126 @@
+package elasticlm
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// PollingOptions holds the settings of adapting the interval of updating positions.
+type PollingOptions struct {
+	// MinInterval is the interval of updating positions while a price is near a range edge or volatile.
+	MinInterval time.Duration
+	// MaxInterval is the interval of updating positions while everything is calm.
+	MaxInterval time.Duration
+	// A price within EdgeBps of its position's range edge polls at MinInterval.
+	EdgeBps float64
+	// An hourly volatility above VolatilityBps polls at MinInterval.
+	VolatilityBps float64
+	// VolatilityHalfLife is the half-life of the volatility of mark prices which the interval follows.
+	VolatilityHalfLife time.Duration
+	// MaxBackoff is the max interval of retrying failed updates.
+	MaxBackoff time.Duration
+}
+
+// validate checks that the adaptive intervals are around the base interval.
+func (o PollingOptions) validate(interval time.Duration) error {
+	if o.MinInterval < 0 || o.MaxInterval < 0 || o.MaxBackoff < 0 {
+		return fmt.Errorf(
+			"negative polling interval: min=%s max=%s backoff=%s", o.MinInterval, o.MaxInterval, o.MaxBackoff,
+		)
+	}
+	if o.MinInterval > interval {
+		return fmt.Errorf("min interval %s must not exceed interval %s", o.MinInterval, interval)
+	}
+	if o.MaxInterval > 0 && o.MaxInterval < interval {
+		return fmt.Errorf("max interval %s must not be less than interval %s", o.MaxInterval, interval)
+	}
+	if o.tracksVolatility() && o.VolatilityHalfLife <= 0 {
+		return fmt.Errorf("polling volatility half-life %s must be positive", o.VolatilityHalfLife)
+	}
+	return nil
+}
+
+// tracksVolatility returns whether the interval follows the volatility of mark prices.
+func (o PollingOptions) tracksVolatility() bool {
+	return o.VolatilityBps > 0 && (o.MinInterval > 0 || o.MaxInterval > 0)
+}
+
+// nextInterval returns the time until the next update of positions. It is the min interval while a position's price
+// is near an edge of its range or volatility is high, the max interval while every position is twice as far from its
+// edges and volatility is below half of the high one, and the base interval otherwise. The interval doubles with every
+// consecutive failure up to the max backoff.
+func (e *ElasticLM) nextInterval(failures int) time.Duration {
+	interval, reason := e.interval, "normal"
+	if distanceBps, ok := e.getEdgeDistanceBps(); ok {
+		volatilityBps, hasVolatility := e.getMaxVolatilityBps()
+		hasVolatility = hasVolatility && e.polling.VolatilityBps > 0
+		switch {
+		case e.polling.MinInterval > 0 && distanceBps <= e.polling.EdgeBps:
+			interval, reason = e.polling.MinInterval, "near range edge"
+		case e.polling.MinInterval > 0 && hasVolatility && volatilityBps >= e.polling.VolatilityBps:
+			interval, reason = e.polling.MinInterval, "high volatility"
+		case e.polling.MaxInterval > 0 && distanceBps > 2*e.polling.EdgeBps &&
+			(!hasVolatility || volatilityBps < e.polling.VolatilityBps/2):
+			interval, reason = e.polling.MaxInterval, "calm"
+		}
+	}
+
+	if failures > 0 && e.polling.MaxBackoff > 0 {
+		backoff := time.Duration(float64(e.interval) * math.Pow(2, float64(failures)))
+		if backoff > e.polling.MaxBackoff || backoff <= 0 {
+			backoff = e.polling.MaxBackoff
+		}
+		if backoff > interval {
+			interval, reason = backoff, "failures"
+		}
+	}
+
+	if interval != e.currentInterval {
+		e.logger.Infow("Change polling interval", "interval", interval, "reason", reason, "failures", failures)
+		e.currentInterval = interval
+	}
+	return interval
+}
+
+// getEdgeDistanceBps returns the smallest distance in bps between the price of an open position and the edges of
+// its range, and false if there is no open position.
+func (e *ElasticLM) getEdgeDistanceBps() (float64, bool) {
+	minTicks, ok := 0, false
+	for _, pos := range e.positionMap {
+		if pos.IsClosed() {
+			continue
+		}
+
+		ticks := abs(pos.Tick - pos.TickLower)
+		if d := abs(pos.TickUpper - pos.Tick); d < ticks {
+			ticks = d
+		}
+		if !ok || ticks < minTicks {
+			minTicks, ok = ticks, true
+		}
+	}
+	return (math.Pow(1.0001, float64(minTicks)) - 1) * 10000, ok
+}
+
+// getMaxVolatilityBps returns the highest hourly volatility in bps of the symbols with a volatility estimate.
+func (e *ElasticLM) getMaxVolatilityBps() (float64, bool) {
+	maxBps, ok := 0.0, false
+	for _, v := range e.pollingVolatilities {
+		sigma, ready := v.sigma(time.Hour)
+		if !ready {
+			continue
+		}
+		if bps := sigma * 10000; !ok || bps > maxBps {
+			maxBps, ok = bps, true
+		}
+	}
+	return maxBps, ok
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
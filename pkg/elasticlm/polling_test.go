@@ -0,0 +1,122 @@
+package elasticlm
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestVolatility returns a volatility estimate of the hourly volatility in bps.
+func newTestVolatility(hourlyBps float64) *volatility {
+	sigma := hourlyBps / 10000
+	return &volatility{Variance: sigma * sigma / time.Hour.Seconds(), Samples: minVolatilitySamples}
+}
+
+func TestNextInterval(t *testing.T) {
+	tests := []struct {
+		name          string
+		edgeTicks     int
+		closed        bool
+		volatilityBps float64
+		failures      int
+		expected      time.Duration
+	}{
+		{name: "no position", expected: time.Minute},
+		{name: "near range edge", edgeTicks: 50, expected: 10 * time.Second},
+		{name: "between edge and calm distance", edgeTicks: 150, expected: time.Minute},
+		{name: "calm", edgeTicks: 500, expected: 5 * time.Minute},
+		{name: "closed position", edgeTicks: 50, closed: true, expected: time.Minute},
+		{name: "high volatility", edgeTicks: 500, volatilityBps: 150, expected: 10 * time.Second},
+		{name: "moderate volatility", edgeTicks: 500, volatilityBps: 70, expected: time.Minute},
+		{name: "low volatility", edgeTicks: 500, volatilityBps: 30, expected: 5 * time.Minute},
+		{name: "backoff below calm interval", edgeTicks: 500, failures: 2, expected: 5 * time.Minute},
+		{name: "backoff near range edge", edgeTicks: 50, failures: 3, expected: 8 * time.Minute},
+		{name: "max backoff", edgeTicks: 50, failures: 5, expected: 10 * time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, _ := newTestElasticLM(t, Options{Polling: PollingOptions{
+				MinInterval:   10 * time.Second,
+				MaxInterval:   5 * time.Minute,
+				EdgeBps:       100,
+				VolatilityBps: 100,
+				MaxBackoff:    10 * time.Minute,
+			}})
+			if test.edgeTicks > 0 {
+				pos := newTestPosition("1", "1", "0")
+				pos.TickLower, pos.TickUpper = -1000, 1000
+				pos.Tick = pos.TickLower + test.edgeTicks
+				if test.closed {
+					pos.Liquidity = big.NewInt(0)
+				}
+				e.positionMap["1"] = pos
+			}
+			if test.volatilityBps > 0 {
+				e.pollingVolatilities[testSymbol] = newTestVolatility(test.volatilityBps)
+			}
+
+			assert.Equal(t, test.expected, e.nextInterval(test.failures))
+			assert.Equal(t, test.expected, e.currentInterval)
+		})
+	}
+}
+
+func TestValidatePolling(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{name: "no adaptive polling", opts: Options{}},
+		{
+			name: "volatility without cost settings",
+			opts: Options{
+				Polling: PollingOptions{MinInterval: 10 * time.Second, VolatilityBps: 100, VolatilityHalfLife: time.Hour},
+			},
+		},
+		{
+			name:     "volatility without half-life",
+			opts:     Options{Polling: PollingOptions{MinInterval: 10 * time.Second, VolatilityBps: 100}},
+			expected: "polling volatility half-life 0s must be positive",
+		},
+		{
+			name: "edges without half-life",
+			opts: Options{Polling: PollingOptions{MinInterval: 10 * time.Second, EdgeBps: 100}},
+		},
+		{
+			name:     "min interval above interval",
+			opts:     Options{Polling: PollingOptions{MinInterval: 2 * time.Minute}},
+			expected: "min interval 2m0s must not exceed interval 1m0s",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, _ := newTestElasticLM(t, test.opts)
+			err := e.polling.validate(e.interval)
+			if test.expected == "" {
+				assert.NoError(t, err)
+				assert.NoError(t, e.cost.validate())
+			} else {
+				assert.EqualError(t, err, test.expected)
+			}
+		})
+	}
+}
+
+func TestSampleVolatilitiesForPolling(t *testing.T) {
+	e, _ := newTestElasticLM(t, Options{
+		Polling: PollingOptions{MinInterval: 10 * time.Second, VolatilityBps: 100, VolatilityHalfLife: time.Hour},
+	})
+	e.positionMap["1"] = newTestPosition("1", "1", "0")
+
+	e.sampleVolatilities(context.Background())
+	require.Contains(t, e.pollingVolatilities, testSymbol)
+	assert.Equal(t, testPrice, e.pollingVolatilities[testSymbol].Price)
+	assert.Empty(t, e.volatilities, "cost checks are off")
+}
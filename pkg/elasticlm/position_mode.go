@@ -0,0 +1,35 @@
+package elasticlm
+
+import (
+	"context"
+
+	"github.com/adshao/go-binance/v2/futures"
+)
+
+// loadPositionMode looks up whether the account is in hedge mode, where positions have LONG and SHORT sides.
+// Hedges are held on the SHORT side then, so trades on the LONG side, e.g. manual ones, don't net with them.
+func (e *ElasticLM) loadPositionMode(ctx context.Context) error {
+	mode, err := e.bclient.GetPositionMode(ctx)
+	if err != nil {
+		e.logger.Errorw("Fail to get position mode", "error", err)
+		return err
+	}
+
+	e.dualSidePosition = mode.DualSidePosition
+	e.logger.Infow("Get position mode of account", "hedgeMode", e.dualSidePosition)
+	return nil
+}
+
+// getOrderPositionSide returns the position side of an order, and whether it is sent as reduce only. In hedge mode,
+// orders are sent for the SHORT side, where buys can only reduce the shorts, and Binance rejects reduce only orders.
+func (e *ElasticLM) getOrderPositionSide(reduceOnly bool) (futures.PositionSideType, bool) {
+	if e.dualSidePosition {
+		return futures.PositionSideTypeShort, false
+	}
+	return futures.PositionSideTypeBoth, reduceOnly
+}
+
+// isHedgeSide returns whether a position risk's side holds the hedges, which is the SHORT side in hedge mode.
+func (e *ElasticLM) isHedgeSide(risk *futures.PositionRisk) bool {
+	return !e.dualSidePosition || risk.PositionSide == string(futures.PositionSideTypeShort)
+}
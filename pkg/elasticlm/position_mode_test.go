@@ -0,0 +1,53 @@
+package elasticlm
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/hiepnv90/elastic-lm/pkg/models"
+	"github.com/hiepnv90/elastic-lm/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// getTestSideAmount returns the position amount of a side of the symbol on the exchange.
+func getTestSideAmount(sim *simulator.Exchange, positionSide futures.PositionSideType) float64 {
+	for _, pos := range sim.Positions() {
+		if pos.Symbol == testSymbol && pos.PositionSide == positionSide {
+			return math.Round(pos.Amount*1000) / 1000
+		}
+	}
+	return 0
+}
+
+func TestHedgeModeKeepsLongSide(t *testing.T) {
+	ctx := context.Background()
+	e, sim := newTestElasticLM(t, Options{Reconcile: ReconcileOptions{Mode: ReconcileModeCorrect}})
+	require.NoError(t, sim.SetDualSidePosition(true))
+	require.NoError(t, e.loadPositionMode(ctx))
+
+	// A long is opened by hand on the LONG side, which doesn't net with the hedges.
+	_, err := sim.CreateFutureOrder(
+		ctx, testSymbol, "1", "0", "", futures.SideTypeBuy, futures.OrderTypeMarket, futures.TimeInForceTypeGTC,
+		false, futures.PositionSideTypeLong, "manual-long",
+	)
+	require.NoError(t, err)
+
+	e.positionMap["1"] = newTestPosition("1", "1", "0")
+	e.hedgeDeltas(ctx, []hedgeDelta{newTestDelta("1", "0.5")})
+	e.hedgeDeltas(ctx, []hedgeDelta{newTestDelta("1", "-0.2")})
+	assertAmount(t, "0.3", e.positionMap["1"].HedgedAmount0)
+	// The simulator rejects reduce only orders in hedge mode, so the buy is sent for the SHORT side instead.
+	assert.Equal(t, -0.3, getTestSideAmount(sim, futures.PositionSideTypeShort), "buys reduce the SHORT side")
+	assert.Equal(t, 1.0, getTestSideAmount(sim, futures.PositionSideTypeLong))
+
+	// Reconciliation compares the hedged amounts with the SHORT side only.
+	require.NoError(t, e.reconcile(ctx))
+	assert.Equal(t, -0.3, getTestSideAmount(sim, futures.PositionSideTypeShort))
+	assert.Equal(t, 1.0, getTestSideAmount(sim, futures.PositionSideTypeLong))
+	orders, err := models.ListOrders(e.db, "", testSymbol, 0)
+	require.NoError(t, err)
+	assert.Len(t, orders, 2, "no correcting order")
+}
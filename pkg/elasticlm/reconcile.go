@@ -0,0 +1,356 @@
+package elasticlm
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+	"github.com/hiepnv90/elastic-lm/pkg/position"
+)
+
+// ReconcileMode defines what to do when stored hedged amounts drift from the exchange's positions.
+type ReconcileMode string
+
+const (
+	ReconcileModeOff     ReconcileMode = "off"
+	ReconcileModeReport  ReconcileMode = "report"
+	ReconcileModeAdopt   ReconcileMode = "adopt"
+	ReconcileModeCorrect ReconcileMode = "correct"
+)
+
+func (m ReconcileMode) validate() error {
+	switch m {
+	case "", ReconcileModeOff, ReconcileModeReport, ReconcileModeAdopt, ReconcileModeCorrect:
+		return nil
+	default:
+		return fmt.Errorf("invalid reconcile mode: %s", m)
+	}
+}
+
+func (m ReconcileMode) enabled() bool {
+	return m != "" && m != ReconcileModeOff
+}
+
+// ReconcileOptions holds the settings of reconciling stored hedged amounts with the exchange's positions.
+type ReconcileOptions struct {
+	// Mode decides what to do with drifts, reconciliation is off when it is unset.
+	Mode ReconcileMode
+	// Interval is the interval of reconciling again while running, positions are only reconciled on start when it is
+	// unset.
+	Interval time.Duration
+}
+
+func (o ReconcileOptions) validate() error {
+	err := o.Mode.validate()
+	if err != nil {
+		return err
+	}
+	if o.Interval < 0 {
+		return fmt.Errorf("negative reconcile interval: %s", o.Interval)
+	}
+	return nil
+}
+
+// hedgeLeg is a non-stable token of a position which is hedged with a Binance symbol.
+type hedgeLeg struct {
+	PositionID string
+	TokenIndex int
+}
+
+// getHedgeLegs returns the hedged legs of all tracked positions grouped by Binance symbol.
+func (e *ElasticLM) getHedgeLegs() map[string][]hedgeLeg {
+	ids := make([]string, 0, len(e.positionMap))
+	for id := range e.positionMap {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	legs := make(map[string][]hedgeLeg)
+	for _, id := range ids {
+		pos := e.positionMap[id]
+		for tokenIndex := 0; tokenIndex < 2; tokenIndex++ {
+			token := pos.Token(tokenIndex)
+			if token.IsStable() {
+				continue
+			}
+			symbol := e.getBinancePerpetualSymbol(token)
+			legs[symbol] = append(legs[symbol], hedgeLeg{PositionID: id, TokenIndex: tokenIndex})
+		}
+	}
+
+	return legs
+}
+
+// hasPendingOrders returns whether a symbol has orders in flight.
+// Resting orders may wait for the price for a long time, so their fills are applied as they come instead.
+func (e *ElasticLM) hasPendingOrders(symbol string) bool {
+	for _, order := range e.pendingOrders {
+		if order.Symbol == symbol && !order.Resting {
+			return true
+		}
+	}
+	return false
+}
+
+// reconcile compares the stored hedged amounts of every symbol with the account's position on the exchange.
+func (e *ElasticLM) reconcile(ctx context.Context) error {
+	l := e.logger.With("mode", e.reconciliation.Mode)
+
+	risks, err := e.bclient.GetPositionRisk(ctx, "")
+	if err != nil {
+		l.Errorw("Fail to get position risk for reconciliation", "error", err)
+		return err
+	}
+
+	positionAmts := make(map[string]string)
+	for _, risk := range risks {
+		if e.isHedgeSide(risk) {
+			positionAmts[risk.Symbol] = risk.PositionAmt
+		}
+	}
+
+	legsMap := e.getHedgeLegs()
+	symbols := make([]string, 0, len(legsMap))
+	for symbol := range legsMap {
+		symbols = append(symbols, symbol)
+	}
+	for symbol, positionAmt := range positionAmts {
+		if _, ok := legsMap[symbol]; !ok && !isZeroDecimal(positionAmt) {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Strings(symbols)
+
+	for _, symbol := range symbols {
+		if e.hasPendingOrders(symbol) {
+			l.Debugw("Skip reconciling symbol with pending orders", "symbol", symbol)
+			continue
+		}
+
+		legs := legsMap[symbol]
+		decimals := e.getSymbolDecimals(symbol, legs)
+		stored := e.getStoredHedgedAmount(legs, decimals)
+		if unallocated, ok := e.unallocatedAmounts[symbol]; ok {
+			stored = common.BigAdd(stored, unallocated.Amount)
+		}
+
+		positionAmt, ok := positionAmts[symbol]
+		if !ok {
+			positionAmt = "0"
+		}
+		exchangeAmt, err := common.ParseAmount(positionAmt, decimals)
+		if err != nil {
+			l.Errorw("Fail to parse position amount", "symbol", symbol, "positionAmt", positionAmt, "error", err)
+			continue
+		}
+		// A short position has negative position amount, while hedged amounts are positive.
+		exchangeAmt = common.BigNeg(exchangeAmt)
+
+		drift := common.BigSub(exchangeAmt, stored)
+		precision := e.symbolInfoMap[symbol].QuantityPrecision
+		if common.BigIsZero(common.RoundAmount(common.BigAbs(drift), decimals, precision, common.RoundTypeFloor)) {
+			continue
+		}
+
+		l.Warnw(
+			"Stored hedged amount drifts from exchange position",
+			"symbol", symbol,
+			"stored", common.FormatAmount(stored, decimals, precision),
+			"exchange", common.FormatAmount(exchangeAmt, decimals, precision),
+			"drift", common.FormatAmount(drift, decimals, precision),
+		)
+
+		switch e.reconciliation.Mode {
+		case ReconcileModeAdopt:
+			e.adoptExchangeAmount(symbol, legs, exchangeAmt, decimals)
+		case ReconcileModeCorrect:
+			// Without stored hedges, e.g. on a fresh database, the position is adopted instead of being closed, so
+			// positions seen later take it over rather than buying it back and selling it again.
+			if len(legs) == 0 {
+				e.adoptExchangeAmount(symbol, legs, exchangeAmt, decimals)
+				continue
+			}
+			err = e.correctDrift(ctx, symbol, drift, decimals)
+			if err != nil {
+				l.Warnw("Fail to correct drift of exchange position", "symbol", symbol, "error", err)
+			}
+		}
+	}
+
+	err = e.savePositions()
+	if err != nil {
+		l.Warnw("Fail to save positions into database", "error", err)
+	}
+
+	return nil
+}
+
+// getSymbolDecimals returns the decimals used to compare amounts of a symbol,
+// which is the decimals of the first hedged token of the symbol.
+func (e *ElasticLM) getSymbolDecimals(symbol string, legs []hedgeLeg) int {
+	if len(legs) > 0 {
+		return e.positionMap[legs[0].PositionID].Token(legs[0].TokenIndex).Decimals
+	}
+	if unallocated, ok := e.unallocatedAmounts[symbol]; ok {
+		return unallocated.Decimals
+	}
+	return 18
+}
+
+func (e *ElasticLM) getStoredHedgedAmount(legs []hedgeLeg, decimals int) *big.Int {
+	total := big.NewInt(0)
+	for _, leg := range legs {
+		pos := e.positionMap[leg.PositionID]
+		amount := common.ScaleAmount(pos.HedgedAmount(leg.TokenIndex), pos.Token(leg.TokenIndex).Decimals, decimals)
+		total = common.BigAdd(total, amount)
+	}
+	return total
+}
+
+// adoptExchangeAmount overwrites the stored hedged amounts of a symbol so that their sum matches the exchange.
+// The exchange amount is split pro rata by the current hedged amounts, or by the token amounts if nothing is hedged.
+// Without any tracked position, the amount is kept unallocated and is assigned to positions seen later.
+func (e *ElasticLM) adoptExchangeAmount(symbol string, legs []hedgeLeg, exchangeAmt *big.Int, decimals int) {
+	l := e.logger.With("symbol", symbol)
+
+	delete(e.unallocatedAmounts, symbol)
+	if len(legs) == 0 {
+		l.Infow("Keep exchange position as unallocated hedged amount", "amount", common.FormatAmount(exchangeAmt, decimals, 5))
+		e.unallocatedAmounts[symbol] = common.Token{Amount: exchangeAmt, Symbol: symbol, Decimals: decimals}
+		return
+	}
+
+	weights := make([]*big.Int, len(legs))
+	totalWeight := big.NewInt(0)
+	for i, leg := range legs {
+		pos := e.positionMap[leg.PositionID]
+		weights[i] = common.ScaleAmount(pos.HedgedAmount(leg.TokenIndex), pos.Token(leg.TokenIndex).Decimals, decimals)
+		totalWeight = common.BigAdd(totalWeight, weights[i])
+	}
+	if totalWeight.Cmp(common.Big0) <= 0 {
+		totalWeight = big.NewInt(0)
+		for i, leg := range legs {
+			pos := e.positionMap[leg.PositionID]
+			weights[i] = common.ScaleAmount(pos.Token(leg.TokenIndex).Amount, pos.Token(leg.TokenIndex).Decimals, decimals)
+			totalWeight = common.BigAdd(totalWeight, weights[i])
+		}
+	}
+
+	remaining := exchangeAmt
+	for i, leg := range legs {
+		share := remaining
+		if i < len(legs)-1 {
+			if totalWeight.Cmp(common.Big0) > 0 {
+				share = common.BigDiv(common.BigMul(exchangeAmt, weights[i]), totalWeight)
+			} else {
+				share = common.BigDiv(exchangeAmt, big.NewInt(int64(len(legs))))
+			}
+		}
+		remaining = common.BigSub(remaining, share)
+
+		pos := e.positionMap[leg.PositionID]
+		tokenDecimals := pos.Token(leg.TokenIndex).Decimals
+		hedgedAmount := common.ScaleAmount(share, decimals, tokenDecimals)
+		pos.AddHedgedAmount(leg.TokenIndex, common.BigSub(hedgedAmount, pos.HedgedAmount(leg.TokenIndex)))
+		e.positionMap[leg.PositionID] = pos
+
+		l.Infow(
+			"Adopt exchange position as hedged amount",
+			"positionID", leg.PositionID,
+			"tokenIndex", leg.TokenIndex,
+			"hedgedAmount", common.FormatAmount(hedgedAmount, tokenDecimals, 5),
+		)
+	}
+}
+
+// adoptUnallocatedAmount assigns the unallocated hedged amount of a token's symbol to a newly seen position,
+// up to the position's hedge target.
+func (e *ElasticLM) adoptUnallocatedAmount(pos *position.Position, tokenIndex int) {
+	token := pos.Token(tokenIndex)
+	if token.IsStable() {
+		return
+	}
+
+	symbol := e.getBinancePerpetualSymbol(token)
+	unallocated, ok := e.unallocatedAmounts[symbol]
+	if !ok {
+		return
+	}
+
+	amount := common.ScaleAmount(unallocated.Amount, unallocated.Decimals, token.Decimals)
+	if target := pos.HedgeTarget(tokenIndex); amount.Cmp(target) > 0 {
+		amount = target
+	}
+	if amount.Cmp(common.Big0) <= 0 {
+		return
+	}
+
+	e.logger.Infow(
+		"Assign unallocated hedged amount to position",
+		"positionID", pos.ID,
+		"symbol", symbol,
+		"amount", common.FormatAmount(amount, token.Decimals, 5),
+	)
+	pos.AddHedgedAmount(tokenIndex, amount)
+
+	unallocated.Amount = common.BigSub(unallocated.Amount, common.ScaleAmount(amount, token.Decimals, unallocated.Decimals))
+	if unallocated.Amount.Cmp(common.Big0) <= 0 {
+		delete(e.unallocatedAmounts, symbol)
+		return
+	}
+	e.unallocatedAmounts[symbol] = unallocated
+}
+
+// correctDrift sends an order so that the exchange position matches the stored hedged amounts.
+func (e *ElasticLM) correctDrift(ctx context.Context, symbol string, drift *big.Int, decimals int) error {
+	precision := e.symbolInfoMap[symbol].QuantityPrecision
+
+	// The exchange is shorter than stored when drift is positive, so buy back the difference.
+	amount := common.BigAbs(drift)
+	side := futures.SideTypeBuy
+	reduceOnly := true
+	if drift.Cmp(common.Big0) < 0 {
+		side = futures.SideTypeSell
+		reduceOnly = false
+	}
+
+	amount = common.RoundAmount(amount, decimals, precision, common.RoundTypeFloor)
+	if common.BigIsZero(amount) {
+		return nil
+	}
+
+	e.logger.Infow(
+		"Correct drift of exchange position",
+		"symbol", symbol,
+		"side", side,
+		"amount", common.FormatAmount(amount, decimals, precision),
+	)
+
+	// Correction orders don't belong to any position, but are tracked to pause reconciliation until they finish.
+	_, err := e.createOrder(
+		ctx,
+		&pendingOrder{
+			Symbol:   symbol,
+			Decimals: decimals,
+			Side:     side,
+			Quantity: amount,
+			Filled:   big.NewInt(0),
+		},
+		common.FormatAmount(amount, decimals, precision),
+		"0",
+		"",
+		futures.OrderTypeMarket,
+		futures.TimeInForceTypeGTC,
+		reduceOnly,
+	)
+	return err
+}
+
+func isZeroDecimal(s string) bool {
+	return strings.Trim(strings.TrimPrefix(s, "-"), "0.") == ""
+}
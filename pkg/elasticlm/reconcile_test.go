@@ -0,0 +1,130 @@
+package elasticlm
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"strconv"
+	"testing"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/hiepnv90/elastic-lm/pkg/graphql"
+	"github.com/hiepnv90/elastic-lm/pkg/models"
+	"github.com/hiepnv90/elastic-lm/pkg/simulator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openTestShort sells a quantity on the exchange outside of ElasticLM, e.g. by hand.
+func openTestShort(t *testing.T, sim *simulator.Exchange, quantity string) {
+	_, err := sim.CreateFutureOrder(
+		context.Background(), testSymbol, quantity, "0", "", futures.SideTypeSell, futures.OrderTypeMarket,
+		futures.TimeInForceTypeGTC, false, "", "manual-"+quantity,
+	)
+	require.NoError(t, err)
+}
+
+// getTestShort returns the short quantity of the symbol on the exchange.
+func getTestShort(sim *simulator.Exchange) float64 {
+	for _, pos := range sim.Positions() {
+		if pos.Symbol == testSymbol {
+			return -math.Round(pos.Amount*1000) / 1000
+		}
+	}
+	return 0
+}
+
+func TestReconcileAdopt(t *testing.T) {
+	e, sim := newTestElasticLM(t, Options{Reconcile: ReconcileOptions{Mode: ReconcileModeAdopt}})
+	e.positionMap["1"] = newTestPosition("1", "1", "0.3")
+	e.positionMap["2"] = newTestPosition("2", "1", "0.1")
+	openTestShort(t, sim, "0.8")
+
+	require.NoError(t, e.reconcile(context.Background()))
+	assertAmount(t, "0.6", e.positionMap["1"].HedgedAmount0, "exchange amount is split by hedged amounts")
+	assertAmount(t, "0.2", e.positionMap["2"].HedgedAmount0)
+	assert.Equal(t, 0.8, getTestShort(sim), "exchange position is kept")
+}
+
+func TestReconcileAdoptUnallocated(t *testing.T) {
+	e, sim := newTestElasticLM(t, Options{Reconcile: ReconcileOptions{Mode: ReconcileModeAdopt}})
+	openTestShort(t, sim, "0.5")
+
+	require.NoError(t, e.reconcile(context.Background()))
+	require.Contains(t, e.unallocatedAmounts, testSymbol)
+	assertAmount(t, "0.5", e.unallocatedAmounts[testSymbol].Amount)
+
+	pos := newTestPosition("1", "0.3", "0")
+	e.adoptUnallocatedAmount(&pos, 0)
+	assertAmount(t, "0.3", pos.HedgedAmount0, "new position gets up to its token amount")
+	assertAmount(t, "0.2", e.unallocatedAmounts[testSymbol].Amount)
+
+	pos = newTestPosition("2", "1", "0")
+	e.adoptUnallocatedAmount(&pos, 0)
+	assertAmount(t, "0.2", pos.HedgedAmount0)
+	assert.NotContains(t, e.unallocatedAmounts, testSymbol)
+}
+
+func TestReconcileCorrect(t *testing.T) {
+	tests := []struct {
+		name     string
+		hedged   string
+		short    string
+		expected float64
+	}{
+		{name: "sell missing short", hedged: "0.5", short: "0.2", expected: 0.5},
+		{name: "buy back extra short", hedged: "0.2", short: "0.5", expected: 0.2},
+		{name: "ignore drift below precision", hedged: "0.2004", short: "0.2", expected: 0.2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, sim := newTestElasticLM(t, Options{Reconcile: ReconcileOptions{Mode: ReconcileModeCorrect}})
+			e.positionMap["1"] = newTestPosition("1", "1", test.hedged)
+			openTestShort(t, sim, test.short)
+
+			require.NoError(t, e.reconcile(context.Background()))
+			assert.Equal(t, test.expected, getTestShort(sim))
+			assertAmount(t, test.hedged, e.positionMap["1"].HedgedAmount0, "stored hedged amount is kept")
+		})
+	}
+}
+
+func TestReconcileCorrectAdoptsWithoutStoredHedges(t *testing.T) {
+	e, sim := newTestElasticLM(t, Options{Reconcile: ReconcileOptions{Mode: ReconcileModeCorrect}})
+	subgraph := newTestSubgraph(t, e)
+	subgraph.positions = []graphql.Position{newTestSubgraphPosition("1", "650000000000000")}
+	openTestShort(t, sim, "0.5")
+
+	require.NoError(t, e.reconcile(context.Background()))
+	assert.Equal(t, 0.5, getTestShort(sim), "short of a fresh database isn't bought back")
+	require.Contains(t, e.unallocatedAmounts, testSymbol)
+
+	require.NoError(t, e.updatePositions(context.Background(), true))
+	short := getTestShort(sim)
+	assertAmount(t, strconv.FormatFloat(short, 'f', 3, 64), e.positionMap["1"].HedgedAmount0)
+	assert.NotContains(t, e.unallocatedAmounts, testSymbol)
+
+	// Only the part of the position which the short doesn't cover is sold.
+	orders, err := models.ListOrders(e.db, "", testSymbol, 0)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, string(futures.SideTypeSell), orders[0].Side)
+	assert.Equal(t, strconv.FormatFloat(short-0.5, 'f', 3, 64), orders[0].Quantity)
+}
+
+func TestReconcileSkipsSymbolWithPendingOrders(t *testing.T) {
+	e, sim := newTestElasticLM(t, Options{Reconcile: ReconcileOptions{Mode: ReconcileModeCorrect}})
+	e.positionMap["1"] = newTestPosition("1", "1", "0.5")
+	e.pendingOrders["elm-1"] = &pendingOrder{
+		Symbol:      testSymbol,
+		Decimals:    testDecimals,
+		Side:        futures.SideTypeSell,
+		Quantity:    ethAmount("0.1"),
+		Filled:      big.NewInt(0),
+		Allocations: []*orderAllocation{newTestAllocation("1", "0.1")},
+	}
+
+	require.NoError(t, e.reconcile(context.Background()))
+	assert.Equal(t, 0.0, getTestShort(sim))
+}
@@ -0,0 +1,207 @@
+package elasticlm
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+)
+
+// orderBookLimit is the number of levels of the order book which market orders are estimated against.
+const orderBookLimit = 100
+
+// SlippageAction defines what to do with a market order whose expected slippage exceeds the max.
+type SlippageAction string
+
+const (
+	// SlippageActionSplit sends the order in slices which are within the max slippage.
+	SlippageActionSplit SlippageAction = "split"
+	// SlippageActionDelay keeps the order's deltas as residuals, so it is tried again on later updates.
+	SlippageActionDelay SlippageAction = "delay"
+	// SlippageActionRefuse drops the order, so only a later plan of the strategies sends it again.
+	SlippageActionRefuse SlippageAction = "refuse"
+)
+
+func (a SlippageAction) validate() error {
+	switch a {
+	case "", SlippageActionSplit, SlippageActionDelay, SlippageActionRefuse:
+		return nil
+	default:
+		return fmt.Errorf("invalid slippage action: %s", a)
+	}
+}
+
+// SlippageOptions holds the settings of guarding market orders against slippage on the order book.
+type SlippageOptions struct {
+	// MaxBps is the max expected slippage of market orders, they aren't guarded when it is unset.
+	MaxBps float64
+	// Action decides what to do with market orders above MaxBps.
+	Action SlippageAction
+}
+
+func (o SlippageOptions) validate() error {
+	if o.MaxBps < 0 {
+		return fmt.Errorf("negative max slippage: %v bps", o.MaxBps)
+	}
+	return o.Action.validate()
+}
+
+// bookLevel is a level of the order book which a market order takes.
+type bookLevel struct {
+	Price    float64
+	Quantity float64
+}
+
+// estimateSlippageBps returns the slippage in bps against the mark price of the average price which a market order
+// of the side fills at by walking the levels. It is infinite when the levels don't have enough quantity.
+func estimateSlippageBps(levels []bookLevel, side futures.SideType, quantity float64, markPrice float64) float64 {
+	notional := 0.0
+	remaining := quantity
+	for _, level := range levels {
+		if remaining <= 0 {
+			break
+		}
+		filled := math.Min(remaining, level.Quantity)
+		notional += filled * level.Price
+		remaining -= filled
+	}
+	if remaining > 0 || quantity <= 0 || markPrice <= 0 {
+		return math.Inf(1)
+	}
+
+	avgPrice := notional / quantity
+	if side == futures.SideTypeBuy {
+		return (avgPrice - markPrice) / markPrice * 10000
+	}
+	return (markPrice - avgPrice) / markPrice * 10000
+}
+
+// getBookLevels returns the levels of a symbol's order book which a market order of the side takes.
+func (e *ElasticLM) getBookLevels(ctx context.Context, symbol string, side futures.SideType) ([]bookLevel, error) {
+	book, err := e.bclient.GetOrderBook(ctx, symbol, orderBookLimit)
+	if err != nil {
+		e.logger.Errorw("Fail to get order book", "symbol", symbol, "error", err)
+		return nil, err
+	}
+
+	priceLevels := book.Bids
+	if side == futures.SideTypeBuy {
+		priceLevels = book.Asks
+	}
+
+	levels := make([]bookLevel, 0, len(priceLevels))
+	for _, priceLevel := range priceLevels {
+		price, err := strconv.ParseFloat(priceLevel.Price, 64)
+		if err != nil {
+			return nil, err
+		}
+		quantity, err := strconv.ParseFloat(priceLevel.Quantity, 64)
+		if err != nil {
+			return nil, err
+		}
+		levels = append(levels, bookLevel{Price: price, Quantity: quantity})
+	}
+	return levels, nil
+}
+
+// getSlippageQuantity returns the expected slippage in bps of a market order's quantity, and the largest quantity
+// whose expected slippage is within the max.
+func (e *ElasticLM) getSlippageQuantity(
+	ctx context.Context, symbol string, side futures.SideType, quantity *big.Int, decimals int,
+) (*big.Int, float64, error) {
+	markPrice, err := e.getMarkPrice(ctx, symbol)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	levels, err := e.getBookLevels(ctx, symbol, side)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	estimate := func(amount *big.Int) float64 {
+		return estimateSlippageBps(levels, side, common.AmountToFloat(amount, decimals), markPrice)
+	}
+
+	slippageBps := estimate(quantity)
+	if slippageBps <= e.slippage.MaxBps {
+		return quantity, slippageBps, nil
+	}
+
+	// The average price only gets worse with the quantity, so the largest quantity is searched in steps of precision.
+	precision := e.symbolInfoMap[symbol].QuantityPrecision
+	step := common.BigExp(big.NewInt(10), int64(decimals-precision))
+	lo, hi := int64(0), common.BigDiv(quantity, step).Int64()
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if estimate(common.BigMul(big.NewInt(mid), step)) <= e.slippage.MaxBps {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	return common.BigMul(big.NewInt(lo), step), slippageBps, nil
+}
+
+// guardSlippage checks the expected slippage of a market order, or of its slices, against the max.
+// It returns the slice quantity to send the order in, and false if the order isn't sent now.
+func (e *ElasticLM) guardSlippage(
+	ctx context.Context, deltas []hedgeDelta, order *pendingOrder, sliceQuantity *big.Int, reduceOnly bool,
+) (*big.Int, bool, error) {
+	if e.slippage.MaxBps <= 0 {
+		return sliceQuantity, true, nil
+	}
+
+	quantity := order.Quantity
+	if sliceQuantity != nil {
+		quantity = sliceQuantity
+	}
+
+	maxQuantity, slippageBps, err := e.getSlippageQuantity(ctx, order.Symbol, order.Side, quantity, order.Decimals)
+	if err != nil {
+		return nil, false, err
+	}
+	if maxQuantity.Cmp(quantity) >= 0 {
+		return sliceQuantity, true, nil
+	}
+
+	e.logger.Warnw(
+		"Expected slippage of order exceeds max",
+		"symbol", order.Symbol,
+		"side", order.Side,
+		"quantity", common.FormatAmount(quantity, order.Decimals, 5),
+		"slippageBps", slippageBps,
+		"maxSlippageBps", e.slippage.MaxBps,
+		"maxQuantity", common.FormatAmount(maxQuantity, order.Decimals, 5),
+		"action", e.slippage.Action,
+	)
+
+	switch e.slippage.Action {
+	case SlippageActionSplit:
+		if common.BigIsZero(maxQuantity) {
+			break
+		}
+		precision := e.symbolInfoMap[order.Symbol].QuantityPrecision
+		slice := splitQuantity(order.Quantity, countSlices(order.Quantity, maxQuantity), order.Decimals, precision)
+		ok := true
+		if !reduceOnly {
+			ok, err = e.checkMinNotional(ctx, order.Symbol, slice, order.Decimals)
+			if err != nil {
+				return nil, false, err
+			}
+		}
+		if ok {
+			return slice, true, nil
+		}
+	case SlippageActionRefuse:
+		return nil, false, nil
+	}
+
+	e.keepResiduals(order.Symbol, deltas, "expected slippage exceeds max")
+	return nil, false, nil
+}
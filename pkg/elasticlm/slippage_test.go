@@ -0,0 +1,97 @@
+package elasticlm
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateSlippageBps(t *testing.T) {
+	asks := []bookLevel{{Price: 100, Quantity: 1}, {Price: 101, Quantity: 1}}
+	bids := []bookLevel{{Price: 99, Quantity: 1}, {Price: 98, Quantity: 1}}
+
+	assert.InDelta(t, 0, estimateSlippageBps(asks, futures.SideTypeBuy, 1, 100), 1e-9)
+	assert.InDelta(t, 50, estimateSlippageBps(asks, futures.SideTypeBuy, 2, 100), 1e-9)
+	assert.InDelta(t, 150, estimateSlippageBps(bids, futures.SideTypeSell, 2, 100), 1e-9)
+	assert.True(t, math.IsInf(estimateSlippageBps(asks, futures.SideTypeBuy, 3, 100), 1), "book is too thin")
+}
+
+func TestGetSlippageQuantity(t *testing.T) {
+	tests := []struct {
+		side   futures.SideType
+		maxBps float64
+	}{
+		{side: futures.SideTypeSell, maxBps: 0.5},
+		{side: futures.SideTypeSell, maxBps: 2},
+		{side: futures.SideTypeBuy, maxBps: 0.15},
+		{side: futures.SideTypeBuy, maxBps: 0.05},
+	}
+
+	for _, test := range tests {
+		e, sim := newTestElasticLM(t, Options{Slippage: SlippageOptions{MaxBps: test.maxBps}})
+		sim.SetDepth(0.1)
+		levels, err := e.getBookLevels(context.Background(), testSymbol, test.side)
+		require.NoError(t, err)
+
+		quantity := ethAmount("5")
+		maxQuantity, slippageBps, err := e.getSlippageQuantity(
+			context.Background(), testSymbol, test.side, quantity, testDecimals,
+		)
+		require.NoError(t, err)
+		assert.InDelta(t, estimateSlippageBps(levels, test.side, 5, testPrice), slippageBps, 1e-9)
+
+		// The search finds the same quantity as trying every step of the precision.
+		expected := big.NewInt(0)
+		for step := ethAmount("0.001"); expected.Cmp(quantity) < 0; {
+			next := common.BigAdd(expected, step)
+			if estimateSlippageBps(levels, test.side, common.AmountToFloat(next, testDecimals), testPrice) > test.maxBps {
+				break
+			}
+			expected = next
+		}
+		assert.Equal(t, expected.String(), maxQuantity.String(), "side=%s maxBps=%v", test.side, test.maxBps)
+	}
+}
+
+func TestGuardSlippage(t *testing.T) {
+	tests := []struct {
+		action    SlippageAction
+		parent    bool
+		residuals int
+	}{
+		{action: SlippageActionSplit, parent: true},
+		{action: SlippageActionDelay, residuals: 1},
+		{action: SlippageActionRefuse},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.action), func(t *testing.T) {
+			e, sim := newTestElasticLM(t, Options{Slippage: SlippageOptions{MaxBps: 0.5, Action: test.action}})
+			sim.SetDepth(0.1)
+			e.positionMap["1"] = newTestPosition("1", "1", "0")
+
+			e.hedgeDeltas(context.Background(), []hedgeDelta{newTestDelta("1", "1")})
+			assert.Equal(t, 0.0, getTestShort(sim))
+			assert.Len(t, e.residuals, test.residuals)
+			if !test.parent {
+				assert.Empty(t, e.parentOrders)
+				return
+			}
+
+			require.Len(t, e.parentOrders, 1)
+			for _, parent := range e.parentOrders {
+				maxQuantity, _, err := e.getSlippageQuantity(
+					context.Background(), testSymbol, futures.SideTypeSell, parent.SliceQuantity, testDecimals,
+				)
+				require.NoError(t, err)
+				assert.Equal(t, parent.SliceQuantity.String(), maxQuantity.String(), "slices are within max slippage")
+			}
+		})
+	}
+}
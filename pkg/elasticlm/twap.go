@@ -0,0 +1,468 @@
+package elasticlm
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	bcommon "github.com/adshao/go-binance/v2/common"
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+	"github.com/hiepnv90/elastic-lm/pkg/models"
+	"gorm.io/gorm"
+)
+
+// parentOrder is a hedge order which is too large for one market order, so it is sent in child orders over time.
+// Sent is the quantity which is already sent in child orders, the allocations track their sent shares likewise.
+type parentOrder struct {
+	ID            uint64
+	Symbol        string
+	Decimals      int
+	Side          futures.SideType
+	ReduceOnly    bool
+	Quantity      *big.Int
+	SliceQuantity *big.Int
+	Sent          *big.Int
+	Interval      time.Duration
+	NextTime      time.Time
+	Allocations   []*parentAllocation
+}
+
+// parentAllocation is the share of a parent order which belongs to a position's token.
+// Amount and Sent are signed (positive increases the hedged amount) and in the parent order's decimals.
+type parentAllocation struct {
+	PositionID string
+	TokenIndex int
+	Amount     *big.Int
+	Sent       *big.Int
+}
+
+func (o *parentOrder) signedAmount(amount *big.Int) *big.Int {
+	if o.Side == futures.SideTypeBuy {
+		return common.BigNeg(amount)
+	}
+	return amount
+}
+
+func (o *parentOrder) remaining() *big.Int {
+	return common.BigSub(o.Quantity, o.Sent)
+}
+
+// remainders returns the signed amounts of the parent order's allocations which are not sent yet.
+func (o *parentOrder) remainders() []*orderAllocation {
+	allocations := make([]*orderAllocation, 0, len(o.Allocations))
+	for _, allocation := range o.Allocations {
+		allocations = append(allocations, &orderAllocation{
+			PositionID: allocation.PositionID,
+			TokenIndex: allocation.TokenIndex,
+			Amount:     common.BigSub(allocation.Amount, allocation.Sent),
+			Applied:    big.NewInt(0),
+		})
+	}
+	return allocations
+}
+
+// TWAPOptions holds the settings of sliced execution. Orders above ThresholdNotional are sent in Slices child orders
+// over Duration, orders above the symbol's max quantity of market orders are always sliced.
+type TWAPOptions struct {
+	ThresholdNotional float64
+	Duration          time.Duration
+	Slices            int
+}
+
+func (o TWAPOptions) validate() error {
+	if o.ThresholdNotional < 0 {
+		return fmt.Errorf("negative TWAP threshold: %v", o.ThresholdNotional)
+	}
+	if o.Duration < 0 {
+		return fmt.Errorf("negative TWAP duration: %s", o.Duration)
+	}
+	if o.Slices < 0 {
+		return fmt.Errorf("negative TWAP slices: %d", o.Slices)
+	}
+	return nil
+}
+
+// getMaxMarketQuantity returns the max quantity of a market order by the symbol's MARKET_LOT_SIZE filter,
+// or nil if the symbol has no such limit.
+func (e *ElasticLM) getMaxMarketQuantity(symbol string, decimals int) (*big.Int, error) {
+	symbolInfo := e.symbolInfoMap[symbol]
+	filter := symbolInfo.MarketLotSizeFilter()
+	if filter == nil || filter.MaxQuantity == "" {
+		return nil, nil
+	}
+
+	maxQuantity, err := common.ParseAmount(filter.MaxQuantity, decimals)
+	if err != nil {
+		e.logger.Errorw("Fail to parse max quantity", "symbol", symbol, "maxQuantity", filter.MaxQuantity, "error", err)
+		return nil, err
+	}
+	if common.BigIsZero(maxQuantity) {
+		return nil, nil
+	}
+	return common.RoundAmount(maxQuantity, decimals, symbolInfo.QuantityPrecision, common.RoundTypeFloor), nil
+}
+
+// getSliceQuantity returns the quantity of each child order when an order is too large to be sent at once,
+// or nil if the order can be sent as one market order.
+// Orders are sliced when their notional exceeds the TWAP threshold or their quantity exceeds the symbol's max
+// quantity of market orders. Slices which aren't reduce only are kept above the symbol's min notional.
+func (e *ElasticLM) getSliceQuantity(
+	ctx context.Context, symbol string, quantity *big.Int, decimals int, reduceOnly bool,
+) (*big.Int, error) {
+	precision := e.symbolInfoMap[symbol].QuantityPrecision
+
+	maxQuantity, err := e.getMaxMarketQuantity(symbol, decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	isLarge := false
+	if e.twap.ThresholdNotional > 0 {
+		markPrice, err := e.getMarkPrice(ctx, symbol)
+		if err != nil {
+			return nil, err
+		}
+		isLarge = common.AmountToFloat(quantity, decimals)*markPrice > e.twap.ThresholdNotional
+	}
+	if !isLarge && (maxQuantity == nil || quantity.Cmp(maxQuantity) <= 0) {
+		return nil, nil
+	}
+
+	slice := quantity
+	if isLarge {
+		for slices := int64(e.twap.Slices); slices > 1; slices-- {
+			slice = splitQuantity(quantity, slices, decimals, precision)
+			if reduceOnly {
+				break
+			}
+			ok, err := e.checkMinNotional(ctx, symbol, slice, decimals)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				break
+			}
+			slice = quantity
+		}
+	}
+
+	if maxQuantity != nil && slice.Cmp(maxQuantity) > 0 {
+		slice = splitQuantity(quantity, countSlices(quantity, maxQuantity), decimals, precision)
+	}
+	if slice.Cmp(quantity) >= 0 {
+		return nil, nil
+	}
+	return slice, nil
+}
+
+// splitQuantity returns the quantity of each of even slices of a quantity, rounded up to the precision,
+// so the last slice is the smallest one.
+func splitQuantity(quantity *big.Int, slices int64, decimals int, precision int) *big.Int {
+	slice := common.BigDiv(common.BigAdd(quantity, big.NewInt(slices-1)), big.NewInt(slices))
+	return common.RoundAmount(slice, decimals, precision, common.RoundTypeCeiling)
+}
+
+// countSlices returns the number of slices of at most the slice quantity which a quantity is sent in.
+func countSlices(quantity *big.Int, sliceQuantity *big.Int) int64 {
+	return common.BigDiv(common.BigAdd(quantity, common.BigSub(sliceQuantity, common.Big1)), sliceQuantity).Int64()
+}
+
+// createParentOrder records an order which is sent in child orders of the slice quantity.
+// The child orders are spread evenly over the TWAP duration, the first one is sent on the same update.
+func (e *ElasticLM) createParentOrder(order *pendingOrder, sliceQuantity *big.Int, reduceOnly bool) error {
+	l := e.logger.With("symbol", order.Symbol)
+	precision := e.symbolInfoMap[order.Symbol].QuantityPrecision
+
+	children := countSlices(order.Quantity, sliceQuantity)
+	parent := &parentOrder{
+		Symbol:        order.Symbol,
+		Decimals:      order.Decimals,
+		Side:          order.Side,
+		ReduceOnly:    reduceOnly,
+		Quantity:      order.Quantity,
+		SliceQuantity: sliceQuantity,
+		Sent:          big.NewInt(0),
+		Interval:      e.twap.Duration / time.Duration(children),
+		NextTime:      time.Now(),
+	}
+	for _, allocation := range order.Allocations {
+		parent.Allocations = append(parent.Allocations, &parentAllocation{
+			PositionID: allocation.PositionID,
+			TokenIndex: allocation.TokenIndex,
+			Amount:     allocation.Amount,
+			Sent:       big.NewInt(0),
+		})
+	}
+
+	row := models.ParentOrder{
+		Symbol:        parent.Symbol,
+		Side:          string(parent.Side),
+		ReduceOnly:    reduceOnly,
+		Quantity:      common.FormatAmount(parent.Quantity, parent.Decimals, precision),
+		SliceQuantity: common.FormatAmount(parent.SliceQuantity, parent.Decimals, precision),
+		Decimals:      parent.Decimals,
+		Interval:      parent.Interval,
+		Status:        models.ParentOrderStatusActive,
+	}
+	err := e.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&row).Error
+		if err != nil {
+			return err
+		}
+
+		for _, allocation := range parent.Allocations {
+			err = tx.Create(&models.ParentOrderAllocation{
+				ParentOrderID: row.ID,
+				PositionID:    allocation.PositionID,
+				TokenIndex:    allocation.TokenIndex,
+				Amount:        allocation.Amount.String(),
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		l.Errorw("Fail to record parent order", "error", err)
+		return err
+	}
+
+	parent.ID = row.ID
+	e.parentOrders[parent.ID] = parent
+	l.Infow(
+		"Slice hedge order of symbol",
+		"parentOrderID", parent.ID,
+		"quantity", common.FormatAmount(parent.Quantity, parent.Decimals, 5),
+		"sliceQuantity", common.FormatAmount(parent.SliceQuantity, parent.Decimals, 5),
+		"children", children,
+		"interval", parent.Interval,
+	)
+	return nil
+}
+
+// executeParentOrders sends the next child orders of parent orders which are due.
+func (e *ElasticLM) executeParentOrders(ctx context.Context) {
+	ids := make([]uint64, 0, len(e.parentOrders))
+	for id := range e.parentOrders {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	now := time.Now()
+	for _, id := range ids {
+		parent := e.parentOrders[id]
+		if now.Before(parent.NextTime) {
+			continue
+		}
+		e.sendChildOrder(ctx, parent)
+	}
+}
+
+// sendChildOrder sends the next slice of a parent order as a market order.
+// Each allocation gets a share of the child order in proportion to its remaining amount.
+// A rejected child order stops the parent order, so the strategies decide again on the amounts left unsent,
+// while a child order whose expected slippage exceeds the max is delayed to the next slice.
+func (e *ElasticLM) sendChildOrder(ctx context.Context, parent *parentOrder) {
+	l := e.logger.With("symbol", parent.Symbol, "parentOrderID", parent.ID)
+
+	remaining := parent.remaining()
+	if remaining.Cmp(common.Big0) <= 0 {
+		e.finishParentOrder(parent, models.ParentOrderStatusDone)
+		return
+	}
+
+	quantity := parent.SliceQuantity
+	if remaining.Cmp(quantity) < 0 {
+		quantity = remaining
+	}
+
+	parent.NextTime = time.Now().Add(parent.Interval)
+	if e.slippage.MaxBps > 0 {
+		maxQuantity, slippageBps, err := e.getSlippageQuantity(ctx, parent.Symbol, parent.Side, quantity, parent.Decimals)
+		if err != nil || maxQuantity.Cmp(quantity) < 0 {
+			l.Warnw("Delay child order as expected slippage exceeds max", "slippageBps", slippageBps, "error", err)
+			return
+		}
+	}
+
+	order := &pendingOrder{
+		Symbol:        parent.Symbol,
+		ParentOrderID: parent.ID,
+		Decimals:      parent.Decimals,
+		Side:          parent.Side,
+		Quantity:      quantity,
+		Filled:        big.NewInt(0),
+	}
+	rest := parent.signedAmount(quantity)
+	for i, allocation := range parent.Allocations {
+		share := rest
+		if i < len(parent.Allocations)-1 {
+			share = common.BigDiv(common.BigMul(common.BigSub(allocation.Amount, allocation.Sent), quantity), remaining)
+		}
+		rest = common.BigSub(rest, share)
+
+		order.Allocations = append(order.Allocations, &orderAllocation{
+			PositionID: allocation.PositionID,
+			TokenIndex: allocation.TokenIndex,
+			Amount:     share,
+			Applied:    big.NewInt(0),
+		})
+	}
+
+	precision := e.symbolInfoMap[parent.Symbol].QuantityPrecision
+	l.Infow(
+		"Send child order",
+		"quantity", common.FormatAmount(quantity, parent.Decimals, 5),
+		"remaining", common.FormatAmount(remaining, parent.Decimals, 5),
+	)
+
+	_, err := e.createOrder(
+		ctx,
+		order,
+		common.FormatAmount(quantity, parent.Decimals, precision),
+		"0",
+		"",
+		futures.OrderTypeMarket,
+		futures.TimeInForceTypeGTC,
+		parent.ReduceOnly,
+	)
+	if err != nil && order.ClientOrderID == "" {
+		// The child order isn't recorded, so it is retried on the next slice.
+		return
+	}
+	if err != nil && bcommon.IsAPIError(err) {
+		l.Warnw("Fail to send child order, stop parent order", "error", err)
+		if isMinNotionalError(err) {
+			e.keepResiduals(parent.Symbol, e.allocationDeltas(parent.remainders(), parent.Decimals), err.Error())
+		}
+		e.finishParentOrder(parent, models.ParentOrderStatusCanceled)
+		return
+	}
+
+	// Child orders with unknown results stay pending until they are looked up, so they count as sent.
+	// Child orders clipped by the exposure limits only count their clipped quantity.
+	parent.Sent = common.BigAdd(parent.Sent, order.Quantity)
+	for i, allocation := range parent.Allocations {
+		allocation.Sent = common.BigAdd(allocation.Sent, order.Allocations[i].Amount)
+	}
+	if parent.remaining().Cmp(common.Big0) <= 0 {
+		e.finishParentOrder(parent, models.ParentOrderStatusDone)
+	}
+}
+
+func (e *ElasticLM) finishParentOrder(parent *parentOrder, status string) {
+	delete(e.parentOrders, parent.ID)
+
+	err := e.db.Model(&models.ParentOrder{}).Where("id = ?", parent.ID).Update("status", status).Error
+	if err != nil {
+		e.logger.Warnw("Fail to update parent order in journal", "parentOrderID", parent.ID, "error", err)
+	}
+
+	e.logger.Infow(
+		"Finish parent order",
+		"symbol", parent.Symbol,
+		"parentOrderID", parent.ID,
+		"status", status,
+		"sent", common.FormatAmount(parent.Sent, parent.Decimals, 5),
+		"quantity", common.FormatAmount(parent.Quantity, parent.Decimals, 5),
+	)
+}
+
+// loadParentOrders restores the active parent orders of the journal.
+// Their progress is derived from the child orders which were recorded, so no slice is sent twice.
+func (e *ElasticLM) loadParentOrders() error {
+	l := e.logger
+
+	rows, err := models.ListActiveParentOrders(e.db)
+	if err != nil {
+		l.Errorw("Fail to get parent orders from journal", "error", err)
+		return err
+	}
+
+	for _, row := range rows {
+		parent, err := e.loadParentOrder(row)
+		if err != nil {
+			l.Errorw("Fail to restore parent order", "parentOrderID", row.ID, "error", err)
+			return err
+		}
+
+		l.Infow(
+			"Restore parent order from journal",
+			"symbol", parent.Symbol,
+			"parentOrderID", parent.ID,
+			"sent", common.FormatAmount(parent.Sent, parent.Decimals, 5),
+			"quantity", common.FormatAmount(parent.Quantity, parent.Decimals, 5),
+		)
+		e.parentOrders[parent.ID] = parent
+	}
+
+	return nil
+}
+
+func (e *ElasticLM) loadParentOrder(row models.ParentOrder) (*parentOrder, error) {
+	quantity, err := common.ParseAmount(row.Quantity, row.Decimals)
+	if err != nil {
+		return nil, err
+	}
+	sliceQuantity, err := common.ParseAmount(row.SliceQuantity, row.Decimals)
+	if err != nil {
+		return nil, err
+	}
+
+	parent := &parentOrder{
+		ID:            row.ID,
+		Symbol:        row.Symbol,
+		Decimals:      row.Decimals,
+		Side:          futures.SideType(row.Side),
+		ReduceOnly:    row.ReduceOnly,
+		Quantity:      quantity,
+		SliceQuantity: sliceQuantity,
+		Sent:          big.NewInt(0),
+		Interval:      row.Interval,
+		NextTime:      time.Now(),
+	}
+
+	allocations, err := models.ListParentOrderAllocations(e.db, row.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, allocation := range allocations {
+		parent.Allocations = append(parent.Allocations, &parentAllocation{
+			PositionID: allocation.PositionID,
+			TokenIndex: allocation.TokenIndex,
+			Amount:     common.NewBigIntFromString(allocation.Amount, 10),
+			Sent:       big.NewInt(0),
+		})
+	}
+
+	children, err := models.ListChildOrders(e.db, row.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range children {
+		childQuantity, err := common.ParseAmount(child.Quantity, child.Decimals)
+		if err != nil {
+			return nil, err
+		}
+		parent.Sent = common.BigAdd(parent.Sent, childQuantity)
+
+		childAllocations, err := models.ListOrderAllocations(e.db, child.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, childAllocation := range childAllocations {
+			for _, allocation := range parent.Allocations {
+				if allocation.PositionID == childAllocation.PositionID && allocation.TokenIndex == childAllocation.TokenIndex {
+					allocation.Sent = common.BigAdd(allocation.Sent, common.NewBigIntFromString(childAllocation.Amount, 10))
+					break
+				}
+			}
+		}
+	}
+
+	return parent, nil
+}
@@ -0,0 +1,127 @@
+package elasticlm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hiepnv90/elastic-lm/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testTWAPOptions = Options{TWAP: TWAPOptions{ThresholdNotional: 500, Duration: time.Hour, Slices: 4}}
+
+func TestGetSliceQuantity(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       Options
+		quantity   string
+		reduceOnly bool
+		expected   string
+	}{
+		{name: "small order", opts: testTWAPOptions, quantity: "0.4"},
+		{name: "order above threshold", opts: testTWAPOptions, quantity: "1.2", expected: "0.3"},
+		{name: "order above max market quantity", quantity: "25", expected: "8.334"},
+		{
+			name:     "slices above min notional",
+			opts:     Options{TWAP: TWAPOptions{ThresholdNotional: 10, Duration: time.Hour, Slices: 4}},
+			quantity: "0.012",
+			expected: "0.006",
+		},
+		{
+			name:       "reduce only slices below min notional",
+			opts:       Options{TWAP: TWAPOptions{ThresholdNotional: 10, Duration: time.Hour, Slices: 4}},
+			quantity:   "0.012",
+			reduceOnly: true,
+			expected:   "0.003",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e, _ := newTestElasticLM(t, test.opts)
+			slice, err := e.getSliceQuantity(
+				context.Background(), testSymbol, ethAmount(test.quantity), testDecimals, test.reduceOnly,
+			)
+			require.NoError(t, err)
+			if test.expected == "" {
+				assert.Nil(t, slice)
+				return
+			}
+			assertAmount(t, test.expected, slice)
+		})
+	}
+}
+
+func TestExecuteParentOrders(t *testing.T) {
+	e, sim := newTestElasticLM(t, testTWAPOptions)
+	e.positionMap["1"] = newTestPosition("1", "1", "0")
+	e.positionMap["2"] = newTestPosition("2", "1", "0")
+
+	e.hedgeDeltas(context.Background(), []hedgeDelta{newTestDelta("1", "1"), newTestDelta("2", "0.2")})
+	require.Len(t, e.parentOrders, 1)
+	assert.Equal(t, 0.0, getTestShort(sim), "child orders are sent by executeParentOrders")
+
+	rows, err := models.ListActiveParentOrders(e.db)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	parent := e.parentOrders[rows[0].ID]
+	assert.Equal(t, 15*time.Minute, parent.Interval)
+
+	for i := 1; i <= 4; i++ {
+		e.executeParentOrders(context.Background())
+		assert.InDelta(t, 0.3*float64(i), getTestShort(sim), 1e-9, "child order %d", i)
+		e.executeParentOrders(context.Background())
+		assert.InDelta(t, 0.3*float64(i), getTestShort(sim), 1e-9, "next child order isn't due")
+		parent.NextTime = time.Now()
+	}
+
+	assert.Empty(t, e.parentOrders)
+	assertAmount(t, "1", e.positionMap["1"].HedgedAmount0)
+	assertAmount(t, "0.2", e.positionMap["2"].HedgedAmount0)
+	rows, err = models.ListActiveParentOrders(e.db)
+	require.NoError(t, err)
+	assert.Empty(t, rows)
+}
+
+func TestLoadParentOrdersRestoresProgress(t *testing.T) {
+	e, sim := newTestElasticLM(t, testTWAPOptions)
+	e.positionMap["1"] = newTestPosition("1", "1", "0")
+	e.positionMap["2"] = newTestPosition("2", "1", "0")
+	e.hedgeDeltas(context.Background(), []hedgeDelta{newTestDelta("1", "1"), newTestDelta("2", "0.2")})
+	for _, parent := range e.parentOrders {
+		e.sendChildOrder(context.Background(), parent)
+		e.sendChildOrder(context.Background(), parent)
+	}
+	require.NoError(t, e.savePositions())
+
+	// A child order which the exchange rejected isn't part of the progress.
+	var rows []models.ParentOrder
+	require.NoError(t, e.db.Find(&rows).Error)
+	require.Len(t, rows, 1)
+	require.NoError(t, e.db.Create(&models.Order{
+		ParentOrderID: rows[0].ID,
+		Symbol:        testSymbol,
+		ClientOrderID: "rejected",
+		Quantity:      "0.3",
+		Decimals:      testDecimals,
+		Status:        models.OrderStatusFailed,
+	}).Error)
+
+	restarted := restartTestElasticLM(t, e, testTWAPOptions)
+	require.NoError(t, restarted.loadParentOrders())
+	require.Contains(t, restarted.parentOrders, rows[0].ID)
+	parent := restarted.parentOrders[rows[0].ID]
+	assertAmount(t, "0.6", parent.Sent)
+	assertAmount(t, "0.3", parent.SliceQuantity)
+	assertAmount(t, "0.5", parent.Allocations[0].Sent)
+	assertAmount(t, "0.1", parent.Allocations[1].Sent)
+
+	restarted.sendChildOrder(context.Background(), parent)
+	restarted.sendChildOrder(context.Background(), parent)
+	assert.Equal(t, 1.2, getTestShort(sim))
+	assert.Empty(t, restarted.parentOrders)
+	assertAmount(t, "1", restarted.positionMap["1"].HedgedAmount0)
+	assertAmount(t, "0.2", restarted.positionMap["2"].HedgedAmount0)
+}
@@ -0,0 +1,335 @@
+package elasticlm
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+	"github.com/hiepnv90/elastic-lm/pkg/models"
+	"gorm.io/gorm"
+)
+
+const (
+	keepaliveInterval = 30 * time.Minute
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+// pendingOrder is a hedge order which is sent but not yet in a final state.
+// Filled is the executed quantity which is already split into the allocations' hedged amounts.
+// Orders hedging several positions at once have one allocation per position's token.
+// Resting orders wait on the exchange until the price reaches them, e.g. the stop orders of ladders.
+// ParentOrderID is set for the child orders of a sliced parent order.
+// PostOnly orders are the working orders of maker orders, which track their unfilled amounts.
+// StopPrice is the trigger price of stop orders, which tells the rungs of a ladder apart.
+type pendingOrder struct {
+	ClientOrderID string
+	ParentOrderID uint64
+	Symbol        string
+	Decimals      int
+	Side          futures.SideType
+	Quantity      *big.Int
+	Filled        *big.Int
+	Resting       bool
+	PostOnly      bool
+	StopPrice     string
+	Allocations   []*orderAllocation
+}
+
+// orderAllocation is the share of a pending order which belongs to a position's token.
+// Amount is signed (positive increases the hedged amount) and in the order's decimals,
+// the amounts of all allocations add up to the order's signed quantity.
+type orderAllocation struct {
+	ID         uint64
+	PositionID string
+	TokenIndex int
+	Amount     *big.Int
+	Applied    *big.Int
+}
+
+func (o *pendingOrder) signedAmount(amount *big.Int) *big.Int {
+	if o.Side == futures.SideTypeBuy {
+		return common.BigNeg(amount)
+	}
+	return amount
+}
+
+// appliedAmount returns the part of an allocation's amount which is filled when the order executed filled quantity.
+func (o *pendingOrder) appliedAmount(allocation *orderAllocation, filled *big.Int) *big.Int {
+	if o.Quantity.Cmp(common.Big0) == 0 {
+		return big.NewInt(0)
+	}
+	return common.BigDiv(common.BigMul(allocation.Amount, filled), o.Quantity)
+}
+
+// getPendingAmount returns the signed amount of a position's token which is still waiting to be filled.
+// Resting orders are not expected to be filled at the current price, so they are not counted,
+// while the unsent amounts of parent orders and the unfilled amounts of maker orders are.
+func (e *ElasticLM) getPendingAmount(positionID string, tokenIndex int) *big.Int {
+	decimals := e.positionMap[positionID].Token(tokenIndex).Decimals
+
+	amount := big.NewInt(0)
+	for _, order := range e.pendingOrders {
+		if order.Resting || order.PostOnly {
+			continue
+		}
+		for _, allocation := range order.Allocations {
+			if allocation.PositionID != positionID || allocation.TokenIndex != tokenIndex {
+				continue
+			}
+			remaining := common.BigSub(allocation.Amount, allocation.Applied)
+			amount = common.BigAdd(amount, common.ScaleAmount(remaining, order.Decimals, decimals))
+		}
+	}
+	for _, parent := range e.parentOrders {
+		for _, allocation := range parent.Allocations {
+			if allocation.PositionID != positionID || allocation.TokenIndex != tokenIndex {
+				continue
+			}
+			remaining := common.BigSub(allocation.Amount, allocation.Sent)
+			amount = common.BigAdd(amount, common.ScaleAmount(remaining, parent.Decimals, decimals))
+		}
+	}
+	for _, m := range e.makerOrders {
+		for _, allocation := range m.remainders() {
+			if allocation.PositionID != positionID || allocation.TokenIndex != tokenIndex {
+				continue
+			}
+			amount = common.BigAdd(amount, common.ScaleAmount(allocation.Amount, m.Decimals, decimals))
+		}
+	}
+
+	return amount
+}
+
+// listenUserData keeps the user data stream connected until the context is cancelled,
+// reconnecting with exponential backoff.
+func (e *ElasticLM) listenUserData(ctx context.Context) {
+	l := e.logger
+
+	delay := minReconnectDelay
+	for {
+		connected, err := e.serveUserData(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			delay = minReconnectDelay
+		}
+
+		l.Warnw("User data stream disconnected", "reconnectDelay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+func (e *ElasticLM) serveUserData(ctx context.Context) (bool, error) {
+	listenKey, doneC, stopC, err := e.bclient.ListenUserData(ctx, e.queueUserDataEvent)
+	if err != nil {
+		return false, err
+	}
+
+	e.logger.Infow("User data stream connected")
+	// Fills may have been missed while the stream was disconnected.
+	e.requestResync()
+
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+
+	stop := func() {
+		close(stopC)
+		<-doneC
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Nobody consumes events anymore, so don't wait for the connection to finish.
+			close(stopC)
+			return true, nil
+		case <-doneC:
+			return true, errors.New("user data stream closed")
+		case <-e.reconnectC:
+			stop()
+			return true, errors.New("listen key expired")
+		case <-ticker.C:
+			err = e.bclient.KeepaliveUserData(ctx, listenKey)
+			if err != nil {
+				stop()
+				return true, err
+			}
+		}
+	}
+}
+
+// queueUserDataEvent passes an event of the user data stream to Run without blocking the stream's reader.
+// When Run is busy and the queue is full, the event is dropped and the pending orders are looked up instead.
+func (e *ElasticLM) queueUserDataEvent(event *futures.WsUserDataEvent) {
+	select {
+	case e.userDataC <- event:
+		return
+	default:
+	}
+
+	if event.Event == futures.UserDataEventTypeListenKeyExpired {
+		e.requestReconnect()
+		return
+	}
+	e.logger.Warnw("User data queue is full, drop event and resync pending orders", "event", event.Event)
+	e.requestResync()
+}
+
+func (e *ElasticLM) requestReconnect() {
+	select {
+	case e.reconnectC <- struct{}{}:
+	default:
+	}
+}
+
+func (e *ElasticLM) handleUserDataEvent(event *futures.WsUserDataEvent) {
+	switch event.Event {
+	case futures.UserDataEventTypeListenKeyExpired:
+		e.requestReconnect()
+	case futures.UserDataEventTypeOrderTradeUpdate:
+		e.handleOrderTradeUpdate(event.OrderTradeUpdate)
+	}
+}
+
+func (e *ElasticLM) handleOrderTradeUpdate(update futures.WsOrderTradeUpdate) {
+	l := e.logger.With("clientOrderID", update.ClientOrderID, "symbol", update.Symbol)
+
+	e.updateJournalOrder(
+		update.ClientOrderID, update.Status, update.AccumulatedFilledQty, update.AveragePrice, update.TradeTime,
+	)
+
+	order, ok := e.pendingOrders[update.ClientOrderID]
+	if !ok {
+		l.Debugw("Ignore update of unknown order", "status", update.Status)
+		return
+	}
+
+	l.Infow(
+		"Receive order update",
+		"executionType", update.ExecutionType,
+		"status", update.Status,
+		"lastFilledQty", update.LastFilledQty,
+		"accumulatedFilledQty", update.AccumulatedFilledQty,
+	)
+
+	filled, err := common.ParseAmount(update.AccumulatedFilledQty, order.Decimals)
+	if err != nil {
+		l.Errorw("Fail to parse filled quantity", "quantity", update.AccumulatedFilledQty, "error", err)
+		return
+	}
+
+	e.applyOrderFill(update.ClientOrderID, order, filled, update.Status)
+}
+
+// resyncPendingOrders looks up every pending order on the exchange to apply fills missed by the user data stream.
+func (e *ElasticLM) resyncPendingOrders(ctx context.Context) {
+	for clientOrderID, order := range e.pendingOrders {
+		l := e.logger.With("clientOrderID", clientOrderID, "symbol", order.Symbol)
+
+		resp, err := e.bclient.GetFutureOrder(ctx, order.Symbol, clientOrderID)
+		if isOrderNotFound(err) {
+			l.Warnw("Pending order never reached the exchange")
+			e.failJournalOrder(clientOrderID, err.Error())
+			delete(e.pendingOrders, clientOrderID)
+			continue
+		}
+		if err != nil {
+			l.Warnw("Fail to get pending order", "error", err)
+			continue
+		}
+		e.updateJournalOrder(clientOrderID, resp.Status, resp.ExecutedQuantity, resp.AvgPrice, resp.UpdateTime)
+
+		filled, err := common.ParseAmount(resp.ExecutedQuantity, order.Decimals)
+		if err != nil {
+			l.Errorw("Fail to parse filled quantity", "quantity", resp.ExecutedQuantity, "error", err)
+			continue
+		}
+
+		e.applyOrderFill(clientOrderID, order, filled, resp.Status)
+	}
+}
+
+// applyOrderFill splits the newly executed quantity of an order into the hedged amounts of its allocations,
+// and forgets the order once it is in a final state.
+// The hedged amounts and the order's applied quantities are saved together, so a fill is never applied twice.
+func (e *ElasticLM) applyOrderFill(
+	clientOrderID string, order *pendingOrder, filled *big.Int, status futures.OrderStatusType,
+) {
+	l := e.logger.With("clientOrderID", clientOrderID, "symbol", order.Symbol)
+
+	delta := common.BigSub(filled, order.Filled)
+	if delta.Cmp(common.Big0) > 0 {
+		order.Filled = filled
+		for _, allocation := range order.Allocations {
+			applied := order.appliedAmount(allocation, filled)
+			pos, ok := e.positionMap[allocation.PositionID]
+			if ok {
+				decimals := pos.Token(allocation.TokenIndex).Decimals
+				amount := common.BigSub(
+					common.ScaleAmount(applied, order.Decimals, decimals),
+					common.ScaleAmount(allocation.Applied, order.Decimals, decimals),
+				)
+				pos.AddHedgedAmount(allocation.TokenIndex, amount)
+				e.positionMap[allocation.PositionID] = pos
+				l.Infow(
+					"Update position hedged amounts from fill",
+					"positionID", allocation.PositionID,
+					"filled", common.FormatAmount(amount, decimals, 5),
+					"hedgedAmount0", pos.HedgedAmount0,
+					"hedgedAmount1", pos.HedgedAmount1,
+				)
+			}
+			allocation.Applied = applied
+		}
+
+		err := e.db.Transaction(func(tx *gorm.DB) error {
+			err := tx.Model(&models.Order{}).
+				Where("client_order_id = ?", clientOrderID).
+				Update("applied_quantity", filled.String()).Error
+			if err != nil {
+				return err
+			}
+			for _, allocation := range order.Allocations {
+				err = tx.Model(&models.OrderAllocation{}).
+					Where("id = ?", allocation.ID).
+					Update("applied_amount", allocation.Applied.String()).Error
+				if err != nil {
+					return err
+				}
+			}
+			return e.savePositionsWith(tx)
+		})
+		if err != nil {
+			l.Warnw("Fail to save order fill into database", "error", err)
+		}
+	}
+
+	switch status {
+	case futures.OrderStatusTypeFilled,
+		futures.OrderStatusTypeCanceled,
+		futures.OrderStatusTypeExpired,
+		futures.OrderStatusTypeRejected:
+		l.Infow(
+			"Order is finished",
+			"status", status,
+			"quantity", common.FormatAmount(order.Quantity, order.Decimals, 5),
+			"filled", common.FormatAmount(order.Filled, order.Decimals, 5),
+		)
+		delete(e.pendingOrders, clientOrderID)
+	}
+}
@@ -0,0 +1,128 @@
+package elasticlm
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/hiepnv90/elastic-lm/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sendOrderUpdate(e *ElasticLM, clientOrderID string, filled string, status futures.OrderStatusType) {
+	e.handleUserDataEvent(&futures.WsUserDataEvent{
+		Event: futures.UserDataEventTypeOrderTradeUpdate,
+		OrderTradeUpdate: futures.WsOrderTradeUpdate{
+			Symbol:               testSymbol,
+			ClientOrderID:        clientOrderID,
+			Status:               status,
+			AccumulatedFilledQty: filled,
+		},
+	})
+}
+
+func TestHandleOrderTradeUpdate(t *testing.T) {
+	e, _ := newTestElasticLM(t, Options{})
+	e.positionMap["1"] = newTestPosition("1", "1", "0")
+	e.pendingOrders["elm-1"] = &pendingOrder{
+		Symbol:      testSymbol,
+		Decimals:    testDecimals,
+		Side:        futures.SideTypeSell,
+		Quantity:    ethAmount("1"),
+		Filled:      big.NewInt(0),
+		Allocations: []*orderAllocation{newTestAllocation("1", "1")},
+	}
+
+	sendOrderUpdate(e, "elm-1", "0.4", futures.OrderStatusTypePartiallyFilled)
+	assertAmount(t, "0.4", e.positionMap["1"].HedgedAmount0)
+
+	sendOrderUpdate(e, "elm-1", "0.4", futures.OrderStatusTypePartiallyFilled)
+	assertAmount(t, "0.4", e.positionMap["1"].HedgedAmount0, "duplicate fill")
+
+	sendOrderUpdate(e, "elm-1", "0.2", futures.OrderStatusTypePartiallyFilled)
+	assertAmount(t, "0.4", e.positionMap["1"].HedgedAmount0, "fill arriving after a later one")
+
+	sendOrderUpdate(e, "elm-1", "1", futures.OrderStatusTypeFilled)
+	assertAmount(t, "1", e.positionMap["1"].HedgedAmount0)
+	assert.NotContains(t, e.pendingOrders, "elm-1")
+
+	sendOrderUpdate(e, "elm-1", "1", futures.OrderStatusTypeFilled)
+	assertAmount(t, "1", e.positionMap["1"].HedgedAmount0, "update of a finished order")
+
+	var saved models.Position
+	require.NoError(t, e.db.Where("id = ?", "1").First(&saved).Error)
+	assert.Equal(t, ethAmount("1").String(), saved.HedgedAmount0)
+}
+
+func TestQueueUserDataEvent(t *testing.T) {
+	e, _ := newTestElasticLM(t, Options{})
+	update := &futures.WsUserDataEvent{Event: futures.UserDataEventTypeOrderTradeUpdate}
+
+	e.queueUserDataEvent(update)
+	assert.Len(t, e.userDataC, 1)
+	assert.Empty(t, e.resyncC)
+
+	for len(e.userDataC) < cap(e.userDataC) {
+		e.queueUserDataEvent(update)
+	}
+	e.queueUserDataEvent(update)
+	assert.Len(t, e.resyncC, 1, "dropped update is replaced by a resync")
+	assert.Empty(t, e.reconnectC)
+
+	e.queueUserDataEvent(&futures.WsUserDataEvent{Event: futures.UserDataEventTypeListenKeyExpired})
+	assert.Len(t, e.reconnectC, 1, "expired listen key still reconnects")
+}
+
+func TestResyncPendingOrders(t *testing.T) {
+	e, sim := newTestElasticLM(t, Options{})
+	e.positionMap["1"] = newTestPosition("1", "1", "0")
+
+	// The fill of an order is missed by the user data stream.
+	openTestShort(t, sim, "0.5")
+	e.pendingOrders["manual-0.5"] = &pendingOrder{
+		Symbol:      testSymbol,
+		Decimals:    testDecimals,
+		Side:        futures.SideTypeSell,
+		Quantity:    ethAmount("0.5"),
+		Filled:      big.NewInt(0),
+		Allocations: []*orderAllocation{newTestAllocation("1", "0.5")},
+	}
+
+	e.resyncPendingOrders(context.Background())
+	assertAmount(t, "0.5", e.positionMap["1"].HedgedAmount0)
+	assert.Empty(t, e.pendingOrders)
+}
+
+func TestApplyOrderFillSplitsProRata(t *testing.T) {
+	e, _ := newTestElasticLM(t, Options{})
+	e.positionMap["1"] = newTestPosition("1", "1", "0")
+	e.positionMap["2"] = newTestPosition("2", "1", "0.2")
+	e.positionMap["3"] = newTestPosition("3", "1", "0")
+	clientOrderID := journalTestOrder(
+		t, e, "0.6", string(futures.OrderStatusTypeNew),
+		newTestAllocation("1", "0.5"), newTestAllocation("2", "-0.2"), newTestAllocation("3", "0.3"),
+	)
+	require.NoError(t, e.loadOpenOrders())
+
+	sendOrderUpdate(e, clientOrderID, "0.3", futures.OrderStatusTypePartiallyFilled)
+	assertAmount(t, "0.25", e.positionMap["1"].HedgedAmount0)
+	assertAmount(t, "0.1", e.positionMap["2"].HedgedAmount0, "offsetting allocation is reduced")
+	assertAmount(t, "0.15", e.positionMap["3"].HedgedAmount0)
+
+	sendOrderUpdate(e, clientOrderID, "0.4", futures.OrderStatusTypePartiallyFilled)
+	assert.Equal(t, "333333333333333333", e.positionMap["1"].HedgedAmount0.String(), "shares are rounded down")
+
+	sendOrderUpdate(e, clientOrderID, "0.6", futures.OrderStatusTypeFilled)
+	assertAmount(t, "0.5", e.positionMap["1"].HedgedAmount0, "full fill applies the whole allocations")
+	assertAmount(t, "0", e.positionMap["2"].HedgedAmount0)
+	assertAmount(t, "0.3", e.positionMap["3"].HedgedAmount0)
+
+	allocations, err := models.ListOrderAllocations(e.db, getJournalOrder(t, e, clientOrderID).ID)
+	require.NoError(t, err)
+	require.Len(t, allocations, 3)
+	for _, allocation := range allocations {
+		assert.Equal(t, allocation.Amount, allocation.AppliedAmount)
+	}
+}
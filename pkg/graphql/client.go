@@ -86,23 +86,47 @@ type Tick struct {
 
 type Position struct {
 	ID        string `json:"id"`
+	Owner     string `json:"owner"`
 	Liquidity string `json:"liquidity"`
 	Pool      Pool   `json:"pool"`
 	TickLower Tick   `json:"tickLower"`
 	TickUpper Tick   `json:"tickUpper"`
 }
 
+// Block is the latest block which the subgraph has indexed, Timestamp is in seconds.
+type Block struct {
+	Number    int64 `json:"number"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+type Meta struct {
+	Block Block `json:"block"`
+}
+
+type Error struct {
+	Message string `json:"message"`
+}
+
 type PositionsResponse struct {
 	Data struct {
 		Positions []Position `json:"positions"`
+		Meta      Meta       `json:"_meta"`
 	} `json:"data"`
+	Errors []Error `json:"errors"`
 }
 
 func (c *Client) GetPositions(ids []string) ([]Position, error) {
+	positions, _, err := c.GetPositionsWithBlock(ids)
+	return positions, err
+}
+
+// GetPositionsWithBlock returns the positions and the latest block which the subgraph has indexed, so callers can
+// tell how fresh the positions are.
+func (c *Client) GetPositionsWithBlock(ids []string) ([]Position, Block, error) {
 	l := c.logger.With("ids", ids)
 
 	idsStr := strings.Join(ids, ",")
-	query := fmt.Sprintf("{\n  positions(where: {id_in: [%s]}) {\n    id\n    liquidity\n    pool {\n      sqrtPrice\n      tick\n      token0 {\n        symbol\n        decimals\n      }\n      token1 {\n        symbol\n        decimals\n      }\n    }\n    tickLower {\n      tickIdx\n    }\n    tickUpper {\n      tickIdx\n    }\n  }\n}", idsStr)
+	query := fmt.Sprintf("{\n  positions(where: {id_in: [%s]}) {\n    id\n    owner\n    liquidity\n    pool {\n      sqrtPrice\n      tick\n      token0 {\n        symbol\n        decimals\n      }\n      token1 {\n        symbol\n        decimals\n      }\n    }\n    tickLower {\n      tickIdx\n    }\n    tickUpper {\n      tickIdx\n    }\n  }\n  _meta {\n    block {\n      number\n      timestamp\n    }\n  }\n}", idsStr)
 	req := map[string]string{
 		"query": query,
 	}
@@ -110,15 +134,20 @@ func (c *Client) GetPositions(ids []string) ([]Position, error) {
 	resp, err := c.Post(c.baseURL, req)
 	if err != nil {
 		l.Errorw("Fail to query positions", "error", err)
-		return nil, err
+		return nil, Block{}, err
 	}
 
 	var posResp PositionsResponse
 	err = json.NewDecoder(resp.Body).Decode(&posResp)
 	if err != nil {
 		l.Errorw("Fail to debug positions data", "error", err)
-		return nil, err
+		return nil, Block{}, err
+	}
+	// A failed query has no data, which would look like all positions are gone.
+	if len(posResp.Errors) > 0 {
+		l.Errorw("Fail to query positions", "errors", posResp.Errors)
+		return nil, Block{}, fmt.Errorf("query positions: %s", posResp.Errors[0].Message)
 	}
 
-	return posResp.Data.Positions, nil
+	return posResp.Data.Positions, posResp.Data.Meta.Block, nil
 }
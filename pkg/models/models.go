@@ -4,19 +4,119 @@ import (
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+const (
+	// OrderStatusIntent is the status of an order which is recorded but may not have reached the exchange yet.
+	OrderStatusIntent = "INTENT"
+	// OrderStatusFailed is the status of an order which the exchange didn't accept.
+	OrderStatusFailed = "FAILED"
+)
+
+const (
+	// ParentOrderStatusActive is the status of a parent order which still has child orders to send.
+	ParentOrderStatusActive = "ACTIVE"
+	// ParentOrderStatusDone is the status of a parent order whose quantity is fully sent in child orders.
+	ParentOrderStatusDone = "DONE"
+	// ParentOrderStatusCanceled is the status of a parent order which is stopped after a child order is rejected.
+	ParentOrderStatusCanceled = "CANCELED"
+)
+
+// OpenOrderStatuses are the statuses of orders which may still be filled.
+var OpenOrderStatuses = []string{OrderStatusIntent, "NEW", "PARTIALLY_FILLED"}
+
+// Order is a journal entry of a hedge order sent to the exchange.
+// AppliedQuantity is the executed quantity (in Decimals) already split into the hedged amounts of its allocations.
+// PositionID is only set when the order hedges a single position.
+// ParentOrderID is set when the order is a child order of a sliced parent order.
 type Order struct {
+	ID               uint64 `gorm:"primaryKey"`
+	PositionID       string `gorm:"index"`
+	ParentOrderID    uint64 `gorm:"index"`
+	Symbol           string `gorm:"index"`
+	ClientOrderID    string `gorm:"uniqueIndex"`
+	OrderID          int64
+	Side             string
+	Type             string
+	ReduceOnly       bool
+	Price            string
+	StopPrice        string
+	Quantity         string
+	ExecutedQuantity string
+	Decimals         int
+	AppliedQuantity  string
+	AvgPrice         string
+	Status           string
+	Error            string
+	UpdateTime       int64
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+// OrderAllocation is the share of a netted order which belongs to a position's token.
+// Amount is signed (positive increases the hedged amount) and in the order's decimals,
+// AppliedAmount is the part of Amount which is already added to the position's hedged amount.
+type OrderAllocation struct {
 	ID            uint64 `gorm:"primaryKey"`
+	OrderID       uint64 `gorm:"index"`
 	PositionID    string `gorm:"index"`
-	Symbol        string
-	ClientOrderID string
-	Price         string
+	TokenIndex    int
 	Amount        string
+	AppliedAmount string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// ParentOrder is a large hedge order which is executed in child orders over a time window.
+// Its progress is the sum of the quantities of its child orders which weren't rejected,
+// so a restart continues sending the remaining quantity.
+type ParentOrder struct {
+	ID            uint64 `gorm:"primaryKey"`
+	Symbol        string `gorm:"index"`
 	Side          string
-	UpdateTime    int64
+	ReduceOnly    bool
+	Quantity      string
+	SliceQuantity string
+	Decimals      int
+	Interval      time.Duration
+	Status        string `gorm:"index"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// ParentOrderAllocation is the share of a parent order which belongs to a position's token.
+// Amount is signed (positive increases the hedged amount) and in the parent order's decimals.
+type ParentOrderAllocation struct {
+	ID            uint64 `gorm:"primaryKey"`
+	ParentOrderID uint64 `gorm:"index"`
+	PositionID    string `gorm:"index"`
+	TokenIndex    int
+	Amount        string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
 }
 
+const (
+	// SettingHalted is the key of the setting which is set while hedging is halted by flattening all shorts.
+	SettingHalted = "halted"
+	// SettingMarginHedgeScale is the key of the setting which scales down the hedge ratios after reducing hedges on
+	// margin.
+	SettingMarginHedgeScale = "margin_hedge_scale"
+	// SettingInstallID is the key of the random ID of the database, which client order IDs carry so they don't
+	// repeat the ones sent before the database is recreated.
+	SettingInstallID = "install_id"
+)
+
+// Setting is a state of the program which is kept across restarts, e.g. whether hedging is halted.
+type Setting struct {
+	Key       string `gorm:"primaryKey"`
+	Value     string
+	UpdatedAt time.Time
+}
+
+// Position is the saved state of a tracked position.
+// ClosedAt is set once the position is closed and its hedges are unwound, the record then keeps the final hedge state.
 type Position struct {
 	ID            string `gorm:"primaryKey"`
 	Liquidity     string
@@ -30,10 +130,14 @@ type Position struct {
 	Symbol1       string
 	Decimals1     int
 	HedgedAmount1 string
+	HedgeRatioBps int64      `gorm:"default:10000"`
+	ClosedAt      *time.Time `gorm:"index"`
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 }
 
+// AutoMigrate creates or updates the tables. Reset drops the saved positions first, the order journal is kept since
+// it is the record to recover the orders sent before a crash, and the settings are kept too.
 func AutoMigrate(db *gorm.DB, reset bool) error {
 	if reset {
 		err := db.Migrator().DropTable(&Position{})
@@ -42,5 +146,81 @@ func AutoMigrate(db *gorm.DB, reset bool) error {
 		}
 	}
 
-	return db.AutoMigrate(&Position{})
+	return db.AutoMigrate(
+		&Position{}, &Order{}, &OrderAllocation{}, &ParentOrder{}, &ParentOrderAllocation{}, &Setting{},
+	)
+}
+
+// ListOrders returns the latest journaled orders, optionally filtered by position and symbol.
+func ListOrders(db *gorm.DB, positionID string, symbol string, limit int) ([]Order, error) {
+	query := db.Order("id DESC")
+	if positionID != "" {
+		query = query.Where(
+			"position_id = ? OR id IN (?)",
+			positionID, db.Model(&OrderAllocation{}).Select("order_id").Where("position_id = ?", positionID),
+		)
+	}
+	if symbol != "" {
+		query = query.Where("symbol = ?", symbol)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	var orders []Order
+	err := query.Find(&orders).Error
+	return orders, err
+}
+
+// ListOpenOrders returns the journaled orders which are not in a final state.
+func ListOpenOrders(db *gorm.DB) ([]Order, error) {
+	var orders []Order
+	err := db.Where("status IN ?", OpenOrderStatuses).Order("id").Find(&orders).Error
+	return orders, err
+}
+
+// ListOrderAllocations returns the allocations of a journaled order.
+func ListOrderAllocations(db *gorm.DB, orderID uint64) ([]OrderAllocation, error) {
+	var allocations []OrderAllocation
+	err := db.Where("order_id = ?", orderID).Order("id").Find(&allocations).Error
+	return allocations, err
+}
+
+// ListActiveParentOrders returns the parent orders which still have child orders to send.
+func ListActiveParentOrders(db *gorm.DB) ([]ParentOrder, error) {
+	var orders []ParentOrder
+	err := db.Where("status = ?", ParentOrderStatusActive).Order("id").Find(&orders).Error
+	return orders, err
+}
+
+// ListParentOrderAllocations returns the allocations of a parent order.
+func ListParentOrderAllocations(db *gorm.DB, parentOrderID uint64) ([]ParentOrderAllocation, error) {
+	var allocations []ParentOrderAllocation
+	err := db.Where("parent_order_id = ?", parentOrderID).Order("id").Find(&allocations).Error
+	return allocations, err
+}
+
+// ListChildOrders returns the child orders of a parent order which weren't rejected by the exchange.
+func ListChildOrders(db *gorm.DB, parentOrderID uint64) ([]Order, error) {
+	var orders []Order
+	err := db.Where("parent_order_id = ? AND status <> ?", parentOrderID, OrderStatusFailed).Order("id").Find(&orders).Error
+	return orders, err
+}
+
+// GetSetting returns the value of a setting, it is empty when the setting isn't set.
+func GetSetting(db *gorm.DB, key string) (string, error) {
+	var settings []Setting
+	err := db.Where("key = ?", key).Limit(1).Find(&settings).Error
+	if err != nil || len(settings) == 0 {
+		return "", err
+	}
+	return settings[0].Value, nil
+}
+
+// SetSetting sets the value of a setting, an empty value deletes it.
+func SetSetting(db *gorm.DB, key string, value string) error {
+	if value == "" {
+		return db.Where("key = ?", key).Delete(&Setting{}).Error
+	}
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&Setting{Key: key, Value: value}).Error
 }
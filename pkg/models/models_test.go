@@ -0,0 +1,74 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+
+	// Every connection opens its own in-memory database, so all queries share one connection.
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	require.NoError(t, AutoMigrate(db, false))
+	return db
+}
+
+func getClientOrderIDs(orders []Order) []string {
+	ids := make([]string, 0, len(orders))
+	for _, order := range orders {
+		ids = append(ids, order.ClientOrderID)
+	}
+	return ids
+}
+
+func TestListOrders(t *testing.T) {
+	db := newTestDB(t)
+	for _, order := range []Order{
+		{PositionID: "1", Symbol: "ETHBUSD", ClientOrderID: "a"},
+		{PositionID: "2", Symbol: "ETHBUSD", ClientOrderID: "b"},
+		{PositionID: "1", Symbol: "BTCBUSD", ClientOrderID: "c"},
+		{Symbol: "ETHBUSD", ClientOrderID: "d"},
+	} {
+		require.NoError(t, db.Create(&order).Error)
+	}
+	// A netted order belongs to the positions of its allocations.
+	netted := Order{Symbol: "ETHBUSD", ClientOrderID: "e"}
+	require.NoError(t, db.Create(&netted).Error)
+	for _, positionID := range []string{"1", "2"} {
+		require.NoError(t, db.Create(&OrderAllocation{OrderID: netted.ID, PositionID: positionID}).Error)
+	}
+
+	tests := []struct {
+		name       string
+		positionID string
+		symbol     string
+		limit      int
+		expected   []string
+	}{
+		{name: "all orders", expected: []string{"e", "d", "c", "b", "a"}},
+		{name: "by position", positionID: "1", expected: []string{"e", "c", "a"}},
+		{name: "by symbol", symbol: "ETHBUSD", expected: []string{"e", "d", "b", "a"}},
+		{name: "by position and symbol", positionID: "2", symbol: "ETHBUSD", expected: []string{"e", "b"}},
+		{name: "by position without orders", positionID: "3", expected: []string{}},
+		{name: "latest orders", limit: 2, expected: []string{"e", "d"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			orders, err := ListOrders(db, test.positionID, test.symbol, test.limit)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, getClientOrderIDs(orders))
+		})
+	}
+}
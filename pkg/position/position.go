@@ -12,10 +12,12 @@ type Position struct {
 	Liquidity     *big.Int
 	TickLower     int
 	TickUpper     int
+	Tick          int
 	MaxAmount0    *big.Int
 	MaxAmount1    *big.Int
 	HedgedAmount0 *big.Int
 	HedgedAmount1 *big.Int
+	HedgeRatioBps int64
 	Token0        common.Token
 	Token1        common.Token
 }
@@ -28,5 +30,54 @@ func (p Position) String() string {
 }
 
 func (p Position) Equal(o Position) bool {
-	return p.Token0.Equal(o.Token0) && p.Token1.Equal(o.Token1)
+	return p.Token0.Equal(o.Token0) && p.Token1.Equal(o.Token1) && p.HedgeRatioBps == o.HedgeRatioBps
+}
+
+// IsClosed returns whether all liquidity of the position is removed.
+func (p Position) IsClosed() bool {
+	return p.Liquidity == nil || p.Liquidity.Sign() == 0
+}
+
+// Token returns the position's token0 or token1 by index.
+func (p Position) Token(index int) common.Token {
+	if index == 0 {
+		return p.Token0
+	}
+	return p.Token1
+}
+
+// MaxAmount returns the max amount of token0 or token1 by index.
+func (p Position) MaxAmount(index int) *big.Int {
+	if index == 0 {
+		return p.MaxAmount0
+	}
+	return p.MaxAmount1
+}
+
+// HedgeTarget returns the amount of token0 or token1 by index which should be hedged with the position's hedge ratio.
+func (p Position) HedgeTarget(index int) *big.Int {
+	return common.BigDiv(common.BigMul(p.Token(index).Amount, big.NewInt(p.HedgeRatioBps)), big.NewInt(10000))
+}
+
+// MaxHedgeTarget returns the max amount of token0 or token1 by index which should be hedged with the position's
+// hedge ratio.
+func (p Position) MaxHedgeTarget(index int) *big.Int {
+	return common.BigDiv(common.BigMul(p.MaxAmount(index), big.NewInt(p.HedgeRatioBps)), big.NewInt(10000))
+}
+
+// HedgedAmount returns the hedged amount of token0 or token1 by index.
+func (p Position) HedgedAmount(index int) *big.Int {
+	if index == 0 {
+		return p.HedgedAmount0
+	}
+	return p.HedgedAmount1
+}
+
+// AddHedgedAmount adds a signed amount to the hedged amount of token0 or token1 by index.
+func (p *Position) AddHedgedAmount(index int, amount *big.Int) {
+	if index == 0 {
+		p.HedgedAmount0 = common.BigAdd(p.HedgedAmount0, amount)
+		return
+	}
+	p.HedgedAmount1 = common.BigAdd(p.HedgedAmount1, amount)
 }
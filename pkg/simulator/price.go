@@ -0,0 +1,126 @@
+package simulator
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PriceSource provides the prices which simulated orders are filled at.
+type PriceSource interface {
+	GetPrice(symbol string) (float64, bool)
+}
+
+// StaticPrices fills every order of a symbol at a fixed configured price.
+type StaticPrices map[string]float64
+
+func (p StaticPrices) GetPrice(symbol string) (float64, bool) {
+	price, ok := p[strings.ToUpper(symbol)]
+	return price, ok
+}
+
+type pricePoint struct {
+	time  time.Time
+	price float64
+}
+
+// ReplayPrices replays recorded prices as time goes by.
+// The first recorded timestamp is mapped to the moment the replay is created,
+// and the recording is then played back at the configured speed.
+type ReplayPrices struct {
+	points map[string][]pricePoint
+	origin time.Time
+	start  time.Time
+	speed  float64
+	now    func() time.Time
+}
+
+// NewReplayPrices reads prices in CSV format with rows of `timestamp,symbol,price`,
+// where timestamp is in unix seconds. Rows whose timestamp is not a number (e.g. a header) are skipped.
+func NewReplayPrices(r io.Reader, speed float64) (*ReplayPrices, error) {
+	if speed <= 0 {
+		speed = 1
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	points := make(map[string][]pricePoint)
+	var origin time.Time
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		ts, err := strconv.ParseInt(record[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		price, err := strconv.ParseFloat(record[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid price %q for symbol %s: %w", record[2], record[1], err)
+		}
+
+		t := time.Unix(ts, 0)
+		if origin.IsZero() || t.Before(origin) {
+			origin = t
+		}
+
+		symbol := strings.ToUpper(record[1])
+		points[symbol] = append(points[symbol], pricePoint{time: t, price: price})
+	}
+
+	if len(points) == 0 {
+		return nil, fmt.Errorf("no prices to replay")
+	}
+
+	for _, ps := range points {
+		sort.SliceStable(ps, func(i, j int) bool { return ps[i].time.Before(ps[j].time) })
+	}
+
+	return &ReplayPrices{
+		points: points,
+		origin: origin,
+		start:  time.Now(),
+		speed:  speed,
+		now:    time.Now,
+	}, nil
+}
+
+// LoadReplayPrices reads replay prices from a CSV file.
+func LoadReplayPrices(fpath string, speed float64) (*ReplayPrices, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return NewReplayPrices(f, speed)
+}
+
+func (p *ReplayPrices) GetPrice(symbol string) (float64, bool) {
+	ps, ok := p.points[strings.ToUpper(symbol)]
+	if !ok {
+		return 0, false
+	}
+
+	elapsed := time.Duration(float64(p.now().Sub(p.start)) * p.speed)
+	t := p.origin.Add(elapsed)
+	i := sort.Search(len(ps), func(i int) bool { return ps[i].time.After(t) })
+	if i == 0 {
+		return ps[0].price, true
+	}
+
+	return ps[i-1].price, true
+}
@@ -0,0 +1,1174 @@
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bcommon "github.com/adshao/go-binance/v2/common"
+	"github.com/adshao/go-binance/v2/futures"
+	"go.uber.org/zap"
+)
+
+const (
+	stepTolerance   = 1e-9
+	eventBufferSize = 1000
+	matchInterval   = 100 * time.Millisecond
+	defaultLeverage = 20
+	maxLeverage     = 125
+	maintMarginRate = 0.004
+)
+
+// MarketData provides the real exchange's public market information to the simulator.
+type MarketData interface {
+	GetExchangeInfo(ctx context.Context) (*futures.ExchangeInfo, error)
+}
+
+// Position is a simulated futures position of a symbol, on one of the LONG and SHORT sides in hedge mode.
+type Position struct {
+	Symbol       string
+	PositionSide futures.PositionSideType
+	Amount       float64
+	EntryPrice   float64
+	RealizedPnL  float64
+	Fee          float64
+}
+
+// positionKey identifies a position by its symbol and position side.
+type positionKey struct {
+	symbol string
+	side   futures.PositionSideType
+}
+
+// Exchange is an in-process simulated USDⓈ-M futures exchange used for paper trading.
+// Market orders are filled immediately at the price given by the price source.
+// Stop orders rest until the price reaches their stop price, then they are filled when the price satisfies their limit.
+// The simulated book is one tick on each side of the price, limit orders which don't cross it rest until the price
+// trades through their limit, and are filled at their limit.
+// With a depth set, every level of the book holds the depth's quantity and market orders walk the book.
+// Positions share the balance of their margin asset as cross margin, their maintenance margin is a fixed rate of their
+// notional at mark price.
+// Accounts are in one-way mode by default, in hedge mode orders name the side of the position they open or close.
+type Exchange struct {
+	mu sync.Mutex
+
+	marketData    MarketData
+	prices        PriceSource
+	feeRate       float64
+	depthQuantity float64
+	symbolInfoMap map[string]futures.Symbol
+	balances      map[string]float64
+	spotBalances  map[string]float64
+	leverages     map[string]int
+	marginTypes   map[string]futures.MarginType
+	positions     map[positionKey]*Position
+	dualSide      bool
+	orders        map[string]*futures.Order
+	openOrders    []*restingOrder
+	events        chan *futures.WsUserDataEvent
+	nextOrderID   int64
+
+	now    func() time.Time
+	logger *zap.SugaredLogger
+}
+
+// restingOrder is an open stop or limit order waiting for the price.
+type restingOrder struct {
+	order     *futures.Order
+	quantity  float64
+	price     float64
+	stopPrice float64
+	triggered bool
+}
+
+// New creates a simulated exchange with the given initial balances (by margin asset).
+// Trading fee is charged on the notional of every fill.
+func New(marketData MarketData, prices PriceSource, feeBps float64, balances map[string]float64) *Exchange {
+	initialBalances := make(map[string]float64, len(balances))
+	for asset, balance := range balances {
+		initialBalances[strings.ToUpper(asset)] = balance
+	}
+
+	return &Exchange{
+		marketData:    marketData,
+		prices:        prices,
+		feeRate:       feeBps / 10000,
+		symbolInfoMap: make(map[string]futures.Symbol),
+		balances:      initialBalances,
+		spotBalances:  make(map[string]float64),
+		leverages:     make(map[string]int),
+		marginTypes:   make(map[string]futures.MarginType),
+		positions:     make(map[positionKey]*Position),
+		orders:        make(map[string]*futures.Order),
+		events:        make(chan *futures.WsUserDataEvent, eventBufferSize),
+		nextOrderID:   1,
+		now:           time.Now,
+		logger:        zap.S().With("exchange", "simulator"),
+	}
+}
+
+// SetDepth sets the quantity of every level of the simulated books, the levels are one tick size apart.
+// Books have unlimited quantity at the best prices without a depth.
+func (e *Exchange) SetDepth(quantity float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.depthQuantity = quantity
+}
+
+func (e *Exchange) GetExchangeInfo(ctx context.Context) (*futures.ExchangeInfo, error) {
+	exchangeInfo, err := e.marketData.GetExchangeInfo(ctx)
+	if err != nil {
+		e.logger.Errorw("Fail to get exchange information", "error", err)
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, symbolInfo := range exchangeInfo.Symbols {
+		e.symbolInfoMap[symbolInfo.Symbol] = symbolInfo
+	}
+
+	return exchangeInfo, nil
+}
+
+func (e *Exchange) CreateFutureOrder(
+	_ context.Context,
+	symbol string,
+	quantity string,
+	price string,
+	stopPrice string,
+	side futures.SideType,
+	orderType futures.OrderType,
+	timeInForce futures.TimeInForceType,
+	reduceOnly bool,
+	positionSide futures.PositionSideType,
+	clientOrderID string,
+) (*futures.CreateOrderResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	symbolInfo, ok := e.symbolInfoMap[symbol]
+	if !ok {
+		return nil, &bcommon.APIError{Code: -1121, Message: "Invalid symbol."}
+	}
+
+	if orderType != futures.OrderTypeMarket && orderType != futures.OrderTypeStop && orderType != futures.OrderTypeLimit {
+		return nil, &bcommon.APIError{Code: -1116, Message: "Invalid orderType."}
+	}
+
+	positionSide, err := e.checkPositionSide(positionSide, reduceOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	qty, err := e.parseQuantity(symbolInfo, quantity)
+	if err != nil {
+		return nil, err
+	}
+
+	marketPrice, ok := e.prices.GetPrice(symbol)
+	if !ok || marketPrice <= 0 {
+		return nil, fmt.Errorf("no price to fill order of symbol %s", symbol)
+	}
+
+	if orderType == futures.OrderTypeStop {
+		return e.createStopOrder(
+			symbolInfo, qty, quantity, price, stopPrice, side, timeInForce, reduceOnly, positionSide, clientOrderID,
+			marketPrice,
+		)
+	}
+	if orderType == futures.OrderTypeLimit {
+		return e.createLimitOrder(
+			symbolInfo, qty, quantity, price, side, timeInForce, reduceOnly, positionSide, clientOrderID, marketPrice,
+		)
+	}
+
+	signedQty := qty
+	if side == futures.SideTypeSell {
+		signedQty = -qty
+	}
+
+	reducing := isReducing(reduceOnly, positionSide, side)
+	pos := e.getPosition(symbol, positionSide)
+	if reducing && (pos.Amount*signedQty >= 0 || math.Abs(pos.Amount) < qty-stepTolerance) {
+		return nil, &bcommon.APIError{Code: -2022, Message: "ReduceOnly Order is rejected."}
+	}
+
+	err = checkMinNotional(symbolInfo, qty*marketPrice, reducing)
+	if err != nil {
+		return nil, err
+	}
+
+	order := e.newOrder(symbol, quantity, "0", "", side, orderType, timeInForce, reduceOnly, positionSide, clientOrderID)
+	e.fillOrder(symbolInfo, order, qty, e.getFillPrice(symbolInfo, side, qty, marketPrice))
+
+	return newCreateOrderResponse(order), nil
+}
+
+func (e *Exchange) createStopOrder(
+	symbolInfo futures.Symbol,
+	qty float64,
+	quantity string,
+	price string,
+	stopPrice string,
+	side futures.SideType,
+	timeInForce futures.TimeInForceType,
+	reduceOnly bool,
+	positionSide futures.PositionSideType,
+	clientOrderID string,
+	marketPrice float64,
+) (*futures.CreateOrderResponse, error) {
+	limitPrice, err := strconv.ParseFloat(price, 64)
+	if err != nil || limitPrice <= 0 {
+		return nil, &bcommon.APIError{Code: -1102, Message: "Mandatory parameter 'price' was not sent, was empty/null, or malformed."}
+	}
+	triggerPrice, err := strconv.ParseFloat(stopPrice, 64)
+	if err != nil || triggerPrice <= 0 {
+		return nil, &bcommon.APIError{Code: -1102, Message: "Mandatory parameter 'stopPrice' was not sent, was empty/null, or malformed."}
+	}
+
+	if isTriggered(side, marketPrice, triggerPrice) {
+		return nil, &bcommon.APIError{Code: -2021, Message: "Order would immediately trigger."}
+	}
+
+	err = checkMinNotional(symbolInfo, qty*limitPrice, isReducing(reduceOnly, positionSide, side))
+	if err != nil {
+		return nil, err
+	}
+
+	order := e.newOrder(
+		symbolInfo.Symbol, quantity, price, stopPrice, side, futures.OrderTypeStop, timeInForce, reduceOnly,
+		positionSide, clientOrderID,
+	)
+	order.Status = futures.OrderStatusTypeNew
+	order.ExecutedQuantity = "0"
+	order.CumQuantity = "0"
+	order.CumQuote = "0"
+	order.AvgPrice = "0"
+	e.openOrders = append(e.openOrders, &restingOrder{
+		order:     order,
+		quantity:  qty,
+		price:     limitPrice,
+		stopPrice: triggerPrice,
+	})
+
+	e.logger.Infow(
+		"Place simulated stop order",
+		"clientOrderID", order.ClientOrderID,
+		"symbol", order.Symbol,
+		"side", side,
+		"quantity", quantity,
+		"price", price,
+		"stopPrice", stopPrice,
+	)
+	e.publishOrderUpdate(order, futures.OrderExecutionTypeNew, "0", 0)
+
+	return newCreateOrderResponse(order), nil
+}
+
+// createLimitOrder fills a limit order which crosses the book at the price, or rests it otherwise.
+// Post-only (GTX) orders which would cross the book are rejected.
+func (e *Exchange) createLimitOrder(
+	symbolInfo futures.Symbol,
+	qty float64,
+	quantity string,
+	price string,
+	side futures.SideType,
+	timeInForce futures.TimeInForceType,
+	reduceOnly bool,
+	positionSide futures.PositionSideType,
+	clientOrderID string,
+	marketPrice float64,
+) (*futures.CreateOrderResponse, error) {
+	limitPrice, err := strconv.ParseFloat(price, 64)
+	if err != nil || limitPrice <= 0 {
+		return nil, &bcommon.APIError{Code: -1102, Message: "Mandatory parameter 'price' was not sent, was empty/null, or malformed."}
+	}
+
+	bid, ask := getBook(symbolInfo, marketPrice)
+	crosses := (side == futures.SideTypeBuy && limitPrice >= ask) || (side == futures.SideTypeSell && limitPrice <= bid)
+	if crosses && timeInForce == futures.TimeInForceTypeGTX {
+		return nil, &bcommon.APIError{
+			Code:    -5022,
+			Message: "Due to the order could not be executed as maker, the Post Only order will be rejected.",
+		}
+	}
+
+	signedQty := qty
+	if side == futures.SideTypeSell {
+		signedQty = -qty
+	}
+	reducing := isReducing(reduceOnly, positionSide, side)
+	pos := e.getPosition(symbolInfo.Symbol, positionSide)
+	if reducing && (pos.Amount*signedQty >= 0 || math.Abs(pos.Amount) < qty-stepTolerance) {
+		return nil, &bcommon.APIError{Code: -2022, Message: "ReduceOnly Order is rejected."}
+	}
+
+	err = checkMinNotional(symbolInfo, qty*limitPrice, reducing)
+	if err != nil {
+		return nil, err
+	}
+
+	order := e.newOrder(
+		symbolInfo.Symbol, quantity, price, "", side, futures.OrderTypeLimit, timeInForce, reduceOnly,
+		positionSide, clientOrderID,
+	)
+	if crosses {
+		e.fillOrder(symbolInfo, order, qty, marketPrice)
+		return newCreateOrderResponse(order), nil
+	}
+
+	order.Status = futures.OrderStatusTypeNew
+	order.ExecutedQuantity = "0"
+	order.CumQuantity = "0"
+	order.CumQuote = "0"
+	order.AvgPrice = "0"
+	e.openOrders = append(e.openOrders, &restingOrder{
+		order:     order,
+		quantity:  qty,
+		price:     limitPrice,
+		triggered: true,
+	})
+
+	e.logger.Infow(
+		"Place simulated limit order",
+		"clientOrderID", order.ClientOrderID,
+		"symbol", order.Symbol,
+		"side", side,
+		"quantity", quantity,
+		"price", price,
+	)
+	e.publishOrderUpdate(order, futures.OrderExecutionTypeNew, "0", 0)
+
+	return newCreateOrderResponse(order), nil
+}
+
+func (e *Exchange) GetFutureOrder(_ context.Context, symbol string, clientOrderID string) (*futures.Order, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	order, ok := e.orders[clientOrderID]
+	if !ok || order.Symbol != symbol {
+		return nil, &bcommon.APIError{Code: -2013, Message: "Order does not exist."}
+	}
+
+	res := *order
+	return &res, nil
+}
+
+// CancelFutureOrder cancels an open stop or limit order, the part which is already filled is kept.
+func (e *Exchange) CancelFutureOrder(
+	_ context.Context, symbol string, clientOrderID string,
+) (*futures.CancelOrderResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i, resting := range e.openOrders {
+		order := resting.order
+		if order.ClientOrderID != clientOrderID || order.Symbol != symbol {
+			continue
+		}
+
+		e.openOrders = append(e.openOrders[:i], e.openOrders[i+1:]...)
+		order.Status = futures.OrderStatusTypeCanceled
+		order.UpdateTime = e.now().UnixMilli()
+		e.publishOrderUpdate(order, futures.OrderExecutionTypeCanceled, "0", 0)
+
+		return &futures.CancelOrderResponse{
+			ClientOrderID:    order.ClientOrderID,
+			CumQuantity:      order.CumQuantity,
+			CumQuote:         order.CumQuote,
+			ExecutedQuantity: order.ExecutedQuantity,
+			OrderID:          order.OrderID,
+			OrigQuantity:     order.OrigQuantity,
+			Price:            order.Price,
+			ReduceOnly:       order.ReduceOnly,
+			Side:             order.Side,
+			Status:           order.Status,
+			StopPrice:        order.StopPrice,
+			Symbol:           order.Symbol,
+			TimeInForce:      order.TimeInForce,
+			Type:             order.Type,
+			UpdateTime:       order.UpdateTime,
+			OrigType:         order.OrigType,
+			PositionSide:     order.PositionSide,
+		}, nil
+	}
+
+	return nil, &bcommon.APIError{Code: -2011, Message: "Unknown order sent."}
+}
+
+// MatchOrders triggers and fills the open orders which the current prices reach.
+// It runs periodically while user data is listened to.
+func (e *Exchange) MatchOrders() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	openOrders := e.openOrders[:0]
+	for _, resting := range e.openOrders {
+		if !e.matchOrder(resting) {
+			openOrders = append(openOrders, resting)
+		}
+	}
+	e.openOrders = openOrders
+}
+
+// matchOrder returns whether the resting order is finished.
+func (e *Exchange) matchOrder(resting *restingOrder) bool {
+	order := resting.order
+	marketPrice, ok := e.prices.GetPrice(order.Symbol)
+	if !ok || marketPrice <= 0 {
+		return false
+	}
+
+	if !resting.triggered {
+		if !isTriggered(order.Side, marketPrice, resting.stopPrice) {
+			return false
+		}
+		resting.triggered = true
+	}
+
+	// A triggered stop order becomes a limit order.
+	if (order.Side == futures.SideTypeBuy && marketPrice > resting.price) ||
+		(order.Side == futures.SideTypeSell && marketPrice < resting.price) {
+		return false
+	}
+	// Limit orders are makers, so they are filled at their limit.
+	fillPrice := marketPrice
+	if order.Type == futures.OrderTypeLimit {
+		fillPrice = resting.price
+	}
+
+	signedQty := resting.quantity
+	if order.Side == futures.SideTypeSell {
+		signedQty = -resting.quantity
+	}
+	pos := e.getPosition(order.Symbol, order.PositionSide)
+	if isReducing(order.ReduceOnly, order.PositionSide, order.Side) && (pos.Amount*signedQty >= 0 || math.Abs(pos.Amount) < resting.quantity-stepTolerance) {
+		order.Status = futures.OrderStatusTypeExpired
+		order.UpdateTime = e.now().UnixMilli()
+		e.publishOrderUpdate(order, futures.OrderExecutionTypeExpired, "0", 0)
+		return true
+	}
+
+	e.fillOrder(e.symbolInfoMap[order.Symbol], order, resting.quantity, fillPrice)
+	return true
+}
+
+// GetPositionRisk returns the risks of the simulated positions, a symbol without a position has a zero one like on
+// Binance.
+func (e *Exchange) GetPositionRisk(_ context.Context, symbol string) ([]*futures.PositionRisk, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	positions := make([]*Position, 0, len(e.positions))
+	for _, pos := range e.positions {
+		if symbol != "" && pos.Symbol != symbol {
+			continue
+		}
+		positions = append(positions, pos)
+	}
+	if symbol != "" {
+		if _, ok := e.symbolInfoMap[symbol]; !ok {
+			return nil, &bcommon.APIError{Code: -1121, Message: "Invalid symbol."}
+		}
+		for _, positionSide := range e.getPositionSides() {
+			if _, ok := e.positions[positionKey{symbol: symbol, side: positionSide}]; !ok {
+				positions = append(positions, &Position{Symbol: symbol, PositionSide: positionSide})
+			}
+		}
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		if positions[i].Symbol != positions[j].Symbol {
+			return positions[i].Symbol < positions[j].Symbol
+		}
+		return positions[i].PositionSide < positions[j].PositionSide
+	})
+
+	risks := make([]*futures.PositionRisk, 0, len(positions))
+	for _, pos := range positions {
+		marginType := "cross"
+		if e.marginTypes[pos.Symbol] == futures.MarginTypeIsolated {
+			marginType = "isolated"
+		}
+
+		markPrice, _ := e.prices.GetPrice(pos.Symbol)
+		risks = append(risks, &futures.PositionRisk{
+			EntryPrice:       formatFloat(pos.EntryPrice),
+			MarginType:       marginType,
+			IsAutoAddMargin:  "false",
+			IsolatedMargin:   "0",
+			Leverage:         strconv.Itoa(e.getLeverage(pos.Symbol)),
+			LiquidationPrice: formatFloat(e.getLiquidationPrice(pos)),
+			MarkPrice:        formatFloat(markPrice),
+			PositionAmt:      formatFloat(pos.Amount),
+			Symbol:           pos.Symbol,
+			UnRealizedProfit: formatFloat(pos.Amount * (markPrice - pos.EntryPrice)),
+			PositionSide:     string(pos.PositionSide),
+			Notional:         formatFloat(pos.Amount * markPrice),
+			IsolatedWallet:   "0",
+		})
+	}
+
+	return risks, nil
+}
+
+func (e *Exchange) GetPositionMode(_ context.Context) (*futures.PositionMode, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return &futures.PositionMode{DualSidePosition: e.dualSide}, nil
+}
+
+// SetDualSidePosition switches the simulated account between hedge mode and one-way mode, like on Binance it can't be
+// switched with a position.
+func (e *Exchange) SetDualSidePosition(dualSide bool) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, pos := range e.positions {
+		if pos.Amount != 0 {
+			return &bcommon.APIError{Code: -4068, Message: "Position side cannot be changed if there exists position."}
+		}
+	}
+
+	e.dualSide = dualSide
+	return nil
+}
+
+// getPositionSides returns the position sides of a symbol in the account's position mode.
+func (e *Exchange) getPositionSides() []futures.PositionSideType {
+	if e.dualSide {
+		return []futures.PositionSideType{futures.PositionSideTypeLong, futures.PositionSideTypeShort}
+	}
+	return []futures.PositionSideType{futures.PositionSideTypeBoth}
+}
+
+// ChangeLeverage sets the leverage of a symbol, which decides the initial margin of its position.
+func (e *Exchange) ChangeLeverage(_ context.Context, symbol string, leverage int) (*futures.SymbolLeverage, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.symbolInfoMap[symbol]; !ok {
+		return nil, &bcommon.APIError{Code: -1121, Message: "Invalid symbol."}
+	}
+	if leverage < 1 || leverage > maxLeverage {
+		return nil, &bcommon.APIError{Code: -4028, Message: fmt.Sprintf("Leverage %d is not valid", leverage)}
+	}
+
+	e.leverages[symbol] = leverage
+	return &futures.SymbolLeverage{Symbol: symbol, Leverage: leverage, MaxNotionalValue: "INF"}, nil
+}
+
+// ChangeMarginType sets the margin type of a symbol, which is only recorded, positions are always simulated with
+// cross margin.
+func (e *Exchange) ChangeMarginType(_ context.Context, symbol string, marginType futures.MarginType) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.symbolInfoMap[symbol]; !ok {
+		return &bcommon.APIError{Code: -1121, Message: "Invalid symbol."}
+	}
+	if marginType != futures.MarginTypeCrossed && marginType != futures.MarginTypeIsolated {
+		return &bcommon.APIError{Code: -1116, Message: "Invalid marginType."}
+	}
+
+	current := e.marginTypes[symbol]
+	if current == "" {
+		current = futures.MarginTypeCrossed
+	}
+	if current == marginType {
+		return &bcommon.APIError{Code: -4046, Message: "No need to change margin type."}
+	}
+	for _, pos := range e.positions {
+		if pos.Symbol == symbol && pos.Amount != 0 {
+			return &bcommon.APIError{Code: -4048, Message: "Margin type cannot be changed if there exists position."}
+		}
+	}
+
+	e.marginTypes[symbol] = marginType
+	return nil
+}
+
+func (e *Exchange) getLeverage(symbol string) int {
+	if leverage, ok := e.leverages[symbol]; ok {
+		return leverage
+	}
+	return defaultLeverage
+}
+
+// GetAccount returns the simulated futures account, whose margin balances include the unrealized profits at mark
+// prices.
+func (e *Exchange) GetAccount(_ context.Context) (*futures.Account, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	assets := make([]string, 0, len(e.balances))
+	for asset := range e.balances {
+		assets = append(assets, asset)
+	}
+	sort.Strings(assets)
+
+	account := &futures.Account{CanDeposit: true, CanTrade: true, CanWithdraw: true, UpdateTime: e.now().UnixMilli()}
+	var walletBalance, unrealizedProfit, initialMargin, maintMargin float64
+	for _, asset := range assets {
+		assetProfit, assetInitialMargin, assetMaintMargin := e.getMargin(asset, nil)
+		marginBalance := e.balances[asset] + assetProfit
+		account.Assets = append(account.Assets, &futures.AccountAsset{
+			Asset:                  asset,
+			InitialMargin:          formatFloat(assetInitialMargin),
+			MaintMargin:            formatFloat(assetMaintMargin),
+			MarginBalance:          formatFloat(marginBalance),
+			MaxWithdrawAmount:      formatFloat(math.Max(0, marginBalance-assetInitialMargin)),
+			OpenOrderInitialMargin: "0",
+			PositionInitialMargin:  formatFloat(assetInitialMargin),
+			UnrealizedProfit:       formatFloat(assetProfit),
+			WalletBalance:          formatFloat(e.balances[asset]),
+		})
+
+		walletBalance += e.balances[asset]
+		unrealizedProfit += assetProfit
+		initialMargin += assetInitialMargin
+		maintMargin += assetMaintMargin
+	}
+
+	account.TotalWalletBalance = formatFloat(walletBalance)
+	account.TotalUnrealizedProfit = formatFloat(unrealizedProfit)
+	account.TotalMarginBalance = formatFloat(walletBalance + unrealizedProfit)
+	account.TotalInitialMargin = formatFloat(initialMargin)
+	account.TotalPositionInitialMargin = formatFloat(initialMargin)
+	account.TotalOpenOrderInitialMargin = "0"
+	account.TotalMaintMargin = formatFloat(maintMargin)
+	account.MaxWithdrawAmount = formatFloat(math.Max(0, walletBalance+unrealizedProfit-initialMargin))
+
+	return account, nil
+}
+
+// TransferToFutures moves an amount of an asset from the simulated spot wallet to the futures balances.
+func (e *Exchange) TransferToFutures(_ context.Context, asset string, amount string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	value, err := strconv.ParseFloat(amount, 64)
+	if err != nil || value <= 0 {
+		return &bcommon.APIError{Code: -1100, Message: "Illegal characters found in parameter 'amount'."}
+	}
+
+	asset = strings.ToUpper(asset)
+	if e.spotBalances[asset] < value {
+		return &bcommon.APIError{Code: -5002, Message: "You have insufficient balance."}
+	}
+
+	e.spotBalances[asset] -= value
+	e.balances[asset] += value
+	e.logger.Infow("Transfer from spot to futures", "asset", asset, "amount", value)
+
+	return nil
+}
+
+// getMargin returns the unrealized profit, initial margin and maintenance margin of the positions of a margin asset
+// at mark prices, except a position.
+func (e *Exchange) getMargin(asset string, except *Position) (unrealizedProfit, initialMargin, maintMargin float64) {
+	for _, pos := range e.positions {
+		if pos.Amount == 0 || pos == except || e.symbolInfoMap[pos.Symbol].MarginAsset != asset {
+			continue
+		}
+
+		markPrice, _ := e.prices.GetPrice(pos.Symbol)
+		notional := math.Abs(pos.Amount) * markPrice
+		unrealizedProfit += pos.Amount * (markPrice - pos.EntryPrice)
+		initialMargin += notional / float64(e.getLeverage(pos.Symbol))
+		maintMargin += notional * maintMarginRate
+	}
+	return unrealizedProfit, initialMargin, maintMargin
+}
+
+// getLiquidationPrice returns the mark price of a position at which the margin balance of its margin asset falls to
+// the maintenance margin, with the prices of the other positions unchanged, or 0 if there is no such price.
+func (e *Exchange) getLiquidationPrice(pos *Position) float64 {
+	if pos.Amount == 0 {
+		return 0
+	}
+
+	asset := e.symbolInfoMap[pos.Symbol].MarginAsset
+	otherProfit, _, otherMaintMargin := e.getMargin(asset, pos)
+	available := e.balances[asset] + otherProfit - otherMaintMargin
+
+	// available + amount * (price - entry) = |amount| * price * maintMarginRate
+	price := (pos.Amount*pos.EntryPrice - available) / (pos.Amount - math.Abs(pos.Amount)*maintMarginRate)
+	if price <= 0 {
+		return 0
+	}
+	return price
+}
+
+// GetPremiumIndex returns the mark prices of the symbols which the price source has a price for.
+func (e *Exchange) GetPremiumIndex(_ context.Context, symbol string) ([]*futures.PremiumIndex, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	symbols := []string{symbol}
+	if symbol == "" {
+		symbols = make([]string, 0, len(e.symbolInfoMap))
+		for s := range e.symbolInfoMap {
+			symbols = append(symbols, s)
+		}
+		sort.Strings(symbols)
+	}
+
+	premiumIndexes := make([]*futures.PremiumIndex, 0, len(symbols))
+	for _, s := range symbols {
+		markPrice, ok := e.prices.GetPrice(s)
+		if !ok {
+			continue
+		}
+		premiumIndexes = append(premiumIndexes, &futures.PremiumIndex{
+			Symbol:          s,
+			MarkPrice:       formatFloat(markPrice),
+			LastFundingRate: "0",
+			Time:            e.now().UnixMilli(),
+		})
+	}
+	if symbol != "" && len(premiumIndexes) == 0 {
+		return nil, &bcommon.APIError{Code: -1121, Message: "Invalid symbol."}
+	}
+
+	return premiumIndexes, nil
+}
+
+// GetBookTicker returns the simulated best prices of a symbol, one tick on each side of the price source's price.
+func (e *Exchange) GetBookTicker(_ context.Context, symbol string) (*futures.BookTicker, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	symbolInfo, ok := e.symbolInfoMap[symbol]
+	if !ok {
+		return nil, &bcommon.APIError{Code: -1121, Message: "Invalid symbol."}
+	}
+	price, ok := e.prices.GetPrice(symbol)
+	if !ok || price <= 0 {
+		return nil, fmt.Errorf("no price of symbol %s", symbol)
+	}
+
+	bid, ask := getBook(symbolInfo, price)
+	return &futures.BookTicker{
+		Symbol:      symbol,
+		BidPrice:    strconv.FormatFloat(bid, 'f', symbolInfo.PricePrecision, 64),
+		BidQuantity: "0",
+		AskPrice:    strconv.FormatFloat(ask, 'f', symbolInfo.PricePrecision, 64),
+		AskQuantity: "0",
+	}, nil
+}
+
+// GetOrderBook returns the simulated levels of a symbol's book around the price source's price.
+func (e *Exchange) GetOrderBook(_ context.Context, symbol string, limit int) (*futures.DepthResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	symbolInfo, ok := e.symbolInfoMap[symbol]
+	if !ok {
+		return nil, &bcommon.APIError{Code: -1121, Message: "Invalid symbol."}
+	}
+	price, ok := e.prices.GetPrice(symbol)
+	if !ok || price <= 0 {
+		return nil, fmt.Errorf("no price of symbol %s", symbol)
+	}
+
+	bid, ask := getBook(symbolInfo, price)
+	tickSize := ask - bid
+	quantity := formatFloat(e.depthQuantity)
+	if e.depthQuantity <= 0 {
+		limit = 1
+		quantity = formatFloat(math.MaxInt32)
+	}
+
+	book := &futures.DepthResponse{Time: e.now().UnixMilli(), TradeTime: e.now().UnixMilli()}
+	for i := 0; i < limit; i++ {
+		offset := float64(i) * tickSize / 2
+		book.Bids = append(book.Bids, futures.Bid{
+			Price:    strconv.FormatFloat(bid-offset, 'f', symbolInfo.PricePrecision, 64),
+			Quantity: quantity,
+		})
+		book.Asks = append(book.Asks, futures.Ask{
+			Price:    strconv.FormatFloat(ask+offset, 'f', symbolInfo.PricePrecision, 64),
+			Quantity: quantity,
+		})
+	}
+
+	return book, nil
+}
+
+// getFillPrice returns the average price of a market order which walks the simulated book.
+func (e *Exchange) getFillPrice(symbolInfo futures.Symbol, side futures.SideType, qty float64, price float64) float64 {
+	if e.depthQuantity <= 0 {
+		return price
+	}
+
+	bid, ask := getBook(symbolInfo, price)
+	tickSize := (ask - bid) / 2
+	best, sign := ask, 1.0
+	if side == futures.SideTypeSell {
+		best, sign = bid, -1.0
+	}
+
+	notional := 0.0
+	remaining := qty
+	for level := 0; remaining > stepTolerance; level++ {
+		filled := math.Min(remaining, e.depthQuantity)
+		notional += filled * (best + sign*float64(level)*tickSize)
+		remaining -= filled
+	}
+	return notional / qty
+}
+
+// ListenUserData calls handler with ORDER_TRADE_UPDATE events of simulated orders until stopC is closed.
+// Open stop orders are matched against the prices meanwhile.
+func (e *Exchange) ListenUserData(
+	_ context.Context,
+	handler futures.WsUserDataHandler,
+) (listenKey string, doneC, stopC chan struct{}, err error) {
+	doneC = make(chan struct{})
+	stopC = make(chan struct{})
+	go func() {
+		defer close(doneC)
+		ticker := time.NewTicker(matchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopC:
+				return
+			case <-ticker.C:
+				e.MatchOrders()
+			case event := <-e.events:
+				handler(event)
+			}
+		}
+	}()
+
+	return "simulator", doneC, stopC, nil
+}
+
+func (e *Exchange) KeepaliveUserData(_ context.Context, _ string) error {
+	return nil
+}
+
+// Positions returns a snapshot of all simulated positions.
+func (e *Exchange) Positions() []Position {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	positions := make([]Position, 0, len(e.positions))
+	for _, pos := range e.positions {
+		positions = append(positions, *pos)
+	}
+
+	return positions
+}
+
+// SetSpotBalance sets the simulated spot wallet balance of an asset, which can be transferred to futures.
+func (e *Exchange) SetSpotBalance(asset string, balance float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.spotBalances[strings.ToUpper(asset)] = balance
+}
+
+// Balance returns the simulated wallet balance of a margin asset.
+func (e *Exchange) Balance(asset string) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.balances[strings.ToUpper(asset)]
+}
+
+func (e *Exchange) parseQuantity(symbolInfo futures.Symbol, quantity string) (float64, error) {
+	qty, err := strconv.ParseFloat(quantity, 64)
+	if err != nil {
+		return 0, &bcommon.APIError{Code: -1100, Message: "Illegal characters found in parameter 'quantity'."}
+	}
+	if qty <= 0 {
+		return 0, &bcommon.APIError{Code: -4003, Message: "Quantity less than zero."}
+	}
+
+	if idx := strings.IndexByte(quantity, '.'); idx >= 0 {
+		decimals := len(strings.TrimRight(quantity[idx+1:], "0"))
+		if decimals > symbolInfo.QuantityPrecision {
+			return 0, &bcommon.APIError{Code: -1111, Message: "Precision is over the maximum defined for this asset."}
+		}
+	}
+
+	var minQty, maxQty, stepSize string
+	if filter := symbolInfo.MarketLotSizeFilter(); filter != nil {
+		minQty, maxQty, stepSize = filter.MinQuantity, filter.MaxQuantity, filter.StepSize
+	} else if filter := symbolInfo.LotSizeFilter(); filter != nil {
+		minQty, maxQty, stepSize = filter.MinQuantity, filter.MaxQuantity, filter.StepSize
+	}
+
+	if v, err := strconv.ParseFloat(minQty, 64); err == nil && qty < v-stepTolerance {
+		return 0, &bcommon.APIError{Code: -4003, Message: "Quantity less than minimum quantity."}
+	}
+	if v, err := strconv.ParseFloat(maxQty, 64); err == nil && v > 0 && qty > v+stepTolerance {
+		return 0, &bcommon.APIError{Code: -4005, Message: "Quantity greater than max quantity."}
+	}
+	if v, err := strconv.ParseFloat(stepSize, 64); err == nil && v > 0 {
+		steps := qty / v
+		if math.Abs(steps-math.Round(steps)) > stepTolerance*math.Max(1, steps) {
+			return 0, &bcommon.APIError{Code: -1013, Message: "Filter failure: LOT_SIZE"}
+		}
+	}
+
+	return qty, nil
+}
+
+func (e *Exchange) getPosition(symbol string, positionSide futures.PositionSideType) *Position {
+	key := positionKey{symbol: symbol, side: positionSide}
+	pos, ok := e.positions[key]
+	if !ok {
+		pos = &Position{Symbol: symbol, PositionSide: positionSide}
+		e.positions[key] = pos
+	}
+
+	return pos
+}
+
+// checkPositionSide returns the position side of an order, which is BOTH in one-way mode and LONG or SHORT in hedge
+// mode. Orders can't be reduce only in hedge mode, closing orders of a side reduce its position instead.
+func (e *Exchange) checkPositionSide(
+	positionSide futures.PositionSideType, reduceOnly bool,
+) (futures.PositionSideType, error) {
+	if positionSide == "" {
+		positionSide = futures.PositionSideTypeBoth
+	}
+
+	if !e.dualSide {
+		if positionSide != futures.PositionSideTypeBoth {
+			return "", &bcommon.APIError{Code: -4061, Message: "Order's position side does not match user's setting."}
+		}
+		return positionSide, nil
+	}
+
+	if positionSide != futures.PositionSideTypeLong && positionSide != futures.PositionSideTypeShort {
+		return "", &bcommon.APIError{Code: -4061, Message: "Order's position side does not match user's setting."}
+	}
+	if reduceOnly {
+		return "", &bcommon.APIError{Code: -1106, Message: "Parameter 'reduceonly' sent when not required."}
+	}
+	return positionSide, nil
+}
+
+// isReducing returns whether an order may only reduce its position, i.e. it is reduce only or it closes its side
+// in hedge mode.
+func isReducing(reduceOnly bool, positionSide futures.PositionSideType, side futures.SideType) bool {
+	return reduceOnly ||
+		(positionSide == futures.PositionSideTypeLong && side == futures.SideTypeSell) ||
+		(positionSide == futures.PositionSideTypeShort && side == futures.SideTypeBuy)
+}
+
+// fill applies a signed fill to the position and settles fee and realized PnL into the margin asset's balance.
+func (e *Exchange) fill(symbolInfo futures.Symbol, pos *Position, signedQty float64, price float64) {
+	fee := math.Abs(signedQty) * price * e.feeRate
+	pos.Fee += fee
+	e.balances[symbolInfo.MarginAsset] -= fee
+
+	newAmount := pos.Amount + signedQty
+	if pos.Amount == 0 || pos.Amount*signedQty > 0 {
+		pos.EntryPrice = (math.Abs(pos.Amount)*pos.EntryPrice + math.Abs(signedQty)*price) / math.Abs(newAmount)
+		pos.Amount = newAmount
+		return
+	}
+
+	closed := math.Min(math.Abs(signedQty), math.Abs(pos.Amount))
+	pnl := closed * (price - pos.EntryPrice)
+	if pos.Amount < 0 {
+		pnl = -pnl
+	}
+	pos.RealizedPnL += pnl
+	e.balances[symbolInfo.MarginAsset] += pnl
+
+	switch {
+	case math.Abs(newAmount) < stepTolerance:
+		newAmount = 0
+		pos.EntryPrice = 0
+	case newAmount*pos.Amount < 0:
+		pos.EntryPrice = price
+	}
+	pos.Amount = newAmount
+}
+
+func (e *Exchange) newOrder(
+	symbol string,
+	quantity string,
+	price string,
+	stopPrice string,
+	side futures.SideType,
+	orderType futures.OrderType,
+	timeInForce futures.TimeInForceType,
+	reduceOnly bool,
+	positionSide futures.PositionSideType,
+	clientOrderID string,
+) *futures.Order {
+	orderID := e.nextOrderID
+	e.nextOrderID++
+	if clientOrderID == "" {
+		clientOrderID = fmt.Sprintf("sim-%d", orderID)
+	}
+
+	updateTime := e.now().UnixMilli()
+	order := &futures.Order{
+		Symbol:        symbol,
+		OrderID:       orderID,
+		ClientOrderID: clientOrderID,
+		Price:         price,
+		StopPrice:     stopPrice,
+		ReduceOnly:    reduceOnly,
+		OrigQuantity:  quantity,
+		TimeInForce:   timeInForce,
+		Type:          orderType,
+		Side:          side,
+		Time:          updateTime,
+		UpdateTime:    updateTime,
+		OrigType:      string(orderType),
+		PositionSide:  positionSide,
+	}
+	e.orders[clientOrderID] = order
+
+	return order
+}
+
+// fillOrder fills the whole quantity of an order at the price and publishes the trade.
+func (e *Exchange) fillOrder(symbolInfo futures.Symbol, order *futures.Order, qty float64, price float64) {
+	signedQty := qty
+	if order.Side == futures.SideTypeSell {
+		signedQty = -qty
+	}
+
+	pos := e.getPosition(order.Symbol, order.PositionSide)
+	e.fill(symbolInfo, pos, signedQty, price)
+
+	e.logger.Infow(
+		"Fill simulated order",
+		"clientOrderID", order.ClientOrderID,
+		"symbol", order.Symbol,
+		"side", order.Side,
+		"quantity", order.OrigQuantity,
+		"price", price,
+		"position", pos.Amount,
+		"entryPrice", pos.EntryPrice,
+		"balance", e.balances[symbolInfo.MarginAsset],
+	)
+
+	order.ExecutedQuantity = order.OrigQuantity
+	order.CumQuantity = order.OrigQuantity
+	order.CumQuote = formatFloat(qty * price)
+	order.AvgPrice = formatFloat(price)
+	order.Status = futures.OrderStatusTypeFilled
+	order.UpdateTime = e.now().UnixMilli()
+	e.publishOrderUpdate(order, futures.OrderExecutionTypeTrade, order.OrigQuantity, price)
+}
+
+func newCreateOrderResponse(order *futures.Order) *futures.CreateOrderResponse {
+	return &futures.CreateOrderResponse{
+		Symbol:           order.Symbol,
+		OrderID:          order.OrderID,
+		ClientOrderID:    order.ClientOrderID,
+		Price:            order.Price,
+		OrigQuantity:     order.OrigQuantity,
+		ExecutedQuantity: order.ExecutedQuantity,
+		CumQuote:         order.CumQuote,
+		ReduceOnly:       order.ReduceOnly,
+		Status:           order.Status,
+		StopPrice:        order.StopPrice,
+		TimeInForce:      order.TimeInForce,
+		Type:             order.Type,
+		Side:             order.Side,
+		UpdateTime:       order.UpdateTime,
+		AvgPrice:         order.AvgPrice,
+		PositionSide:     order.PositionSide,
+	}
+}
+
+// checkMinNotional rejects orders below the symbol's min notional unless they are reduce only.
+func checkMinNotional(symbolInfo futures.Symbol, notional float64, reduceOnly bool) error {
+	filter := symbolInfo.MinNotionalFilter()
+	if filter == nil || reduceOnly {
+		return nil
+	}
+
+	minNotional, _ := strconv.ParseFloat(filter.Notional, 64)
+	if notional < minNotional {
+		return &bcommon.APIError{
+			Code:    -4164,
+			Message: fmt.Sprintf("Order's notional must be no smaller than %s (unless you choose reduce only).", filter.Notional),
+		}
+	}
+	return nil
+}
+
+// getBook returns the simulated best bid and ask of a symbol, one tick size on each side of the price.
+func getBook(symbolInfo futures.Symbol, price float64) (float64, float64) {
+	tickSize := 0.0
+	if filter := symbolInfo.PriceFilter(); filter != nil {
+		tickSize, _ = strconv.ParseFloat(filter.TickSize, 64)
+	}
+	if tickSize <= 0 {
+		return price, price
+	}
+
+	mid := math.Round(price/tickSize) * tickSize
+	return mid - tickSize, mid + tickSize
+}
+
+// isTriggered returns whether the price reaches the stop price of an order, buy orders trigger as the price rises.
+func isTriggered(side futures.SideType, price float64, stopPrice float64) bool {
+	if side == futures.SideTypeBuy {
+		return price >= stopPrice
+	}
+	return price <= stopPrice
+}
+
+func (e *Exchange) publishOrderUpdate(
+	order *futures.Order, executionType futures.OrderExecutionType, lastFilledQty string, lastFilledPrice float64,
+) {
+	event := &futures.WsUserDataEvent{
+		Event:           futures.UserDataEventTypeOrderTradeUpdate,
+		Time:            order.UpdateTime,
+		TransactionTime: order.UpdateTime,
+		OrderTradeUpdate: futures.WsOrderTradeUpdate{
+			Symbol:               order.Symbol,
+			ClientOrderID:        order.ClientOrderID,
+			Side:                 order.Side,
+			Type:                 order.Type,
+			TimeInForce:          order.TimeInForce,
+			OriginalQty:          order.OrigQuantity,
+			OriginalPrice:        order.Price,
+			AveragePrice:         order.AvgPrice,
+			StopPrice:            order.StopPrice,
+			ExecutionType:        executionType,
+			Status:               order.Status,
+			ID:                   order.OrderID,
+			LastFilledQty:        lastFilledQty,
+			AccumulatedFilledQty: order.ExecutedQuantity,
+			LastFilledPrice:      formatFloat(lastFilledPrice),
+			TradeTime:            order.UpdateTime,
+			IsReduceOnly:         order.ReduceOnly,
+			PositionSide:         order.PositionSide,
+		},
+	}
+
+	select {
+	case e.events <- event:
+	default:
+		e.logger.Warnw("Drop order update as nobody listens to user data", "clientOrderID", order.ClientOrderID)
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,356 @@
+package simulator
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testMarketData struct{}
+
+func (testMarketData) GetExchangeInfo(_ context.Context) (*futures.ExchangeInfo, error) {
+	return &futures.ExchangeInfo{
+		Symbols: []futures.Symbol{
+			{
+				Symbol:            "ETHBUSD",
+				QuantityPrecision: 3,
+				PricePrecision:    2,
+				MarginAsset:       "BUSD",
+				Filters: []map[string]interface{}{
+					{"filterType": "MARKET_LOT_SIZE", "minQty": "0.001", "maxQty": "2000", "stepSize": "0.001"},
+					{"filterType": "PRICE_FILTER", "minPrice": "0.01", "maxPrice": "100000", "tickSize": "0.01"},
+					{"filterType": "MIN_NOTIONAL", "notional": "5"},
+				},
+			},
+		},
+	}, nil
+}
+
+func newTestExchange(t *testing.T, price float64) *Exchange {
+	e := New(testMarketData{}, StaticPrices{"ETHBUSD": price}, 4, map[string]float64{"BUSD": 1000})
+	_, err := e.GetExchangeInfo(context.Background())
+	require.NoError(t, err)
+	return e
+}
+
+func createMarketOrder(e *Exchange, quantity string, side futures.SideType, reduceOnly bool) (*futures.CreateOrderResponse, error) {
+	return e.CreateFutureOrder(
+		context.Background(), "ETHBUSD", quantity, "0", "", side,
+		futures.OrderTypeMarket, futures.TimeInForceTypeGTC, reduceOnly, "", "",
+	)
+}
+
+func parseFloat(t *testing.T, s string) float64 {
+	f, err := strconv.ParseFloat(s, 64)
+	require.NoError(t, err)
+	return f
+}
+
+func TestCreateFutureOrder(t *testing.T) {
+	e := newTestExchange(t, 1000)
+
+	resp, err := createMarketOrder(e, "0.5", futures.SideTypeSell, false)
+	require.NoError(t, err)
+	assert.Equal(t, futures.OrderStatusTypeFilled, resp.Status)
+	assert.Equal(t, "0.5", resp.ExecutedQuantity)
+	assert.Equal(t, "1000", resp.AvgPrice)
+
+	e.prices = StaticPrices{"ETHBUSD": 900}
+	_, err = createMarketOrder(e, "0.2", futures.SideTypeBuy, true)
+	require.NoError(t, err)
+
+	positions := e.Positions()
+	require.Len(t, positions, 1)
+	assert.InDelta(t, -0.3, positions[0].Amount, 1e-9)
+	assert.InDelta(t, 1000, positions[0].EntryPrice, 1e-9)
+	assert.InDelta(t, 20, positions[0].RealizedPnL, 1e-9)
+	// 1000 + 20 pnl - 0.2 fee (500 notional) - 0.072 fee (180 notional)
+	assert.InDelta(t, 1019.728, e.Balance("BUSD"), 1e-9)
+}
+
+func TestListenUserData(t *testing.T) {
+	e := newTestExchange(t, 1000)
+
+	eventC := make(chan *futures.WsUserDataEvent, 1)
+	_, _, stopC, err := e.ListenUserData(context.Background(), func(event *futures.WsUserDataEvent) {
+		eventC <- event
+	})
+	require.NoError(t, err)
+	defer close(stopC)
+
+	_, err = e.CreateFutureOrder(
+		context.Background(), "ETHBUSD", "0.5", "0", "", futures.SideTypeSell,
+		futures.OrderTypeMarket, futures.TimeInForceTypeGTC, false, "", "elm-1",
+	)
+	require.NoError(t, err)
+
+	event := <-eventC
+	assert.Equal(t, futures.UserDataEventTypeOrderTradeUpdate, event.Event)
+	assert.Equal(t, "elm-1", event.OrderTradeUpdate.ClientOrderID)
+	assert.Equal(t, "0.5", event.OrderTradeUpdate.AccumulatedFilledQty)
+
+	order, err := e.GetFutureOrder(context.Background(), "ETHBUSD", "elm-1")
+	require.NoError(t, err)
+	assert.Equal(t, futures.OrderStatusTypeFilled, order.Status)
+}
+
+func TestCreateFutureOrderFilters(t *testing.T) {
+	tests := []struct {
+		quantity   string
+		side       futures.SideType
+		reduceOnly bool
+		errCode    string
+	}{
+		{quantity: "0.0001", side: futures.SideTypeSell, errCode: "code=-1111"},
+		{quantity: "3000", side: futures.SideTypeSell, errCode: "code=-4005"},
+		{quantity: "0.004", side: futures.SideTypeSell, errCode: "code=-4164"},
+		{quantity: "0.1", side: futures.SideTypeBuy, reduceOnly: true, errCode: "code=-2022"},
+	}
+
+	for _, test := range tests {
+		e := newTestExchange(t, 1000)
+		_, err := createMarketOrder(e, test.quantity, test.side, test.reduceOnly)
+		require.Error(t, err)
+		assert.True(t, strings.Contains(err.Error(), test.errCode), err.Error())
+	}
+}
+
+func TestStopOrder(t *testing.T) {
+	e := newTestExchange(t, 1000)
+	createStopOrder := func(clientOrderID string, stopPrice string, price string) error {
+		_, err := e.CreateFutureOrder(
+			context.Background(), "ETHBUSD", "0.5", price, stopPrice, futures.SideTypeSell,
+			futures.OrderTypeStop, futures.TimeInForceTypeGTC, false, "", clientOrderID,
+		)
+		return err
+	}
+
+	err := createStopOrder("elm-1", "1010", "1005")
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "code=-2021"), err.Error())
+
+	require.NoError(t, createStopOrder("elm-1", "990", "985"))
+	require.NoError(t, createStopOrder("elm-2", "950", "945"))
+
+	tests := []struct {
+		price    float64
+		expected futures.OrderStatusType
+	}{
+		{price: 995, expected: futures.OrderStatusTypeNew},
+		// Triggered, but the price is below the limit.
+		{price: 980, expected: futures.OrderStatusTypeNew},
+		{price: 987, expected: futures.OrderStatusTypeFilled},
+	}
+	for _, test := range tests {
+		e.prices = StaticPrices{"ETHBUSD": test.price}
+		e.MatchOrders()
+		order, err := e.GetFutureOrder(context.Background(), "ETHBUSD", "elm-1")
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, order.Status)
+	}
+
+	positions := e.Positions()
+	require.Len(t, positions, 1)
+	assert.InDelta(t, -0.5, positions[0].Amount, 1e-9)
+	assert.InDelta(t, 987, positions[0].EntryPrice, 1e-9)
+
+	resp, err := e.CancelFutureOrder(context.Background(), "ETHBUSD", "elm-2")
+	require.NoError(t, err)
+	assert.Equal(t, futures.OrderStatusTypeCanceled, resp.Status)
+	assert.Equal(t, "0", resp.ExecutedQuantity)
+
+	_, err = e.CancelFutureOrder(context.Background(), "ETHBUSD", "elm-1")
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "code=-2011"), err.Error())
+}
+
+func TestLimitOrder(t *testing.T) {
+	e := newTestExchange(t, 1000)
+	createPostOnlyOrder := func(clientOrderID string, side futures.SideType, price string) error {
+		_, err := e.CreateFutureOrder(
+			context.Background(), "ETHBUSD", "0.5", price, "", side,
+			futures.OrderTypeLimit, futures.TimeInForceTypeGTX, false, "", clientOrderID,
+		)
+		return err
+	}
+
+	book, err := e.GetBookTicker(context.Background(), "ETHBUSD")
+	require.NoError(t, err)
+	assert.Equal(t, "999.99", book.BidPrice)
+	assert.Equal(t, "1000.01", book.AskPrice)
+
+	err = createPostOnlyOrder("elm-1", futures.SideTypeSell, book.BidPrice)
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "code=-5022"), err.Error())
+
+	require.NoError(t, createPostOnlyOrder("elm-1", futures.SideTypeSell, book.AskPrice))
+
+	tests := []struct {
+		price    float64
+		expected futures.OrderStatusType
+	}{
+		{price: 1000, expected: futures.OrderStatusTypeNew},
+		{price: 1000.02, expected: futures.OrderStatusTypeFilled},
+	}
+	for _, test := range tests {
+		e.prices = StaticPrices{"ETHBUSD": test.price}
+		e.MatchOrders()
+		order, err := e.GetFutureOrder(context.Background(), "ETHBUSD", "elm-1")
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, order.Status)
+	}
+
+	// Makers are filled at their limit.
+	positions := e.Positions()
+	require.Len(t, positions, 1)
+	assert.InDelta(t, -0.5, positions[0].Amount, 1e-9)
+	assert.InDelta(t, 1000.01, positions[0].EntryPrice, 1e-9)
+}
+
+func TestDepth(t *testing.T) {
+	e := newTestExchange(t, 1000)
+	e.SetDepth(0.5)
+
+	book, err := e.GetOrderBook(context.Background(), "ETHBUSD", 3)
+	require.NoError(t, err)
+	require.Len(t, book.Bids, 3)
+	require.Len(t, book.Asks, 3)
+	assert.Equal(t, futures.Bid{Price: "999.97", Quantity: "0.5"}, book.Bids[2])
+	assert.Equal(t, futures.Ask{Price: "1000.03", Quantity: "0.5"}, book.Asks[2])
+
+	// The order takes 0.5 at each of the first two levels and 0.25 at the third one.
+	_, err = createMarketOrder(e, "1.25", futures.SideTypeBuy, false)
+	require.NoError(t, err)
+	positions := e.Positions()
+	require.Len(t, positions, 1)
+	assert.InDelta(t, (0.5*1000.01+0.5*1000.02+0.25*1000.03)/1.25, positions[0].EntryPrice, 1e-9)
+}
+
+func TestAccount(t *testing.T) {
+	e := newTestExchange(t, 1000)
+
+	_, err := createMarketOrder(e, "0.5", futures.SideTypeSell, false)
+	require.NoError(t, err)
+
+	e.prices = StaticPrices{"ETHBUSD": 2000}
+	account, err := e.GetAccount(context.Background())
+	require.NoError(t, err)
+	// 1000 - 0.2 fee, -500 unrealized profit, 0.4% of 1000 notional
+	assert.InDelta(t, 999.8, parseFloat(t, account.TotalWalletBalance), 1e-9)
+	assert.InDelta(t, 499.8, parseFloat(t, account.TotalMarginBalance), 1e-9)
+	assert.InDelta(t, 4, parseFloat(t, account.TotalMaintMargin), 1e-9)
+
+	risks, err := e.GetPositionRisk(context.Background(), "ETHBUSD")
+	require.NoError(t, err)
+	require.Len(t, risks, 1)
+	assert.InDelta(t, (500+999.8)/0.502, parseFloat(t, risks[0].LiquidationPrice), 1e-9)
+
+	err = e.TransferToFutures(context.Background(), "BUSD", "200")
+	assert.Error(t, err)
+
+	e.SetSpotBalance("BUSD", 300)
+	err = e.TransferToFutures(context.Background(), "BUSD", "200")
+	require.NoError(t, err)
+	assert.InDelta(t, 1199.8, e.Balance("BUSD"), 1e-9)
+
+	risks, err = e.GetPositionRisk(context.Background(), "ETHBUSD")
+	require.NoError(t, err)
+	require.Len(t, risks, 1)
+	assert.InDelta(t, (500+1199.8)/0.502, parseFloat(t, risks[0].LiquidationPrice), 1e-9)
+}
+
+func TestLeverageAndMarginType(t *testing.T) {
+	e := newTestExchange(t, 1000)
+
+	risks, err := e.GetPositionRisk(context.Background(), "ETHBUSD")
+	require.NoError(t, err)
+	require.Len(t, risks, 1)
+	assert.Equal(t, "0", risks[0].PositionAmt)
+	assert.Equal(t, "20", risks[0].Leverage)
+	assert.Equal(t, "cross", risks[0].MarginType)
+
+	_, err = e.ChangeLeverage(context.Background(), "ETHBUSD", 200)
+	assert.Error(t, err)
+	_, err = e.ChangeLeverage(context.Background(), "ETHBUSD", 5)
+	require.NoError(t, err)
+	require.NoError(t, e.ChangeMarginType(context.Background(), "ETHBUSD", futures.MarginTypeIsolated))
+	assert.Error(t, e.ChangeMarginType(context.Background(), "ETHBUSD", futures.MarginTypeIsolated))
+
+	risks, err = e.GetPositionRisk(context.Background(), "ETHBUSD")
+	require.NoError(t, err)
+	require.Len(t, risks, 1)
+	assert.Equal(t, "5", risks[0].Leverage)
+	assert.Equal(t, "isolated", risks[0].MarginType)
+
+	// Margin type can't be changed with a position.
+	_, err = createMarketOrder(e, "0.5", futures.SideTypeSell, false)
+	require.NoError(t, err)
+	assert.Error(t, e.ChangeMarginType(context.Background(), "ETHBUSD", futures.MarginTypeCrossed))
+}
+
+func TestHedgeMode(t *testing.T) {
+	e := newTestExchange(t, 1000)
+	require.NoError(t, e.SetDualSidePosition(true))
+
+	createOrder := func(quantity string, side futures.SideType, reduceOnly bool, positionSide futures.PositionSideType) error {
+		_, err := e.CreateFutureOrder(
+			context.Background(), "ETHBUSD", quantity, "0", "", side,
+			futures.OrderTypeMarket, futures.TimeInForceTypeGTC, reduceOnly, positionSide, "",
+		)
+		return err
+	}
+
+	assert.Error(t, createOrder("0.5", futures.SideTypeSell, false, ""))
+	assert.Error(t, createOrder("0.5", futures.SideTypeBuy, true, futures.PositionSideTypeShort))
+	require.NoError(t, createOrder("0.5", futures.SideTypeSell, false, futures.PositionSideTypeShort))
+	require.NoError(t, createOrder("0.2", futures.SideTypeBuy, false, futures.PositionSideTypeLong))
+
+	risks, err := e.GetPositionRisk(context.Background(), "ETHBUSD")
+	require.NoError(t, err)
+	require.Len(t, risks, 2)
+	assert.Equal(t, string(futures.PositionSideTypeLong), risks[0].PositionSide)
+	assert.Equal(t, "0.2", risks[0].PositionAmt)
+	assert.Equal(t, string(futures.PositionSideTypeShort), risks[1].PositionSide)
+	assert.Equal(t, "-0.5", risks[1].PositionAmt)
+
+	// Buys of the SHORT side only close it.
+	assert.Error(t, createOrder("0.6", futures.SideTypeBuy, false, futures.PositionSideTypeShort))
+	require.NoError(t, createOrder("0.5", futures.SideTypeBuy, false, futures.PositionSideTypeShort))
+	assert.Error(t, e.SetDualSidePosition(false))
+
+	risks, err = e.GetPositionRisk(context.Background(), "ETHBUSD")
+	require.NoError(t, err)
+	require.Len(t, risks, 2)
+	assert.Equal(t, "0.2", risks[0].PositionAmt)
+	assert.Equal(t, "0", risks[1].PositionAmt)
+}
+
+func TestReplayPrices(t *testing.T) {
+	data := "timestamp,symbol,price\n100,ETHBUSD,1000\n110,ETHBUSD,1010\n120,ETHBUSD,990\n"
+	prices, err := NewReplayPrices(strings.NewReader(data), 2)
+	require.NoError(t, err)
+
+	start := prices.start
+	tests := []struct {
+		elapsed  time.Duration
+		expected float64
+	}{
+		{elapsed: 0, expected: 1000},
+		{elapsed: 4 * time.Second, expected: 1000},
+		{elapsed: 5 * time.Second, expected: 1010},
+		{elapsed: time.Hour, expected: 990},
+	}
+
+	for _, test := range tests {
+		prices.now = func() time.Time { return start.Add(test.elapsed) }
+		price, ok := prices.GetPrice("ethbusd")
+		assert.True(t, ok)
+		assert.Equal(t, test.expected, price)
+	}
+}
@@ -0,0 +1,113 @@
+package strategy
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+)
+
+const DynamicName = "dynamic"
+
+// Dynamic hedges the current token amounts of positions and rebalances when the price moves.
+// A rebalance is triggered when a delta is above the threshold, then the delta is hedged back to within the target band.
+// Thresholds in quote currency at mark price replace the thresholds in bps when they are set.
+// MinThresholdBps is the lower bound of the threshold whichever is used.
+type Dynamic struct {
+	ThresholdBps      int64
+	TargetBps         int64
+	ThresholdNotional float64
+	TargetNotional    float64
+	MinThresholdBps   int64
+}
+
+func (s Dynamic) Name() string {
+	return DynamicName
+}
+
+func (s Dynamic) Plan(in Input) (Plan, error) {
+	plan := Plan{PositionID: in.Position.ID, Strategy: s.Name()}
+
+	for tokenIndex := 0; tokenIndex < 2; tokenIndex++ {
+		target := newTarget(in, tokenIndex, in.Position.HedgeTarget(tokenIndex))
+		if in.Previous == nil {
+			if s.ThresholdBps >= Bps {
+				target.keep(fmt.Sprintf("new position with amount threshold of %d bps", s.ThresholdBps))
+			} else {
+				target.Reason = "hedge new position"
+			}
+		} else {
+			s.rebalance(in, &target)
+		}
+		plan.Targets = append(plan.Targets, target)
+	}
+
+	return plan, nil
+}
+
+func (s Dynamic) rebalance(in Input, target *Target) {
+	if target.Token.IsStable() {
+		target.Reason = "stable token"
+		return
+	}
+
+	if in.Position.IsClosed() {
+		target.Reason = "position is closed"
+		return
+	}
+
+	// A new hedge ratio, e.g. set by the operator or scaled down on margin, is applied in full.
+	if in.Previous.HedgeRatioBps != in.Position.HedgeRatioBps {
+		target.Reason = fmt.Sprintf(
+			"hedge ratio changes from %d to %d bps", in.Previous.HedgeRatioBps, in.Position.HedgeRatioBps,
+		)
+		return
+	}
+
+	amount := in.Position.Token(target.TokenIndex).Amount
+	maxAmount := in.Position.MaxAmount(target.TokenIndex)
+	if amount.Cmp(maxAmount) >= 0 || amount.Cmp(common.Big0) <= 0 {
+		target.Reason = "position is out of range"
+		return
+	}
+
+	triggerAmount, targetAmount := s.getBands(in, target, maxAmount)
+	minTriggerAmount := common.BigDiv(common.BigMul(maxAmount, big.NewInt(s.MinThresholdBps)), big.NewInt(Bps))
+	if triggerAmount.Cmp(minTriggerAmount) < 0 {
+		triggerAmount = minTriggerAmount
+	}
+
+	absDelta := common.BigAbs(target.Delta)
+	if absDelta.Cmp(triggerAmount) <= 0 {
+		target.keep(fmt.Sprintf("delta is within threshold %s", common.FormatAmount(triggerAmount, target.Token.Decimals, 5)))
+		return
+	}
+
+	if targetAmount.Cmp(common.Big0) <= 0 {
+		target.Reason = "delta is above threshold"
+		return
+	}
+
+	absDelta = common.BigSub(absDelta, targetAmount)
+	if target.Delta.Cmp(common.Big0) < 0 {
+		absDelta = common.BigNeg(absDelta)
+	}
+	target.Delta = absDelta
+	target.Reason = fmt.Sprintf("hedge back to target band %s", common.FormatAmount(targetAmount, target.Token.Decimals, 5))
+}
+
+// getBands returns the amounts which trigger a rebalance and which a rebalance hedges back to.
+func (s Dynamic) getBands(in Input, target *Target, maxAmount *big.Int) (*big.Int, *big.Int) {
+	decimals := target.Token.Decimals
+	if s.ThresholdNotional > 0 && in.Market != nil {
+		// Thresholds in bps are used when the mark price is unavailable.
+		markPrice, err := in.Market.MarkPrice(target.Symbol)
+		if err == nil && markPrice > 0 {
+			return common.FloatToAmount(s.ThresholdNotional/markPrice, decimals),
+				common.FloatToAmount(s.TargetNotional/markPrice, decimals)
+		}
+	}
+
+	return common.BigDiv(common.BigMul(maxAmount, big.NewInt(s.ThresholdBps)), big.NewInt(Bps)),
+		common.BigDiv(common.BigMul(maxAmount, big.NewInt(s.TargetBps)), big.NewInt(Bps))
+}
@@ -0,0 +1,126 @@
+package strategy
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"sort"
+
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+	"github.com/hiepnv90/elastic-lm/pkg/position"
+)
+
+const LadderName = "ladder"
+
+// Ladder hedges positions with a ladder of resting orders across their ranges, see LadderRungs.
+// The ladder follows the price by itself, so its plans only hedge new positions and deltas above a rung's size,
+// e.g. when the price gaps over rungs before they are filled.
+type Ladder struct {
+	Dynamic
+	Rungs int
+}
+
+func (s Ladder) Name() string {
+	return LadderName
+}
+
+func (s Ladder) Plan(in Input) (Plan, error) {
+	d := s.Dynamic
+	if s.Rungs > 0 && d.MinThresholdBps < Bps/int64(s.Rungs) {
+		d.MinThresholdBps = Bps / int64(s.Rungs)
+	}
+
+	plan, err := d.Plan(in)
+	plan.Strategy = s.Name()
+	return plan, err
+}
+
+// Rung is a resting order of a ladder, which hedges the amount of a position's volatile token
+// swapped by the pool while the price crosses the rung's ticks.
+type Rung struct {
+	TokenIndex int
+	TickLower  int
+	TickUpper  int
+	// Price is the price of the volatile token in the stable token at the middle of the rung.
+	Price float64
+	// Amount is the signed amount to add to the hedged amount when the price reaches the rung.
+	Amount *big.Int
+}
+
+// LadderRungs splits the range of a position into rungs of equal ticks, which are sized by the amount curve of its
+// volatile token, so the hedge grows as the volatile token's price falls and shrinks as it rises.
+// The rung containing the current tick is split at the tick, and rungs are sorted from the nearest to the current tick.
+// Only pools with one stable token are supported, as the prices of rungs are in the stable token.
+func LadderRungs(pos position.Position, rungs int) ([]Rung, error) {
+	if rungs <= 0 {
+		return nil, errors.New("number of rungs must be positive")
+	}
+	if pos.TickUpper <= pos.TickLower {
+		return nil, errors.New("invalid range of position")
+	}
+	if pos.Token0.IsStable() == pos.Token1.IsStable() {
+		return nil, errors.New("ladder needs a pool of one stable token and one volatile token")
+	}
+
+	tokenIndex := 0
+	if pos.Token0.IsStable() {
+		tokenIndex = 1
+	}
+
+	var res []Rung
+	width := pos.TickUpper - pos.TickLower
+	for i := 0; i < rungs; i++ {
+		tickLower := pos.TickLower + width*i/rungs
+		tickUpper := pos.TickLower + width*(i+1)/rungs
+		if pos.Tick > tickLower && pos.Tick < tickUpper {
+			res = appendRung(res, pos, tokenIndex, tickLower, pos.Tick)
+			res = appendRung(res, pos, tokenIndex, pos.Tick, tickUpper)
+			continue
+		}
+		res = appendRung(res, pos, tokenIndex, tickLower, tickUpper)
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return distance(res[i], pos.Tick) < distance(res[j], pos.Tick)
+	})
+	return res, nil
+}
+
+func appendRung(rungs []Rung, pos position.Position, tokenIndex int, tickLower int, tickUpper int) []Rung {
+	if tickUpper <= tickLower {
+		return rungs
+	}
+
+	lowerSqrtPrice := common.GetSqrtRatioAtTick(tickLower)
+	upperSqrtPrice := common.GetSqrtRatioAtTick(tickUpper)
+	// The price of token0 in token1 rises with the tick, so token0's amount grows below the current tick,
+	// and token1's amount grows above it.
+	amount := common.CalculateAmount0(lowerSqrtPrice, upperSqrtPrice, pos.Liquidity)
+	grows := tickUpper <= pos.Tick
+	if tokenIndex == 1 {
+		amount = common.CalculateAmount1(lowerSqrtPrice, upperSqrtPrice, pos.Liquidity)
+		grows = tickLower >= pos.Tick
+	}
+	amount = common.BigDiv(common.BigMul(amount, big.NewInt(pos.HedgeRatioBps)), big.NewInt(Bps))
+	if !grows {
+		amount = common.BigNeg(amount)
+	}
+
+	midTick := float64(tickLower+tickUpper) / 2
+	price := math.Pow(1.0001, midTick) * math.Pow10(pos.Token0.Decimals-pos.Token1.Decimals)
+	if tokenIndex == 1 {
+		price = 1 / price
+	}
+
+	return append(rungs, Rung{
+		TokenIndex: tokenIndex,
+		TickLower:  tickLower,
+		TickUpper:  tickUpper,
+		Price:      price,
+		Amount:     amount,
+	})
+}
+
+func distance(rung Rung, tick int) float64 {
+	return math.Abs(float64(rung.TickLower+rung.TickUpper)/2 - float64(tick))
+}
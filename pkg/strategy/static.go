@@ -0,0 +1,50 @@
+package strategy
+
+import (
+	"fmt"
+
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+)
+
+const StaticName = "static"
+
+// Static hedges the max token amounts of positions, which are the amounts at the edges of their ranges.
+// The hedge doesn't follow the price, it is adjusted when the hedged amounts, including orders waiting to be filled,
+// differ from the max amounts by more than the threshold of Dynamic, e.g. when the position's liquidity, range or
+// hedge ratio changes, or when an order fails. New positions are always hedged.
+type Static struct {
+	Dynamic
+}
+
+func (s Static) Name() string {
+	return StaticName
+}
+
+func (s Static) Plan(in Input) (Plan, error) {
+	plan := Plan{PositionID: in.Position.ID, Strategy: s.Name()}
+
+	for tokenIndex := 0; tokenIndex < 2; tokenIndex++ {
+		target := newTarget(in, tokenIndex, in.Position.MaxHedgeTarget(tokenIndex))
+		switch {
+		case in.Previous == nil:
+			target.Reason = "hedge max amount of new position"
+		case target.Token.IsStable():
+			target.Reason = "stable token"
+		case in.Position.IsClosed():
+			target.Reason = "position is closed"
+		default:
+			triggerAmount, _ := s.getBands(in, &target, in.Position.MaxAmount(tokenIndex))
+			if common.BigAbs(target.Delta).Cmp(triggerAmount) <= 0 {
+				target.keep(fmt.Sprintf(
+					"delta to max amount is within threshold %s",
+					common.FormatAmount(triggerAmount, target.Token.Decimals, 5),
+				))
+				break
+			}
+			target.Reason = "hedge max amount of range"
+		}
+		plan.Targets = append(plan.Targets, target)
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,77 @@
+package strategy
+
+import (
+	"math/big"
+
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+	"github.com/hiepnv90/elastic-lm/pkg/position"
+)
+
+// Bps is the denominator of amounts in basis points.
+const Bps = 10000
+
+// MarketData provides the market data which strategies decide on.
+type MarketData interface {
+	// MarkPrice returns the mark price of a perpetual symbol.
+	MarkPrice(symbol string) (float64, error)
+}
+
+// Input is a snapshot of a position and its hedge.
+type Input struct {
+	// Position is the latest information of the position, including its hedge ratio.
+	Position position.Position
+	// Previous is the information of the position when it was last planned, it is nil for new positions.
+	Previous *position.Position
+	// HedgedAmounts are the hedged amounts of token0 and token1, including orders waiting to be filled.
+	HedgedAmounts [2]*big.Int
+	// Symbols are the perpetual symbols hedging token0 and token1.
+	Symbols [2]string
+	Market  MarketData
+}
+
+// Target is the decision of a strategy on the hedge of a position's token.
+type Target struct {
+	Symbol     string
+	TokenIndex int
+	// Token is the position's token with the target hedged amount.
+	Token common.Token
+	// HedgedAmount is the current hedged amount of the token.
+	HedgedAmount *big.Int
+	// Delta is the amount to add to the hedged amount, it is zero when the hedge is kept.
+	Delta  *big.Int
+	Reason string
+}
+
+// Plan is the hedge plan of a position.
+// Tokens without a target are not evaluated, so their hedges and residuals are kept.
+type Plan struct {
+	PositionID string
+	Strategy   string
+	Targets    []Target
+	// Reason explains a plan without any target.
+	Reason string
+}
+
+// Strategy decides the hedge of a position without executing it.
+type Strategy interface {
+	Name() string
+	Plan(in Input) (Plan, error)
+}
+
+func newTarget(in Input, tokenIndex int, targetAmount *big.Int) Target {
+	token := in.Position.Token(tokenIndex)
+	token.Amount = targetAmount
+	return Target{
+		Symbol:       in.Symbols[tokenIndex],
+		TokenIndex:   tokenIndex,
+		Token:        token,
+		HedgedAmount: in.HedgedAmounts[tokenIndex],
+		Delta:        common.BigSub(targetAmount, in.HedgedAmounts[tokenIndex]),
+	}
+}
+
+// keep drops the target's delta, so the current hedge is kept.
+func (t *Target) keep(reason string) {
+	t.Delta = big.NewInt(0)
+	t.Reason = reason
+}
@@ -0,0 +1,313 @@
+package strategy
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/hiepnv90/elastic-lm/pkg/common"
+	"github.com/hiepnv90/elastic-lm/pkg/position"
+	"github.com/stretchr/testify/assert"
+)
+
+type staticMarket map[string]float64
+
+func (m staticMarket) MarkPrice(symbol string) (float64, error) {
+	price, ok := m[symbol]
+	if !ok {
+		return 0, errors.New("unknown symbol")
+	}
+	return price, nil
+}
+
+func newPosition(amount0 int64, liquidity int64) position.Position {
+	return position.Position{
+		ID:            "1",
+		Liquidity:     big.NewInt(liquidity),
+		TickLower:     -100,
+		TickUpper:     100,
+		MaxAmount0:    big.NewInt(100),
+		MaxAmount1:    big.NewInt(2000),
+		HedgedAmount0: big.NewInt(0),
+		HedgedAmount1: big.NewInt(0),
+		HedgeRatioBps: 10000,
+		Token0:        common.Token{Amount: big.NewInt(amount0), Symbol: "WETH", Decimals: 0},
+		Token1:        common.Token{Amount: big.NewInt(1000), Symbol: "USDC", Decimals: 0},
+	}
+}
+
+func newInput(pos position.Position, prev *position.Position, hedged0 int64) Input {
+	return Input{
+		Position:      pos,
+		Previous:      prev,
+		HedgedAmounts: [2]*big.Int{big.NewInt(hedged0), big.NewInt(0)},
+		Symbols:       [2]string{"ETHBUSD", "USDCBUSD"},
+		Market:        staticMarket{"ETHBUSD": 10},
+	}
+}
+
+func TestDynamicPlan(t *testing.T) {
+	prev := newPosition(50, 1)
+	reduced := newPosition(50, 1)
+	reduced.HedgeRatioBps = 8000
+	tests := []struct {
+		name     string
+		strategy Dynamic
+		in       Input
+		expected []int64
+	}{
+		{
+			name:     "hedge new position",
+			strategy: Dynamic{ThresholdBps: 100},
+			in:       newInput(newPosition(50, 1), nil, 0),
+			expected: []int64{50, 1000},
+		},
+		{
+			name:     "skip new position with threshold of 10000 bps",
+			strategy: Dynamic{ThresholdBps: 10000},
+			in:       newInput(newPosition(50, 1), nil, 0),
+			expected: []int64{0, 0},
+		},
+		{
+			name:     "keep delta within threshold",
+			strategy: Dynamic{ThresholdBps: 500},
+			in:       newInput(newPosition(54, 1), &prev, 50),
+			expected: []int64{0, 1000},
+		},
+		{
+			name:     "hedge delta above threshold",
+			strategy: Dynamic{ThresholdBps: 500},
+			in:       newInput(newPosition(44, 1), &prev, 50),
+			expected: []int64{-6, 1000},
+		},
+		{
+			name:     "hedge back to target band",
+			strategy: Dynamic{ThresholdBps: 500, TargetBps: 200},
+			in:       newInput(newPosition(44, 1), &prev, 50),
+			expected: []int64{-4, 1000},
+		},
+		{
+			name:     "keep delta within threshold in notional",
+			strategy: Dynamic{ThresholdBps: 100, ThresholdNotional: 100},
+			in:       newInput(newPosition(44, 1), &prev, 50),
+			expected: []int64{0, 1000},
+		},
+		{
+			name:     "hedge out of range position",
+			strategy: Dynamic{ThresholdBps: 5000},
+			in:       newInput(newPosition(100, 1), &prev, 50),
+			expected: []int64{50, 1000},
+		},
+		{
+			name:     "rebalance to new hedge ratio within threshold",
+			strategy: Dynamic{ThresholdBps: 5000},
+			in:       newInput(reduced, &prev, 50),
+			expected: []int64{-10, 800},
+		},
+	}
+
+	for _, test := range tests {
+		plan, err := test.strategy.Plan(test.in)
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, DynamicName, plan.Strategy, test.name)
+		assert.Len(t, plan.Targets, len(test.expected), test.name)
+		for i, target := range plan.Targets {
+			assert.Equal(t, big.NewInt(test.expected[i]), target.Delta, test.name)
+			assert.NotEmpty(t, target.Reason, test.name)
+		}
+	}
+}
+
+func TestStaticPlan(t *testing.T) {
+	prev := newPosition(50, 1)
+	halfRatio := newPosition(50, 1)
+	halfRatio.HedgeRatioBps = 5000
+	hedged := func(in Input, hedged1 int64) Input {
+		in.HedgedAmounts[1] = big.NewInt(hedged1)
+		return in
+	}
+	tests := []struct {
+		name     string
+		strategy Static
+		in       Input
+		expected []int64
+	}{
+		{
+			name:     "hedge max amounts of new position",
+			in:       newInput(newPosition(50, 1), nil, 0),
+			expected: []int64{100, 2000},
+		},
+		{
+			name:     "hedge max amounts of new position with threshold of 10000 bps",
+			strategy: Static{Dynamic: Dynamic{ThresholdBps: 10000}},
+			in:       newInput(newPosition(50, 1), nil, 0),
+			expected: []int64{100, 2000},
+		},
+		{
+			name:     "keep hedge of unchanged position",
+			in:       hedged(newInput(newPosition(20, 1), &prev, 100), 2000),
+			expected: []int64{0, 0},
+		},
+		{
+			name:     "hedge unchanged position which is under-hedged",
+			strategy: Static{Dynamic: Dynamic{ThresholdBps: 500}},
+			in:       hedged(newInput(newPosition(50, 1), &prev, 40), 2000),
+			expected: []int64{60, 0},
+		},
+		{
+			name:     "keep hedge of unchanged position within threshold",
+			strategy: Static{Dynamic: Dynamic{ThresholdBps: 500}},
+			in:       hedged(newInput(newPosition(50, 1), &prev, 96), 2000),
+			expected: []int64{0, 0},
+		},
+		{
+			name:     "keep hedge within threshold in notional",
+			strategy: Static{Dynamic: Dynamic{ThresholdBps: 100, ThresholdNotional: 100}},
+			in:       hedged(newInput(newPosition(50, 1), &prev, 91), 2000),
+			expected: []int64{0, 0},
+		},
+		{
+			name:     "move hedge of position switched from dynamic to max amounts",
+			strategy: Static{Dynamic: Dynamic{ThresholdBps: 100}},
+			in:       hedged(newInput(newPosition(50, 1), &prev, 50), 1000),
+			expected: []int64{50, 1000},
+		},
+		{
+			name:     "adjust hedge when hedge ratio changes",
+			in:       newInput(halfRatio, &prev, 100),
+			expected: []int64{-50, 1000},
+		},
+		{
+			name: "unwind hedge of closed position",
+			in: func() Input {
+				pos := newPosition(0, 0)
+				pos.MaxAmount0 = big.NewInt(0)
+				pos.MaxAmount1 = big.NewInt(0)
+				return newInput(pos, &prev, 100)
+			}(),
+			expected: []int64{-100, 0},
+		},
+	}
+
+	for _, test := range tests {
+		plan, err := test.strategy.Plan(test.in)
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, StaticName, plan.Strategy, test.name)
+		assert.Len(t, plan.Targets, len(test.expected), test.name)
+		for i, target := range plan.Targets {
+			assert.Equal(t, test.expected[i], target.Delta.Int64(), test.name)
+			assert.NotEmpty(t, target.Reason, test.name)
+		}
+	}
+}
+
+func TestLadderRungs(t *testing.T) {
+	liquidity := big.NewInt(100000000000000000)
+	tickLower, tickUpper := -207240, -199160
+	maxAmount0 := common.CalculateAmount0(
+		common.GetSqrtRatioAtTick(tickLower), common.GetSqrtRatioAtTick(tickUpper), liquidity,
+	)
+
+	tests := []struct {
+		name     string
+		tick     int
+		rungs    int
+		expected int
+	}{
+		{name: "rung boundary at current tick", tick: -203200, rungs: 10, expected: 10},
+		{name: "split rung at current tick", tick: -203000, rungs: 10, expected: 11},
+		{name: "below range", tick: -210000, rungs: 4, expected: 4},
+		{name: "above range", tick: -190000, rungs: 4, expected: 4},
+	}
+
+	for _, test := range tests {
+		pos := position.Position{
+			ID:            "1",
+			Liquidity:     liquidity,
+			TickLower:     tickLower,
+			TickUpper:     tickUpper,
+			Tick:          test.tick,
+			HedgeRatioBps: 10000,
+			Token0:        common.Token{Amount: big.NewInt(0), Symbol: "WETH", Decimals: 18},
+			Token1:        common.Token{Amount: big.NewInt(0), Symbol: "USDC", Decimals: 6},
+		}
+		rungs, err := LadderRungs(pos, test.rungs)
+		assert.NoError(t, err, test.name)
+		assert.Len(t, rungs, test.expected, test.name)
+
+		// Rungs below the current tick add up to the token0 amount which the position gains as the price falls
+		// to the lower tick, and rungs above it add up to the amount which it loses as the price rises.
+		below, above := big.NewInt(0), big.NewInt(0)
+		for i, rung := range rungs {
+			assert.Equal(t, 0, rung.TokenIndex, test.name)
+			if rung.TickUpper <= test.tick {
+				assert.True(t, rung.Amount.Sign() > 0, test.name)
+				below = common.BigAdd(below, rung.Amount)
+			} else {
+				assert.True(t, rung.Amount.Sign() < 0, test.name)
+				above = common.BigSub(above, rung.Amount)
+			}
+			if i > 0 {
+				assert.True(t, distance(rungs[i-1], test.tick) <= distance(rung, test.tick), test.name)
+			}
+		}
+		total := common.BigAdd(below, above)
+		assert.InDelta(t, common.AmountToFloat(maxAmount0, 18), common.AmountToFloat(total, 18), 1e-9, test.name)
+	}
+
+	pos := newPosition(50, 1)
+	pos.Token1.Symbol = "WBTC"
+	_, err := LadderRungs(pos, 10)
+	assert.Error(t, err)
+}
+
+func TestLadderRungPrices(t *testing.T) {
+	pos := position.Position{
+		Liquidity:     big.NewInt(100000000000000000),
+		TickLower:     -207240,
+		TickUpper:     -199160,
+		Tick:          -203200,
+		HedgeRatioBps: 10000,
+		Token0:        common.Token{Amount: big.NewInt(0), Symbol: "WETH", Decimals: 18},
+		Token1:        common.Token{Amount: big.NewInt(0), Symbol: "USDC", Decimals: 6},
+	}
+	rungs, err := LadderRungs(pos, 2)
+	assert.NoError(t, err)
+	for _, rung := range rungs {
+		// The price of WETH is about 1500 USDC at the current tick.
+		if rung.Amount.Sign() > 0 {
+			assert.True(t, rung.Price < 1500 && rung.Price > 900, rung.Price)
+		} else {
+			assert.True(t, rung.Price > 1500 && rung.Price < 2500, rung.Price)
+		}
+	}
+
+	// Prices are of the volatile token when it is token1.
+	pos.Token0, pos.Token1 = pos.Token1, pos.Token0
+	pos.Token0.Decimals, pos.Token1.Decimals = 18, 6
+	rungs, err = LadderRungs(pos, 2)
+	assert.NoError(t, err)
+	for _, rung := range rungs {
+		assert.Equal(t, 1, rung.TokenIndex)
+		if rung.TickLower >= pos.Tick {
+			assert.True(t, rung.Amount.Sign() > 0)
+		} else {
+			assert.True(t, rung.Amount.Sign() < 0)
+		}
+	}
+}
+
+func TestLadderPlan(t *testing.T) {
+	prev := newPosition(50, 1)
+	s := Ladder{Dynamic: Dynamic{ThresholdBps: 100}, Rungs: 10}
+
+	// Deltas within a rung are left to the ladder.
+	plan, err := s.Plan(newInput(newPosition(42, 1), &prev, 50))
+	assert.NoError(t, err)
+	assert.Equal(t, LadderName, plan.Strategy)
+	assert.Equal(t, big.NewInt(0), plan.Targets[0].Delta)
+
+	plan, err = s.Plan(newInput(newPosition(38, 1), &prev, 50))
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewInt(-12), plan.Targets[0].Delta)
+}